@@ -1,2 +1,3 @@
 #!/bin/bash
-docker build --tag voter-api-basic:v2  -f ./dockerfile.better .
+#Build from the repo root so the shared voter-api packages are in the context
+docker build --tag voter-api-basic:v2 --build-arg VERSION=$(git describe --always --dirty) -f ./dockerfile.better ..
@@ -0,0 +1,3 @@
+#!/bin/bash
+#Build from the repo root so the shared voter-api packages are in the context
+docker build --tag voter-api-distroless:v2 --build-arg VERSION=$(git describe --always --dirty) -f ./dockerfile.distroless ..
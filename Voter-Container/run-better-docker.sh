@@ -1,2 +1,4 @@
 #!/bin/bash
-docker run -it --rm --name better-voter -p 1080:1080 voter-api-basic:v2
\ No newline at end of file
+#For a container to get to redis on the host machine, you reference the
+#host machine by using host.docker.internal (at least in docker desktop)
+docker run -it --rm --name better-voter -p 1080:1080 -e REDIS_URL=host.docker.internal:6379 voter-api-basic:v2
\ No newline at end of file
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"drexel.edu/polls/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// The api package creates and maintains a reference to the data handler
+// this is a good design practice
+type PollAPI struct {
+	db       db.PollStore
+	bootTime time.Time
+}
+
+func New(storeType string) (*PollAPI, error) {
+	dbHandler, err := db.NewPollStore(storeType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PollAPI{db: dbHandler, bootTime: time.Now()}, nil
+}
+
+func paramId(c *fiber.Ctx, name string) (uint, error) {
+	id, err := strconv.ParseUint(c.Params(name), 10, 64)
+	if err != nil {
+		return 0, fiber.NewError(http.StatusBadRequest)
+	}
+	return uint(id), nil
+}
+
+func (pa *PollAPI) ListAllPolls(c *fiber.Ctx) error {
+
+	pollList, err := pa.db.GetAllPolls()
+	if err != nil {
+		log.Println("Error Getting All Polls: ", err)
+		return fiber.NewError(http.StatusNotFound,
+			"Error Getting All Polls")
+	}
+	//Return [] rather than null when there are no polls
+	if pollList == nil {
+		pollList = make([]db.Poll, 0)
+	}
+
+	return c.JSON(pollList)
+}
+
+func (pa *PollAPI) GetPoll(c *fiber.Ctx) error {
+	id, err := paramId(c, "id")
+	if err != nil {
+		return err
+	}
+
+	poll, err := pa.db.GetPoll(id)
+	if err != nil {
+		log.Println("Poll not found: ", err)
+		return fiber.NewError(http.StatusNotFound)
+	}
+
+	return c.JSON(poll)
+}
+
+func (pa *PollAPI) AddPoll(c *fiber.Ctx) error {
+	var poll db.Poll
+
+	if err := c.BodyParser(&poll); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if err := pa.db.AddPoll(poll); err != nil {
+		log.Println("Error adding poll: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.JSON(poll)
+}
+
+func (pa *PollAPI) UpdatePoll(c *fiber.Ctx) error {
+	id, err := paramId(c, "id")
+	if err != nil {
+		return err
+	}
+
+	var poll db.Poll
+	if err := c.BodyParser(&poll); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if err := pa.db.UpdatePoll(id, poll); err != nil {
+		log.Println("Error updating poll: ", err)
+		return fiber.NewError(http.StatusNotFound)
+	}
+
+	return c.JSON(poll)
+}
+
+func (pa *PollAPI) DeletePoll(c *fiber.Ctx) error {
+	id, err := paramId(c, "id")
+	if err != nil {
+		return err
+	}
+
+	if err := pa.db.DeletePoll(id); err != nil {
+		log.Println("Error deleting poll: ", err)
+		return fiber.NewError(http.StatusNotFound)
+	}
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
+
+func (pa *PollAPI) DeleteAllPolls(c *fiber.Ctx) error {
+
+	if err := pa.db.DeleteAll(); err != nil {
+		log.Println("Error deleting all polls: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.Status(http.StatusOK).SendString("Delete All OK")
+}
+
+func (pa *PollAPI) GetPollOptions(c *fiber.Ctx) error {
+	id, err := paramId(c, "id")
+	if err != nil {
+		return err
+	}
+
+	options, err := pa.db.GetPollOptions(id)
+	if err != nil {
+		log.Println("Poll not found: ", err)
+		return fiber.NewError(http.StatusNotFound)
+	}
+	if options == nil {
+		options = make([]db.PollOption, 0)
+	}
+
+	return c.JSON(options)
+}
+
+func (pa *PollAPI) GetPollOption(c *fiber.Ctx) error {
+	id, err := paramId(c, "id")
+	if err != nil {
+		return err
+	}
+	optionId, err := paramId(c, "optionid")
+	if err != nil {
+		return err
+	}
+
+	option, err := pa.db.GetPollOption(id, optionId)
+	if err != nil {
+		log.Println("Poll option not found: ", err)
+		return fiber.NewError(http.StatusNotFound)
+	}
+
+	return c.JSON(option)
+}
+
+func (pa *PollAPI) AddPollOption(c *fiber.Ctx) error {
+	id, err := paramId(c, "id")
+	if err != nil {
+		return err
+	}
+
+	var option db.PollOption
+	if err := c.BodyParser(&option); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if err := pa.db.AddPollOption(id, option); err != nil {
+		log.Println("Error adding poll option: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.JSON(option)
+}
+
+func (pa *PollAPI) UpdatePollOption(c *fiber.Ctx) error {
+	id, err := paramId(c, "id")
+	if err != nil {
+		return err
+	}
+	optionId, err := paramId(c, "optionid")
+	if err != nil {
+		return err
+	}
+
+	var option db.PollOption
+	if err := c.BodyParser(&option); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+
+	if err := pa.db.UpdatePollOption(id, optionId, option); err != nil {
+		log.Println("Error updating poll option: ", err)
+		return fiber.NewError(http.StatusNotFound)
+	}
+
+	return c.JSON(option)
+}
+
+func (pa *PollAPI) DeletePollOption(c *fiber.Ctx) error {
+	id, err := paramId(c, "id")
+	if err != nil {
+		return err
+	}
+	optionId, err := paramId(c, "optionid")
+	if err != nil {
+		return err
+	}
+
+	if err := pa.db.DeletePollOption(id, optionId); err != nil {
+		log.Println("Error deleting poll option: ", err)
+		return fiber.NewError(http.StatusNotFound)
+	}
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
+
+// implementation of GET /polls/health
+func (pa *PollAPI) HealthCheck(c *fiber.Ctx) error {
+	uptime := time.Since(pa.bootTime)
+
+	return c.Status(http.StatusOK).
+		JSON(fiber.Map{
+			"status":  "ok",
+			"version": "1.0.0",
+			"uptime":  uptime.Seconds(),
+		})
+}
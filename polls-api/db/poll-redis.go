@@ -0,0 +1,259 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	RedisNilError        = "redis: nil"
+	RedisDefaultLocation = "0.0.0.0:6379"
+	RedisKeyPrefix       = "poll:"
+)
+
+type cache struct {
+	client  *redis.Client
+	context context.Context
+}
+
+// PollCache is the redis backed PollStore.  Polls are stored as RedisJSON
+// documents under poll:<id>
+type PollCache struct {
+	cache
+}
+
+func NewPollCache() (*PollCache, error) {
+	redisUrl := os.Getenv("REDIS_URL")
+	if redisUrl == "" {
+		redisUrl = RedisDefaultLocation
+	}
+	return NewWithCacheInstance(redisUrl)
+}
+
+func NewWithCacheInstance(location string) (*PollCache, error) {
+
+	client := redis.NewClient(&redis.Options{
+		Addr: location,
+	})
+
+	ctx := context.TODO()
+
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		log.Println("Error connecting to redis" + err.Error())
+		return nil, err
+	}
+
+	return &PollCache{
+		cache: cache{
+			client:  client,
+			context: ctx,
+		},
+	}, nil
+}
+
+//------------------------------------------------------------
+// REDIS HELPERS
+//------------------------------------------------------------
+
+func isRedisNilError(err error) bool {
+	return errors.Is(err, redis.Nil) || err.Error() == RedisNilError
+}
+
+func redisKeyFromId(id uint) string {
+	return fmt.Sprintf("%s%d", RedisKeyPrefix, id)
+}
+
+func (p *PollCache) getAllKeys() ([]string, error) {
+	key := fmt.Sprintf("%s*", RedisKeyPrefix)
+	return p.client.Keys(p.context, key).Result()
+}
+
+func (p *PollCache) upsertPoll(poll *Poll) error {
+	return p.client.JSONSet(p.context, redisKeyFromId(poll.PollId), ".", poll).Err()
+}
+
+func (p *PollCache) getPollFromRedis(key string, poll *Poll) error {
+	pollJson, err := p.client.JSONGet(p.context, key, ".").Result()
+	if err != nil {
+		if isRedisNilError(err) {
+			return errors.New("poll does not exist")
+		}
+		return err
+	}
+	if pollJson == "" {
+		return errors.New("poll does not exist")
+	}
+	return json.Unmarshal([]byte(pollJson), poll)
+}
+
+func (p *PollCache) doesKeyExist(id uint) bool {
+	kc, _ := p.client.Exists(p.context, redisKeyFromId(id)).Result()
+	return kc > 0
+}
+
+// optionIndex looks up the array position of an option so it can be
+// addressed with a JSON path like $.poll_options[2]
+func (p *PollCache) optionIndex(id, optionId uint) (int, error) {
+	options, err := p.GetPollOptions(id)
+	if err != nil {
+		return -1, err
+	}
+
+	i := findOption(options, optionId)
+	if i < 0 {
+		return -1, errors.New("poll option not found")
+	}
+	return i, nil
+}
+
+//------------------------------------------------------------
+// REDIS HELPERS-END
+//------------------------------------------------------------
+
+// AddPoll writes the poll with JSON.SET NX, which only sets the key if it
+// does not exist, so two adds of the same id can't both succeed
+func (p *PollCache) AddPoll(poll Poll) error {
+	if poll.PollOptions == nil {
+		poll.PollOptions = make([]PollOption, 0)
+	}
+
+	//NX answers nil rather than OK when the key is already there
+	err := p.client.JSONSetMode(p.context, redisKeyFromId(poll.PollId), "$", &poll, "NX").Err()
+	if err != nil && isRedisNilError(err) {
+		return fmt.Errorf("poll with id %d already exists", poll.PollId)
+	}
+	return err
+}
+
+func (p *PollCache) GetPoll(id uint) (Poll, error) {
+	var poll Poll
+	if err := p.getPollFromRedis(redisKeyFromId(id), &poll); err != nil {
+		return Poll{}, err
+	}
+	return poll, nil
+}
+
+func (p *PollCache) GetAllPolls() ([]Poll, error) {
+	keyList, err := p.getAllKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	resList := make([]Poll, len(keyList))
+	for idx, k := range keyList {
+		if err := p.getPollFromRedis(k, &resList[idx]); err != nil {
+			return nil, err
+		}
+	}
+
+	return resList, nil
+}
+
+func (p *PollCache) UpdatePoll(id uint, poll Poll) error {
+	if !p.doesKeyExist(id) {
+		return fmt.Errorf("poll with id %d does not exist", id)
+	}
+	if poll.PollOptions == nil {
+		poll.PollOptions = make([]PollOption, 0)
+	}
+	poll.PollId = id
+	return p.upsertPoll(&poll)
+}
+
+func (p *PollCache) DeletePoll(id uint) error {
+	if !p.doesKeyExist(id) {
+		return fmt.Errorf("poll with id %d does not exist", id)
+	}
+	return p.client.Del(p.context, redisKeyFromId(id)).Err()
+}
+
+func (p *PollCache) DeleteAll() error {
+	keyList, err := p.getAllKeys()
+	if err != nil {
+		return err
+	}
+	if len(keyList) == 0 {
+		return nil
+	}
+	return p.client.Del(p.context, keyList...).Err()
+}
+
+func (p *PollCache) GetPollOptions(id uint) ([]PollOption, error) {
+	optionsJson, err := p.client.JSONGet(p.context, redisKeyFromId(id), "$.poll_options").Result()
+	if err != nil {
+		if isRedisNilError(err) {
+			return []PollOption{}, errors.New("poll does not exist")
+		}
+		return []PollOption{}, err
+	}
+	if optionsJson == "" {
+		return []PollOption{}, errors.New("poll does not exist")
+	}
+
+	//A $ path always returns an array of matches, we only have one
+	var matches [][]PollOption
+	if err := json.Unmarshal([]byte(optionsJson), &matches); err != nil {
+		return []PollOption{}, err
+	}
+	if len(matches) == 0 {
+		return []PollOption{}, nil
+	}
+	return matches[0], nil
+}
+
+func (p *PollCache) GetPollOption(id, optionId uint) (PollOption, error) {
+	options, err := p.GetPollOptions(id)
+	if err != nil {
+		return PollOption{}, err
+	}
+
+	i := findOption(options, optionId)
+	if i < 0 {
+		return PollOption{}, errors.New("poll option not found")
+	}
+	return options[i], nil
+}
+
+func (p *PollCache) AddPollOption(id uint, option PollOption) error {
+	if !p.doesKeyExist(id) {
+		return fmt.Errorf("poll with id %d does not exist", id)
+	}
+	if _, err := p.optionIndex(id, option.PollOptionId); err == nil {
+		return errors.New("poll option already exists")
+	}
+
+	//Unlike JSONSet, JSONArrAppend expects values that are already json
+	optionJson, err := json.Marshal(option)
+	if err != nil {
+		return err
+	}
+	return p.client.JSONArrAppend(p.context, redisKeyFromId(id), "$.poll_options", string(optionJson)).Err()
+}
+
+func (p *PollCache) UpdatePollOption(id, optionId uint, option PollOption) error {
+	i, err := p.optionIndex(id, optionId)
+	if err != nil {
+		return err
+	}
+	//The path names the option, a different id in the body would leave
+	//two options with the same one
+	option.PollOptionId = optionId
+	path := fmt.Sprintf("$.poll_options[%d]", i)
+	return p.client.JSONSet(p.context, redisKeyFromId(id), path, &option).Err()
+}
+
+func (p *PollCache) DeletePollOption(id, optionId uint) error {
+	i, err := p.optionIndex(id, optionId)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("$.poll_options[%d]", i)
+	return p.client.JSONDel(p.context, redisKeyFromId(id), path).Err()
+}
@@ -0,0 +1,253 @@
+package db
+
+import (
+	"errors"
+	"sync"
+)
+
+type PollOption struct {
+	PollOptionId    uint   `json:"poll_option_id"`
+	PollOptionValue string `json:"poll_option_value"`
+}
+
+type Poll struct {
+	PollId       uint         `json:"poll_id"`
+	PollTitle    string       `json:"poll_title"`
+	PollQuestion string       `json:"poll_question"`
+	PollOptions  []PollOption `json:"poll_options"`
+}
+
+// PollStore is implemented by every backend that can hold polls.  The
+// api package only talks to this interface so the in-memory and redis
+// stores can be swapped with a command line flag
+type PollStore interface {
+	AddPoll(poll Poll) error
+	GetPoll(id uint) (Poll, error)
+	GetAllPolls() ([]Poll, error)
+	UpdatePoll(id uint, poll Poll) error
+	DeletePoll(id uint) error
+	DeleteAll() error
+
+	GetPollOptions(id uint) ([]PollOption, error)
+	GetPollOption(id, optionId uint) (PollOption, error)
+	AddPollOption(id uint, option PollOption) error
+	UpdatePollOption(id, optionId uint, option PollOption) error
+	DeletePollOption(id, optionId uint) error
+}
+
+const (
+	StoreMemory = "memory"
+	StoreRedis  = "redis"
+)
+
+// NewPollStore returns the backend named by storeType, either
+// StoreMemory or StoreRedis
+func NewPollStore(storeType string) (PollStore, error) {
+	switch storeType {
+	case StoreMemory, "":
+		return NewPollList()
+	case StoreRedis:
+		return NewPollCache()
+	default:
+		return nil, errors.New("unknown store type: " + storeType)
+	}
+}
+
+// PollList is the in-memory PollStore.  Fiber runs handlers on many
+// goroutines so every access to the map goes through the mutex
+type PollList struct {
+	mu    sync.RWMutex
+	Polls map[uint]Poll //A map of PollIDs as keys and Poll structs as values
+}
+
+func NewPollList() (*PollList, error) {
+
+	pollList := &PollList{
+		Polls: make(map[uint]Poll),
+	}
+
+	return pollList, nil
+}
+
+// cloneOptions copies the options so the slice we keep in the map never
+// shares its backing array with one a caller holds
+func cloneOptions(o []PollOption) []PollOption {
+	if o == nil {
+		return nil
+	}
+	return append(make([]PollOption, 0, len(o)), o...)
+}
+
+func (p Poll) clone() Poll {
+	p.PollOptions = cloneOptions(p.PollOptions)
+	return p
+}
+
+func findOption(options []PollOption, optionId uint) int {
+	for i, option := range options {
+		if option.PollOptionId == optionId {
+			return i
+		}
+	}
+	return -1
+}
+
+func (p *PollList) AddPoll(poll Poll) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	//Before we add a poll, make sure it does not exist already
+	if _, ok := p.Polls[poll.PollId]; ok {
+		return errors.New("poll already exists")
+	}
+
+	p.Polls[poll.PollId] = poll.clone()
+	return nil
+}
+
+func (p *PollList) GetPoll(id uint) (Poll, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	poll, ok := p.Polls[id]
+	if !ok {
+		return Poll{}, errors.New("poll does not exist")
+	}
+
+	return poll.clone(), nil
+}
+
+func (p *PollList) GetAllPolls() ([]Poll, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var pollList []Poll
+	for _, poll := range p.Polls {
+		pollList = append(pollList, poll.clone())
+	}
+
+	return pollList, nil
+}
+
+func (p *PollList) UpdatePoll(id uint, poll Poll) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.Polls[id]; !ok {
+		return errors.New("poll does not exist")
+	}
+
+	//The path names the poll, not the body
+	poll.PollId = id
+	p.Polls[id] = poll.clone()
+	return nil
+}
+
+func (p *PollList) DeletePoll(id uint) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.Polls[id]; !ok {
+		return errors.New("poll does not exist")
+	}
+
+	delete(p.Polls, id)
+	return nil
+}
+
+func (p *PollList) DeleteAll() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Polls = make(map[uint]Poll)
+	return nil
+}
+
+func (p *PollList) GetPollOptions(id uint) ([]PollOption, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	poll, ok := p.Polls[id]
+	if !ok {
+		return []PollOption{}, errors.New("poll does not exist")
+	}
+
+	return cloneOptions(poll.PollOptions), nil
+}
+
+func (p *PollList) GetPollOption(id, optionId uint) (PollOption, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	poll, ok := p.Polls[id]
+	if !ok {
+		return PollOption{}, errors.New("poll does not exist")
+	}
+
+	i := findOption(poll.PollOptions, optionId)
+	if i < 0 {
+		return PollOption{}, errors.New("poll option not found")
+	}
+
+	return poll.PollOptions[i], nil
+}
+
+func (p *PollList) AddPollOption(id uint, option PollOption) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	poll, ok := p.Polls[id]
+	if !ok {
+		return errors.New("poll does not exist")
+	}
+
+	if findOption(poll.PollOptions, option.PollOptionId) >= 0 {
+		return errors.New("poll option already exists")
+	}
+
+	poll.PollOptions = append(cloneOptions(poll.PollOptions), option)
+	p.Polls[id] = poll
+	return nil
+}
+
+func (p *PollList) UpdatePollOption(id, optionId uint, option PollOption) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	poll, ok := p.Polls[id]
+	if !ok {
+		return errors.New("poll does not exist")
+	}
+
+	i := findOption(poll.PollOptions, optionId)
+	if i < 0 {
+		return errors.New("poll option not found")
+	}
+
+	//The path names the option, a different id in the body would leave
+	//two options with the same one
+	option.PollOptionId = optionId
+	poll.PollOptions = cloneOptions(poll.PollOptions)
+	poll.PollOptions[i] = option
+	p.Polls[id] = poll
+	return nil
+}
+
+func (p *PollList) DeletePollOption(id, optionId uint) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	poll, ok := p.Polls[id]
+	if !ok {
+		return errors.New("poll does not exist")
+	}
+
+	i := findOption(poll.PollOptions, optionId)
+	if i < 0 {
+		return errors.New("poll option not found")
+	}
+
+	poll.PollOptions = append(cloneOptions(poll.PollOptions[:i]), poll.PollOptions[i+1:]...)
+	p.Polls[id] = poll
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"drexel.edu/polls/api"
+	"drexel.edu/polls/db"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+)
+
+var (
+	hostFlag  string
+	portFlag  uint
+	storeFlag string
+)
+
+func processCmdLineFlags() {
+
+	flag.StringVar(&hostFlag, "h", "0.0.0.0", "Listen on all interfaces")
+	flag.UintVar(&portFlag, "p", 1081, "Default Port")
+	flag.StringVar(&storeFlag, "store", db.StoreMemory, "Poll store to use, memory or redis")
+
+	flag.Parse()
+}
+
+// main is the entry point for our polls API application.  It processes
+// the command line flags, picks a poll store and registers the routes
+func main() {
+	processCmdLineFlags()
+
+	app := fiber.New()
+	app.Use(cors.New())
+	app.Use(recover.New())
+
+	apiHandler, err := api.New(storeFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	app.Get("/polls", apiHandler.ListAllPolls)
+	app.Post("/polls", apiHandler.AddPoll)
+	app.Delete("/polls", apiHandler.DeleteAllPolls)
+	app.Get("/polls/:id<int>", apiHandler.GetPoll)
+	app.Put("/polls/:id<int>", apiHandler.UpdatePoll)
+	app.Delete("/polls/:id<int>", apiHandler.DeletePoll)
+	app.Get("/polls/:id<int>/options", apiHandler.GetPollOptions)
+	app.Post("/polls/:id<int>/options", apiHandler.AddPollOption)
+	app.Get("/polls/:id<int>/options/:optionid<int>", apiHandler.GetPollOption)
+	app.Put("/polls/:id<int>/options/:optionid<int>", apiHandler.UpdatePollOption)
+	app.Delete("/polls/:id<int>/options/:optionid<int>", apiHandler.DeletePollOption)
+
+	app.Get("/polls/health", apiHandler.HealthCheck)
+
+	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
+	log.Println("Starting server on ", serverPath)
+	app.Listen(serverPath)
+}
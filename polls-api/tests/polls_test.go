@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"testing"
+
+	"drexel.edu/polls/db"
+	fake "github.com/brianvoe/gofakeit/v6" //aliasing package name
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	BASE_API = "http://localhost:1081"
+
+	cli = resty.New()
+)
+
+func TestMain(m *testing.M) {
+
+	//SETUP GOES FIRST
+	rsp, err := cli.R().Delete(BASE_API + "/polls")
+
+	if err != nil || rsp.StatusCode() != 200 {
+		log.Printf("error clearing database, %v", err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+
+	//Now Exit
+	os.Exit(code)
+}
+
+func newRandPoll(id uint) db.Poll {
+	return db.Poll{
+		PollId:       id,
+		PollTitle:    fake.HipsterWord(),
+		PollQuestion: fake.Question(),
+		PollOptions: []db.PollOption{
+			{PollOptionId: 1, PollOptionValue: fake.Animal()},
+			{PollOptionId: 2, PollOptionValue: fake.Animal()},
+		},
+	}
+}
+
+func Test_LoadDB(t *testing.T) {
+	numLoad := 3
+	for i := 0; i < numLoad; i++ {
+		rsp, err := cli.R().
+			SetBody(newRandPoll(uint(i))).
+			Post(BASE_API + "/polls")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+	}
+}
+
+func Test_Health(t *testing.T) {
+	rsp, err := cli.R().Get(BASE_API + "/polls/health")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_GetAllPolls(t *testing.T) {
+	var polls []db.Poll
+
+	rsp, err := cli.R().SetResult(&polls).Get(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 3, len(polls))
+}
+
+func Test_GetPollByID(t *testing.T) {
+	rsp, err := cli.R().Get(BASE_API + "/polls/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	var poll db.Poll
+	err = json.Unmarshal(rsp.Body(), &poll)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), poll.PollId)
+	assert.NotEmpty(t, poll.PollQuestion)
+	assert.Equal(t, 2, len(poll.PollOptions))
+}
+
+func Test_UpdatePoll(t *testing.T) {
+	updated := db.Poll{
+		PollId:       1,
+		PollTitle:    "Favorite Pet",
+		PollQuestion: "What type of pet do you like best?",
+		PollOptions: []db.PollOption{
+			{PollOptionId: 1, PollOptionValue: "Dog"},
+			{PollOptionId: 2, PollOptionValue: "Cat"},
+		},
+	}
+
+	rsp, err := cli.R().SetBody(updated).Put(BASE_API + "/polls/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	var poll db.Poll
+	rsp, err = cli.R().SetResult(&poll).Get(BASE_API + "/polls/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, updated.PollQuestion, poll.PollQuestion)
+}
+
+func Test_PollOptions(t *testing.T) {
+	option := db.PollOption{PollOptionId: 3, PollOptionValue: "Fish"}
+
+	rsp, err := cli.R().SetBody(option).Post(BASE_API + "/polls/1/options")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	var options []db.PollOption
+	rsp, err = cli.R().SetResult(&options).Get(BASE_API + "/polls/1/options")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 3, len(options))
+
+	option.PollOptionValue = "Goldfish"
+	rsp, err = cli.R().SetBody(option).Put(BASE_API + "/polls/1/options/3")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	var got db.PollOption
+	rsp, err = cli.R().SetResult(&got).Get(BASE_API + "/polls/1/options/3")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, "Goldfish", got.PollOptionValue)
+
+	rsp, err = cli.R().Delete(BASE_API + "/polls/1/options/3")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().Get(BASE_API + "/polls/1/options/3")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode())
+}
+
+// Test_PathIdsWin checks the ids in the path are the ones stored, a body
+// naming another poll or option can't move or duplicate it
+func Test_PathIdsWin(t *testing.T) {
+	rsp, err := cli.R().SetBody(newRandPoll(7)).Put(BASE_API + "/polls/2")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	var poll db.Poll
+	rsp, err = cli.R().SetResult(&poll).Get(BASE_API + "/polls/2")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, uint(2), poll.PollId)
+
+	rsp, err = cli.R().Get(BASE_API + "/polls/7")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode())
+
+	option := db.PollOption{PollOptionId: 2, PollOptionValue: "Moved"}
+	rsp, err = cli.R().SetBody(option).Put(BASE_API + "/polls/2/options/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	var options []db.PollOption
+	rsp, err = cli.R().SetResult(&options).Get(BASE_API + "/polls/2/options")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 2, len(options))
+	assert.Equal(t, db.PollOption{PollOptionId: 1, PollOptionValue: "Moved"}, options[0])
+	assert.Equal(t, uint(2), options[1].PollOptionId)
+}
+
+func Test_DeletePoll(t *testing.T) {
+	rsp, err := cli.R().Delete(fmt.Sprintf("%s/polls/%d", BASE_API, 2))
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().Get(BASE_API + "/polls/2")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode())
+}
+
+func Test_DeleteAllPolls(t *testing.T) {
+	rsp, err := cli.R().Delete(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	var polls []db.Poll
+	rsp, err = cli.R().SetResult(&polls).Get(BASE_API + "/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 0, len(polls))
+}
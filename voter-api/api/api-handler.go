@@ -1,11 +1,15 @@
 package api
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"drexel.edu/todo/db"
@@ -15,38 +19,164 @@ import (
 // The api package creates and maintains a reference to the data handler
 // this is a good design practice
 type VoterAPI struct {
-	db            *db.VoterList
-	bootTime      time.Time
-	totalRequests uint64
-	totalErrors   uint64
+	db        db.VoterStore
+	storeType string
+	links     LinkConfig
+	bootTime  time.Time
+	metrics   *metrics
+
+	//nil leaves every route open, see UseAuth
+	auth *Authenticator
+
+	//nil limits nothing, see UseRateLimit
+	limiter *RateLimiter
+
+	//set by Shutdown, /readyz answers 503 from then on
+	shuttingDown atomic.Bool
+
+	//the OpenAPI document, built once from the route table
+	spec map[string]any
+}
+
+func New(storeType string, links LinkConfig) (*VoterAPI, error) {
+	dbHandler, err := db.NewVoterStore(storeType)
+	if err != nil {
+		return nil, err
+	}
+	return newVoterAPI(dbHandler, storeType, links), nil
 }
 
-func New() (*VoterAPI, error) {
-	dbHandler, err := db.NewVoterList()
+// NewWithStoreConfig is New with the store settings passed in rather than
+// taken from the environment, see db.OpenVoterStore
+func NewWithStoreConfig(store db.StoreConfig, links LinkConfig) (*VoterAPI, error) {
+	dbHandler, err := db.OpenVoterStore(store)
 	if err != nil {
 		return nil, err
 	}
+	return newVoterAPI(dbHandler, store.Type, links), nil
+}
+
+func newVoterAPI(dbHandler db.VoterStore, storeType string, links LinkConfig) *VoterAPI {
+	if storeType == "" {
+		storeType = db.StoreMemory
+	}
+	//Every store call gets a span, see db.TracedStore
+	store := db.NewTracedStore(dbHandler, storeType)
+	return &VoterAPI{db: store, storeType: storeType, links: links, bootTime: time.Now(), metrics: newMetrics(), spec: openAPISpec(links)}
+}
+
+// maxPageLimit caps the limit query parameter of GET /voters, and
+// defaultPageLimit is the page size when there is none
+const (
+	maxPageLimit     = 1000
+	defaultPageLimit = 100
+)
+
+// etag is the ETag header for a voter revision
+func etag(rev uint64) string {
+	return fmt.Sprintf(`"%d"`, rev)
+}
+
+// ifMatch reads the If-Match header as a voter revision.  No header or *
+// means any revision, anything we did not hand out as an ETag is a 400
+func ifMatch(c *fiber.Ctx) (uint64, error) {
+	hdr := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	if hdr == "" || hdr == "*" {
+		return db.AnyRevision, nil
+	}
+
+	rev, err := strconv.ParseUint(strings.Trim(strings.TrimPrefix(hdr, "W/"), `"`), 10, 64)
+	if err != nil || rev == db.AnyRevision {
+		return 0, newProblem(http.StatusBadRequest, "invalid_if_match", "If-Match must be an ETag from this API")
+	}
+	return rev, nil
+}
 
-	return &VoterAPI{db: dbHandler, bootTime: time.Now(), totalErrors: 0, totalRequests: 45}, nil
+// currentVoter reads the voter back after a write so the response carries
+// the new revision, and sets it as the ETag
+func (vt *VoterAPI) currentVoter(c *fiber.Ctx, id uint) (db.Voter, error) {
+	voter, err := vt.db.GetVoter(c.UserContext(), id)
+	if err != nil {
+		slog.DebugContext(c.UserContext(), "Error reading back voter", "err", err)
+		return db.Voter{}, err
+	}
+	c.Set(fiber.HeaderETag, etag(voter.Revision))
+	return voter, nil
 }
 
+// implementation for GET /voters
+// Supports ?limit=&cursor= paging, ?sort=name|voter_id and ?name= and
+// ?email= filters.  Without a limit a page has defaultPageLimit voters.
+// When there are more pages the next one is in _links and in a Link
+// header with rel="next"
 func (vt *VoterAPI) ListAllVoters(c *fiber.Ctx) error {
 
-	voterList, err := vt.db.GetAllVoters()
+	q := db.VoterQuery{
+		Limit:  defaultPageLimit,
+		Cursor: c.Query("cursor"),
+		Sort:   c.Query("sort"),
+		Name:   c.Query("name"),
+		Email:  c.Query("email"),
+	}
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil {
+			return fiber.NewError(http.StatusBadRequest, "limit must be a number")
+		}
+		//0 would be every voter in the store
+		if limit == 0 {
+			return fiber.NewError(http.StatusBadRequest, "limit must be at least 1")
+		}
+		q.Limit = min(limit, maxPageLimit)
+	}
+	if err := q.Validate(); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
+	}
+
+	page, err := vt.db.ListVoters(c.UserContext(), q)
 	if err != nil {
-		log.Println("Error Getting All Items: ", err)
-		return fiber.NewError(http.StatusNotFound,
-			"Error Getting All Items")
+		slog.DebugContext(c.UserContext(), "Error Getting All Items", "err", err)
+		return err
+	}
+
+	if page.NextCursor != "" {
+		c.Append(fiber.HeaderLink, fmt.Sprintf(`<%s>; rel="next"`, vt.links.pageLink(q, page.NextCursor)))
 	}
+
 	//Note that the database returns a nil slice if there are no items
-	//in the database.  We need to convert this to an empty slice
-	//so that the JSON marshalling works correctly.  We want to return
-	//an empty slice, not a nil slice. This will result in the json being []
-	if voterList == nil {
-		voterList = make([]db.Voter, 0)
+	//in the database.  voterListResponse always builds a non-nil slice
+	//so the JSON will be [] rather than null
+	return c.JSON(vt.links.voterPageResponse(q, page))
+}
+
+// implementation for GET /voters/search
+// ?name= is a full text match on the name and ?email= an exact match on
+// the email, either can end in * for a prefix match.  On redis this runs
+// against the RediSearch index instead of scanning every voter
+func (vt *VoterAPI) SearchVoters(c *fiber.Ctx) error {
+
+	s := db.VoterSearch{
+		Name:  c.Query("name"),
+		Email: c.Query("email"),
+	}
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil {
+			return fiber.NewError(http.StatusBadRequest, "limit must be a number")
+		}
+		s.Limit = min(limit, maxPageLimit)
+	}
+	if err := s.Validate(); err != nil {
+		return fiber.NewError(http.StatusBadRequest, err.Error())
 	}
 
-	return c.JSON(voterList)
+	voterList, err := vt.db.SearchVoters(c.UserContext(), s)
+	if err != nil {
+		slog.DebugContext(c.UserContext(), "Error searching voters", "err", err)
+		return err
+	}
+
+	return c.JSON(vt.links.voterListResponse(voterList))
 }
 
 func (vt *VoterAPI) GetVoters(c *fiber.Ctx) error {
@@ -57,13 +187,14 @@ func (vt *VoterAPI) GetVoters(c *fiber.Ctx) error {
 		return fiber.NewError(http.StatusBadRequest)
 	}
 
-	voter, err := vt.db.GetVoter(uint(id))
+	voter, err := vt.db.GetVoter(c.UserContext(), uint(id))
 	if err != nil {
-		log.Println("Item not found: ", err)
-		return fiber.NewError(http.StatusNotFound)
+		slog.DebugContext(c.UserContext(), "Item not found", "err", err)
+		return err
 	}
 
-	return c.JSON(voter)
+	c.Set(fiber.HeaderETag, etag(voter.Revision))
+	return c.JSON(vt.links.voterResponse(voter))
 }
 
 func (vt *VoterAPI) GetVotersPoll(c *fiber.Ctx) error {
@@ -74,13 +205,13 @@ func (vt *VoterAPI) GetVotersPoll(c *fiber.Ctx) error {
 		return fiber.NewError(http.StatusBadRequest)
 	}
 
-	voter, err := vt.db.GetVoterPoll(uint(id))
+	voter, err := vt.db.GetVoterPoll(c.UserContext(), uint(id))
 	if err != nil {
-		log.Println("Item not found: ", err)
-		return fiber.NewError(http.StatusNotFound)
+		slog.DebugContext(c.UserContext(), "Item not found", "err", err)
+		return err
 	}
 
-	return c.JSON(voter)
+	return c.JSON(vt.links.historyListResponse(uint(id), voter))
 }
 
 func (vt *VoterAPI) GetVotersPollId(c *fiber.Ctx) error {
@@ -97,31 +228,37 @@ func (vt *VoterAPI) GetVotersPollId(c *fiber.Ctx) error {
 		return fiber.NewError(http.StatusBadRequest)
 	}
 
-	voter, err := vt.db.GetVoterPollId(uint(id), uint(pollId))
+	voter, err := vt.db.GetVoterPollId(c.UserContext(), uint(id), uint(pollId))
 	if err != nil {
-		log.Println("Item not found: ", err)
-		return fiber.NewError(http.StatusNotFound)
+		slog.DebugContext(c.UserContext(), "Item not found", "err", err)
+		return err
 	}
 
-	return c.JSON(voter)
+	return c.JSON(vt.links.historyResponse(uint(id), voter))
 }
 
-// implementation for POST /todo
-// adds a new todo
+// implementation for POST /voters
+// Leave voter_id out and the store picks the next one, send one to keep
+// an id from another system.  Answers 201 with the new voter's url in
+// Location
 func (vt *VoterAPI) AddVoters(c *fiber.Ctx) error {
-	var voter db.Voter
-
-	if err := c.BodyParser(&voter); err != nil {
-		log.Println("Error binding JSON: ", err)
-		return fiber.NewError(http.StatusBadRequest)
+	voter, err := bindVoter(c, 0)
+	if err != nil {
+		return err
 	}
 
-	if err := vt.db.AddVoter(voter); err != nil {
-		log.Println("Error adding item: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+	id, err := vt.db.AddVoter(c.UserContext(), voter)
+	if err != nil {
+		slog.DebugContext(c.UserContext(), "Error adding item", "err", err)
+		return err
 	}
 
-	return c.JSON(voter)
+	voter, err = vt.currentVoter(c, id)
+	if err != nil {
+		return err
+	}
+	c.Location(expand(vt.links.VoterBaseURL, VoterPath, id))
+	return c.Status(http.StatusCreated).JSON(vt.links.voterResponse(voter))
 }
 
 func (vt *VoterAPI) AddVotersPoll(c *fiber.Ctx) error {
@@ -132,29 +269,29 @@ func (vt *VoterAPI) AddVotersPoll(c *fiber.Ctx) error {
 		return fiber.NewError(http.StatusBadRequest)
 	}
 
-	var voterPoll db.VoterHistory
-
-	if err := c.BodyParser(&voterPoll); err != nil {
-		log.Println("Error binding JSON: ", err)
-		return fiber.NewError(http.StatusBadRequest)
+	voterPoll, err := bindHistory(c, 0)
+	if err != nil {
+		return err
 	}
 
-	if err := vt.db.AddVoterPoll(uint(voterID), voterPoll); err != nil {
-		log.Println("Error adding item: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+	if err := vt.db.AddVoterPoll(c.UserContext(), uint(voterID), voterPoll); err != nil {
+		slog.DebugContext(c.UserContext(), "Error adding item", "err", err)
+		return err
 	}
 
-	return c.JSON(voterPoll)
+	return c.JSON(vt.links.historyResponse(uint(voterID), voterPoll))
 }
 
 func (vt *VoterAPI) DeleteAllVoters(c *fiber.Ctx) error {
 
-	if err := vt.db.DeleteAll(); err != nil {
-		log.Println("Error deleting all items: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+	if cnt, err := vt.db.DeleteAll(c.UserContext()); err != nil {
+		slog.DebugContext(c.UserContext(), "Error deleting all items", "err", err)
+		return err
+	} else {
+		slog.InfoContext(c.UserContext(), "Deleted all voters", "count", cnt)
 	}
 
-	return c.Status(http.StatusOK).SendString("Delete All OK")
+	return c.Status(http.StatusOK).JSON(vt.links.messageResponse("Delete All OK"))
 }
 
 func (vt *VoterAPI) DeleteVoters(c *fiber.Ctx) error {
@@ -164,12 +301,17 @@ func (vt *VoterAPI) DeleteVoters(c *fiber.Ctx) error {
 		return fiber.NewError(http.StatusBadRequest)
 	}
 
-	if err := vt.db.DeleteVoter(uint(id)); err != nil {
-		log.Println("Error deleting item: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+	rev, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
+
+	if err := vt.db.DeleteVoter(c.UserContext(), uint(id), rev); err != nil {
+		slog.DebugContext(c.UserContext(), "Error deleting item", "err", err)
+		return err
 	}
 
-	return c.Status(http.StatusOK).SendString("Delete OK")
+	return c.Status(http.StatusOK).JSON(vt.links.messageResponse("Delete OK"))
 }
 
 func (vt *VoterAPI) DeleteVotersPoll(c *fiber.Ctx) error {
@@ -185,12 +327,17 @@ func (vt *VoterAPI) DeleteVotersPoll(c *fiber.Ctx) error {
 		return fiber.NewError(http.StatusBadRequest)
 	}
 
-	if err := vt.db.DeleteVoterPoll(uint(id), uint(pollId)); err != nil {
-		log.Println("Error deleting item: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+	rev, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
+
+	if err := vt.db.DeleteVoterPoll(c.UserContext(), uint(id), uint(pollId), rev); err != nil {
+		slog.DebugContext(c.UserContext(), "Error deleting item", "err", err)
+		return err
 	}
 
-	return c.Status(http.StatusOK).SendString("Delete OK")
+	return c.Status(http.StatusOK).JSON(vt.links.messageResponse("Delete OK"))
 }
 
 func (vt *VoterAPI) UpdateVoters(c *fiber.Ctx) error {
@@ -199,18 +346,93 @@ func (vt *VoterAPI) UpdateVoters(c *fiber.Ctx) error {
 	if err != nil {
 		return fiber.NewError(http.StatusBadRequest)
 	}
-	var voter db.Voter
-	if err := c.BodyParser(&voter); err != nil {
-		log.Println("Error binding JSON: ", err)
+	voter, err := bindVoter(c, uint(id))
+	if err != nil {
+		return err
+	}
+
+	rev, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
+
+	//A voter may fix its own name and email but not its vote history, so
+	//its PUT goes to the store as a patch that leaves the history alone
+	if p := CurrentPrincipal(c); p != nil && p.Role == RoleVoter {
+		err = vt.patchOwnVoter(c, uint(id), rev, voter)
+	} else {
+		err = vt.db.UpdateVoter(c.UserContext(), uint(id), rev, voter)
+	}
+	if err != nil {
+		slog.DebugContext(c.UserContext(), "Error updating voter", "err", err)
+		return err
+	}
+
+	voter, err = vt.currentVoter(c, uint(id))
+	if err != nil {
+		return err
+	}
+	return c.JSON(vt.links.voterResponse(voter))
+}
+
+// implementation for PATCH /voters/:id
+// Takes a JSON Merge Patch (application/merge-patch+json) or a JSON Patch
+// (application/json-patch+json).  The store applies it to the stored voter,
+// so fields the patch does not touch, like vote_history, are kept
+func (vt *VoterAPI) PatchVoters(c *fiber.Ctx) error {
+	idStr := c.Params("id")
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
 		return fiber.NewError(http.StatusBadRequest)
 	}
 
-	if err := vt.db.UpdateVoter(uint(id), voter); err != nil {
-		log.Println("Error updating voter: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+	patch, err := db.NewVoterPatch(c.Get(fiber.HeaderContentType), c.Body())
+	if err != nil {
+		slog.DebugContext(c.UserContext(), "Error reading patch", "err", err)
+		if errors.Is(err, db.ErrUnsupportedPatch) {
+			c.Set("Accept-Patch", db.MergePatchType+", "+db.JSONPatchType)
+			return newProblem(http.StatusUnsupportedMediaType, db.ErrUnsupportedPatch.Code, err.Error())
+		}
+		return err
+	}
+
+	rev, err := ifMatch(c)
+	if err != nil {
+		return err
+	}
+
+	if p := CurrentPrincipal(c); p != nil && p.Role == RoleVoter {
+		patch = patch.KeepHistory()
+	}
+	if err := vt.db.PatchVoter(c.UserContext(), uint(id), rev, patch); err != nil {
+		slog.DebugContext(c.UserContext(), "Error patching voter", "err", err)
+		return err
+	}
+
+	voter, err := vt.currentVoter(c, uint(id))
+	if err != nil {
+		return err
 	}
+	return c.JSON(vt.links.voterResponse(voter))
+}
 
-	return c.JSON(voter)
+// patchOwnVoter is a voter's PUT of its own record.  The name and email
+// are replaced, the stored vote history is kept when the body leaves it out
+// and the patch fails with a 422 when the body changes it
+func (vt *VoterAPI) patchOwnVoter(c *fiber.Ctx, id uint, rev uint64, voter db.Voter) error {
+	doc := map[string]any{"name": voter.Name, "email": voter.Email}
+	if voter.VoteHistory != nil {
+		doc["vote_history"] = voter.VoteHistory
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	patch, err := db.NewVoterPatch(db.MergePatchType, b)
+	if err != nil {
+		return err
+	}
+	return vt.db.PatchVoter(c.UserContext(), id, rev, patch.KeepHistory())
 }
 
 func (vt *VoterAPI) UpdateVotersPoll(c *fiber.Ctx) error {
@@ -224,18 +446,25 @@ func (vt *VoterAPI) UpdateVotersPoll(c *fiber.Ctx) error {
 	if err != nil {
 		return fiber.NewError(http.StatusBadRequest)
 	}
-	var voterHistory db.VoterHistory
-	if err := c.BodyParser(&voterHistory); err != nil {
-		log.Println("Error binding JSON: ", err)
-		return fiber.NewError(http.StatusBadRequest)
+	voterHistory, err := bindHistory(c, uint(pollId))
+	if err != nil {
+		return err
+	}
+
+	rev, err := ifMatch(c)
+	if err != nil {
+		return err
 	}
 
-	if err := vt.db.UpdateVoterPoll(uint(id), uint(pollId), voterHistory); err != nil {
-		log.Println("Error updating voter: ", err)
-		return fiber.NewError(http.StatusInternalServerError)
+	if err := vt.db.UpdateVoterPoll(c.UserContext(), uint(id), uint(pollId), rev, voterHistory); err != nil {
+		slog.DebugContext(c.UserContext(), "Error updating voter", "err", err)
+		return err
+	}
+	if _, err := vt.currentVoter(c, uint(id)); err != nil {
+		return err
 	}
 
-	return c.JSON(voterHistory)
+	return c.JSON(vt.links.historyResponse(uint(id), voterHistory))
 }
 
 func (td *VoterAPI) CrashSim(c *fiber.Ctx) error {
@@ -265,18 +494,30 @@ func (td *VoterAPI) CrashSim3(c *fiber.Ctx) error {
 }
 
 // implementation of GET /health. It is a good practice to build in a
-// health check for your API.  Below the results are just hard coded
-// but in a real API you can provide detailed information about the
-// health of your API with a Health Check
+// health check for your API.  The status is the /readyz one, so it is 503
+// when the store is down.  The request and error counts come from the
+// same counters /metrics serves, errors being the 5xx answers
 func (td *VoterAPI) HealthCheck(c *fiber.Ctx) error {
 	uptime := time.Since(td.bootTime)
+	totalRequests, totalErrors := td.metrics.totals()
 
-	return c.Status(http.StatusOK).
+	status, ready := http.StatusOK, td.ready(c.UserContext())
+	if ready.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+
+	return c.Status(status).
 		JSON(fiber.Map{
-			"status":             "ok",
-			"version":            "1.0.0",
+			"status":             ready.Status,
+			"version":            buildInfo().Version,
+			"build":              buildInfo(),
+			"checks":             ready.Checks,
 			"uptime":             uptime.Seconds(),
-			"users_processed":    td.totalRequests,
-			"errors_encountered": td.totalErrors,
+			"users_processed":    totalRequests,
+			"errors_encountered": totalErrors,
+			"_links": Links{
+				"self":       {expand(td.links.VoterBaseURL, VoterHealthPath)},
+				"all_voters": {expand(td.links.VoterBaseURL, VotersPath)},
+			},
 		})
 }
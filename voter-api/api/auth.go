@@ -0,0 +1,273 @@
+package api
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Roles.  An admin may do anything, a clerk may read every voter and add
+// voters and poll history, a voter may only read and change its own record
+const (
+	RoleAdmin = "admin"
+	RoleClerk = "clerk"
+	RoleVoter = "voter"
+)
+
+// APIKeyHeader carries a static API key.  Tokens go in Authorization
+const APIKeyHeader = "X-API-Key"
+
+// Principal methods
+const (
+	AuthAPIKey     = "api_key"
+	AuthJWT        = "jwt"
+	AuthClientCert = "client_cert"
+)
+
+// tokenLeeway is how far the token times may be off our clock
+const tokenLeeway = 30 * time.Second
+
+// APIKey is one static key.  Name is who it was given to, it is the
+// subject of the requests made with it
+type APIKey struct {
+	Name string
+	Key  string
+	Role string
+}
+
+// CertRole gives the verified client certificates with Subject a role.
+// Subject is matched against the whole certificate subject, like
+// CN=polls-api,O=Voting, and against each of the certificate's DNS names
+type CertRole struct {
+	Subject string
+	Role    string
+}
+
+// AuthConfig is the API keys, the client certificate roles and the JWKS
+// file tokens are checked against.  Issuer and Audience, when set, have to
+// match the token's iss and aud
+type AuthConfig struct {
+	APIKeys     []APIKey
+	ClientCerts []CertRole
+	JWKSFile    string
+	Issuer      string
+	Audience    string
+}
+
+// Principal is who made a request
+type Principal struct {
+	Subject string `json:"subject"`
+	Role    string `json:"role"`
+	VoterId uint   `json:"voter_id,omitempty"`
+	Method  string `json:"method"`
+}
+
+// tokenClaims are the claims we read from a JWT.  VoterId is only needed
+// with the voter role
+type tokenClaims struct {
+	Role    string `json:"role"`
+	VoterId uint   `json:"voter_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Authenticator knows the API keys and token signing keys
+type Authenticator struct {
+	//keyed by the SHA-256 of the key, so a lookup does not compare the
+	//secret byte by byte
+	apiKeys map[[32]byte]Principal
+
+	//role by certificate subject or DNS name
+	certRoles map[string]string
+
+	jwks   []verifyKey
+	parser *jwt.Parser
+}
+
+// NewAuthenticator checks the keys and loads the JWKS file, if there is one
+func NewAuthenticator(cfg AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{apiKeys: make(map[[32]byte]Principal), certRoles: make(map[string]string)}
+
+	for _, k := range cfg.APIKeys {
+		if k.Role != RoleAdmin && k.Role != RoleClerk {
+			return nil, fmt.Errorf("API key %s: role must be %s or %s", k.Name, RoleAdmin, RoleClerk)
+		}
+		if len(k.Key) < 16 {
+			return nil, fmt.Errorf("API key %s: key must be at least 16 characters", k.Name)
+		}
+		a.apiKeys[sha256.Sum256([]byte(k.Key))] = Principal{Subject: k.Name, Role: k.Role, Method: AuthAPIKey}
+	}
+
+	for _, cr := range cfg.ClientCerts {
+		if cr.Role != RoleAdmin && cr.Role != RoleClerk {
+			return nil, fmt.Errorf("client certificate %s: role must be %s or %s", cr.Subject, RoleAdmin, RoleClerk)
+		}
+		if cr.Subject == "" {
+			return nil, errors.New("client certificate role without a subject")
+		}
+		a.certRoles[cr.Subject] = cr.Role
+	}
+
+	if cfg.JWKSFile != "" {
+		keys, err := loadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.jwks = keys
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "HS256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(tokenLeeway),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	a.parser = jwt.NewParser(opts...)
+	return a, nil
+}
+
+// signingKey finds the key for a token by its kid.  A key only verifies
+// the alg it is for, so an HS256 token can't be checked against an RSA
+// public key used as the secret
+func (a *Authenticator) signingKey(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	var match *verifyKey
+	for i, k := range a.jwks {
+		if k.alg != t.Method.Alg() || (kid != "" && k.kid != kid) {
+			continue
+		}
+		//Without a kid the key has to be the only one that fits
+		if match != nil {
+			return nil, errors.New("token has no kid and more than one key fits")
+		}
+		match = &a.jwks[i]
+	}
+	if match == nil {
+		return nil, errors.New("no key for the token")
+	}
+	return match.key, nil
+}
+
+func (a *Authenticator) parseToken(raw string) (*Principal, error) {
+	if len(a.jwks) == 0 {
+		return nil, errors.New("tokens are not accepted")
+	}
+
+	var claims tokenClaims
+	if _, err := a.parser.ParseWithClaims(raw, &claims, a.signingKey); err != nil {
+		return nil, err
+	}
+
+	p := &Principal{Subject: claims.Subject, Role: claims.Role, Method: AuthJWT}
+	switch claims.Role {
+	case RoleAdmin, RoleClerk:
+	case RoleVoter:
+		if claims.VoterId == 0 {
+			return nil, errors.New("voter token without a voter_id")
+		}
+		p.VoterId = claims.VoterId
+	default:
+		return nil, fmt.Errorf("unknown role %q", claims.Role)
+	}
+	return p, nil
+}
+
+// certPrincipal is the principal of the request's verified client
+// certificate, nil when there is none or it has no role
+func (a *Authenticator) certPrincipal(c *fiber.Ctx) *Principal {
+	cert := ClientCert(c)
+	if cert == nil {
+		return nil
+	}
+
+	subject := cert.Subject.String()
+	for _, name := range append([]string{subject}, cert.DNSNames...) {
+		if role, ok := a.certRoles[name]; ok {
+			return &Principal{Subject: subject, Role: role, Method: AuthClientCert}
+		}
+	}
+	return nil
+}
+
+// authenticate works out the principal of a request.  A client
+// certificate with a role comes first, the TLS handshake already proved
+// it.  Otherwise an API key wins over a token if a client sends both
+func (a *Authenticator) authenticate(c *fiber.Ctx) (*Principal, error) {
+	if p := a.certPrincipal(c); p != nil {
+		return p, nil
+	}
+
+	if key := c.Get(APIKeyHeader); key != "" {
+		p, ok := a.apiKeys[sha256.Sum256([]byte(key))]
+		if !ok {
+			return nil, errors.New("unknown API key")
+		}
+		return &p, nil
+	}
+
+	scheme, token, found := strings.Cut(c.Get(fiber.HeaderAuthorization), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return nil, errors.New("no API key or bearer token")
+	}
+	return a.parseToken(strings.TrimSpace(token))
+}
+
+// principalKey is where allow keeps the principal in the fiber locals
+const principalKey = "principal"
+
+// CurrentPrincipal is who made the request, nil when auth is off
+func CurrentPrincipal(c *fiber.Ctx) *Principal {
+	p, _ := c.Locals(principalKey).(*Principal)
+	return p
+}
+
+// UseAuth turns on authentication.  Call it before the app serves, without
+// it every route is open
+func (vt *VoterAPI) UseAuth(a *Authenticator) {
+	vt.auth = a
+}
+
+// allow lets only the roles given through to the handler.  A voter is only
+// ever let through to its own /voters/:id, the routes that allow the voter
+// role all have the id
+func (vt *VoterAPI) allow(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if vt.auth == nil {
+			return c.Next()
+		}
+
+		p, err := vt.auth.authenticate(c)
+		if err != nil {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="voter-api"`)
+			return newProblem(http.StatusUnauthorized, "unauthorized", err.Error())
+		}
+		c.Locals(principalKey, p)
+
+		allowed := false
+		for _, r := range roles {
+			allowed = allowed || r == p.Role
+		}
+		if !allowed {
+			return newProblem(http.StatusForbidden, "forbidden", fmt.Sprintf("role %s may not %s %s", p.Role, c.Method(), c.Route().Path))
+		}
+
+		if p.Role == RoleVoter {
+			id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+			if err != nil || uint(id) != p.VoterId {
+				return newProblem(http.StatusForbidden, "forbidden", "a voter may only access its own record")
+			}
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Version is set at build time with
+//
+//	go build -ldflags "-X drexel.edu/todo/api.Version=1.2.3"
+//
+// Left empty we fall back to the module version and vcs revision go
+// stamps into the binary
+var Version = ""
+
+// BuildInfo is what the health endpoints report about the binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+var buildInfo = sync.OnceValue(func() BuildInfo {
+	bi := BuildInfo{Version: Version, GoVersion: "unknown"}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		if bi.Version == "" {
+			bi.Version = "unknown"
+		}
+		return bi
+	}
+	bi.GoVersion = info.GoVersion
+	if bi.Version == "" {
+		bi.Version = info.Main.Version
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			bi.Revision = s.Value
+		}
+	}
+	return bi
+})
+
+// readyTimeout is how long /readyz waits for each dependency
+const readyTimeout = 2 * time.Second
+
+// Check is the state of one dependency in a /readyz answer
+type Check struct {
+	Status    string  `json:"status"`
+	Type      string  `json:"type,omitempty"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// ReadyResponse is the body of /readyz
+type ReadyResponse struct {
+	Status  string           `json:"status"`
+	Version string           `json:"version"`
+	Checks  map[string]Check `json:"checks"`
+}
+
+// ready pings every dependency, today that is just the store.  Once
+// Shutdown was called we are not ready whatever the store says
+func (vt *VoterAPI) ready(ctx context.Context) ReadyResponse {
+	if vt.shuttingDown.Load() {
+		return ReadyResponse{
+			Status:  "shutting_down",
+			Version: buildInfo().Version,
+			Checks:  map[string]Check{},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := vt.db.Ping(ctx)
+	store := Check{
+		Status:    "up",
+		Type:      vt.storeType,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		store.Status = "down"
+		store.Error = err.Error()
+	}
+
+	res := ReadyResponse{
+		Status:  "ready",
+		Version: buildInfo().Version,
+		Checks:  map[string]Check{"store": store},
+	}
+	for _, c := range res.Checks {
+		if c.Status != "up" {
+			res.Status = "not_ready"
+		}
+	}
+	return res
+}
+
+// implementation of GET /livez
+// The process is up and serving, nothing else is checked.  A failing
+// dependency should take us out of the load balancer, not get us restarted
+func (vt *VoterAPI) Livez(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"status":  "ok",
+		"version": buildInfo().Version,
+	})
+}
+
+// implementation of GET /readyz
+// 200 when every dependency answers within readyTimeout, 503 otherwise or
+// while shutting down
+func (vt *VoterAPI) Readyz(c *fiber.Ctx) error {
+	res := vt.ready(c.UserContext())
+	if res.Status != "ready" {
+		return c.Status(http.StatusServiceUnavailable).JSON(res)
+	}
+	return c.JSON(res)
+}
+
+// HealthcheckConfig is where the healthcheck subcommand finds the server.
+// CertFile and KeyFile are the client certificate when the server requires
+// one
+type HealthcheckConfig struct {
+	Host     string
+	Port     uint
+	TLS      bool
+	CertFile string
+	KeyFile  string
+	Path     string
+	Timeout  time.Duration
+}
+
+// Healthcheck is the healthcheck subcommand, for container health checks
+// in images without curl.  It asks the server on this host for cfg.Path
+// and returns the exit code, 0 if it answered 200
+func Healthcheck(cfg HealthcheckConfig) int {
+	cli := http.Client{Timeout: cfg.Timeout}
+	scheme := "http"
+	if cfg.TLS {
+		scheme = "https"
+
+		//We probe ourselves, mostly on 127.0.0.1, which the certificate
+		//is not issued for, so there is nothing to verify it against
+		tlsCfg := &tls.Config{InsecureSkipVerify: true}
+		if cfg.CertFile != "" {
+			cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+			if err != nil {
+				fmt.Println("healthcheck:", err)
+				return 2
+			}
+			tlsCfg.Certificates = []tls.Certificate{cert}
+		}
+		cli.Transport = &http.Transport{TLSClientConfig: tlsCfg}
+	}
+
+	//A server listening on every address is reached on loopback
+	host := cfg.Host
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	addr := net.JoinHostPort(host, strconv.FormatUint(uint64(cfg.Port), 10))
+
+	rsp, err := cli.Get(fmt.Sprintf("%s://%s%s", scheme, addr, cfg.Path))
+	if err != nil {
+		fmt.Println("healthcheck:", err)
+		return 1
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		fmt.Println("healthcheck:", rsp.Status)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// jwk is the part of a JSON Web Key we use, RFC 7517.  RSA keys verify
+// RS256 tokens, oct keys are the shared secret of HS256 tokens
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+
+	//RSA public key
+	N string `json:"n"`
+	E string `json:"e"`
+
+	//oct secret
+	K string `json:"k"`
+}
+
+// verifyKey is one usable key, for the alg it can verify
+type verifyKey struct {
+	kid string
+	alg string
+	key interface{}
+}
+
+// loadJWKS reads the keys of a JWKS file.  Keys for anything but signing
+// with RS256 or HS256 are skipped, a file with none left is an error
+func loadJWKS(path string) ([]verifyKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var keys []verifyKey
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var vk verifyKey
+		switch {
+		case k.Kty == "RSA" && (k.Alg == "" || k.Alg == "RS256"):
+			pub, err := rsaKey(k)
+			if err != nil {
+				return nil, fmt.Errorf("%s: key %d: %w", path, i, err)
+			}
+			vk = verifyKey{kid: k.Kid, alg: "RS256", key: pub}
+		case k.Kty == "oct" && (k.Alg == "" || k.Alg == "HS256"):
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil || len(secret) < 32 {
+				return nil, fmt.Errorf("%s: key %d: k must be at least 32 bytes of base64url", path, i)
+			}
+			vk = verifyKey{kid: k.Kid, alg: "HS256", key: secret}
+		default:
+			continue
+		}
+		keys = append(keys, vk)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New(path + ": no RS256 or HS256 signing keys")
+	}
+	return keys, nil
+}
+
+func rsaKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil || len(n) == 0 {
+		return nil, errors.New("bad RSA modulus n")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("bad RSA exponent e")
+	}
+
+	pub := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}
+	if pub.N.BitLen() < 2048 {
+		return nil, errors.New("RSA keys must be at least 2048 bits")
+	}
+	return pub, nil
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+
+	"drexel.edu/todo/db"
+)
+
+// LinkConfig holds the base urls the links are built on.  An empty base
+// gives relative links like /voters/1
+type LinkConfig struct {
+	VoterBaseURL string
+	PollsBaseURL string
+	VotesBaseURL string
+}
+
+type Link struct {
+	Href string `json:"href"`
+}
+
+type Links map[string]Link
+
+// VoterHistoryResponse is a db.VoterHistory with its _links
+type VoterHistoryResponse struct {
+	db.VoterHistory
+	Links Links `json:"_links"`
+}
+
+// VoterResponse is a db.Voter with its _links.  VoteHistory shadows the
+// embedded field so every history entry carries its own links too
+type VoterResponse struct {
+	db.Voter
+	VoteHistory []VoterHistoryResponse `json:"vote_history"`
+	Links       Links                  `json:"_links"`
+}
+
+// VoterPageResponse is one page of GET /voters.  Its _links has self and,
+// when there are more voters, next
+type VoterPageResponse struct {
+	Voters []VoterResponse `json:"voters"`
+	Links  Links           `json:"_links"`
+}
+
+// MessageResponse is returned by the handlers that have no resource to
+// send back, like the deletes
+type MessageResponse struct {
+	Message string `json:"message"`
+	Links   Links  `json:"_links"`
+}
+
+var routeParam = regexp.MustCompile(`:[a-z]+(<[a-z]+>)?`)
+
+// expand fills the :params of a route pattern in order, so
+// expand("/voters/:id<int>/polls/:pollid<int>", 1, 2) is /voters/1/polls/2
+func expand(base, pattern string, ids ...uint) string {
+	i := 0
+	path := routeParam.ReplaceAllStringFunc(pattern, func(string) string {
+		if i >= len(ids) {
+			return ""
+		}
+		s := strconv.FormatUint(uint64(ids[i]), 10)
+		i++
+		return s
+	})
+	return base + path
+}
+
+func (lc LinkConfig) voterLinks(voterId uint) Links {
+	return Links{
+		"self":            {expand(lc.VoterBaseURL, VoterPath, voterId)},
+		"vote_history":    {expand(lc.VoterBaseURL, VoterPollsPath, voterId)},
+		"voter_responses": {expand(lc.VotesBaseURL, VoterVotesPath, voterId)},
+		"make_vote":       {expand(lc.VotesBaseURL, VotesPath)},
+		"all_polls":       {expand(lc.PollsBaseURL, PollsPath)},
+	}
+}
+
+func (lc LinkConfig) historyLinks(voterId, pollId uint) Links {
+	return Links{
+		"self":          {expand(lc.VoterBaseURL, VoterPollPath, voterId, pollId)},
+		"voter":         {expand(lc.VoterBaseURL, VoterPath, voterId)},
+		"vote_response": {expand(lc.VotesBaseURL, VoterPollVotePath, voterId, pollId)},
+		"poll_info":     {expand(lc.PollsBaseURL, PollPath, pollId)},
+	}
+}
+
+func (lc LinkConfig) collectionLinks() Links {
+	return Links{
+		"self":      {expand(lc.VoterBaseURL, VotersPath)},
+		"all_polls": {expand(lc.PollsBaseURL, PollsPath)},
+	}
+}
+
+// pageLink is GET /voters with the same query at cursor, the first page
+// if cursor is empty
+func (lc LinkConfig) pageLink(q db.VoterQuery, cursor string) string {
+	params := url.Values{}
+	params.Set("limit", strconv.Itoa(q.Limit))
+	if cursor != "" {
+		params.Set("cursor", cursor)
+	}
+	if q.Sort != "" {
+		params.Set("sort", q.Sort)
+	}
+	if q.Name != "" {
+		params.Set("name", q.Name)
+	}
+	if q.Email != "" {
+		params.Set("email", q.Email)
+	}
+	return expand(lc.VoterBaseURL, VotersPath) + "?" + params.Encode()
+}
+
+func (lc LinkConfig) historyResponse(voterId uint, h db.VoterHistory) VoterHistoryResponse {
+	return VoterHistoryResponse{
+		VoterHistory: h,
+		Links:        lc.historyLinks(voterId, h.PollId),
+	}
+}
+
+func (lc LinkConfig) historyListResponse(voterId uint, hl []db.VoterHistory) []VoterHistoryResponse {
+	res := make([]VoterHistoryResponse, 0, len(hl))
+	for _, h := range hl {
+		res = append(res, lc.historyResponse(voterId, h))
+	}
+	return res
+}
+
+func (lc LinkConfig) voterResponse(v db.Voter) VoterResponse {
+	return VoterResponse{
+		Voter:       v,
+		VoteHistory: lc.historyListResponse(v.VoterId, v.VoteHistory),
+		Links:       lc.voterLinks(v.VoterId),
+	}
+}
+
+func (lc LinkConfig) voterListResponse(vl []db.Voter) []VoterResponse {
+	res := make([]VoterResponse, 0, len(vl))
+	for _, v := range vl {
+		res = append(res, lc.voterResponse(v))
+	}
+	return res
+}
+
+func (lc LinkConfig) voterPageResponse(q db.VoterQuery, page db.VoterPage) VoterPageResponse {
+	links := Links{"self": {lc.pageLink(q, q.Cursor)}}
+	if page.NextCursor != "" {
+		links["next"] = Link{lc.pageLink(q, page.NextCursor)}
+	}
+	return VoterPageResponse{
+		Voters: lc.voterListResponse(page.Voters),
+		Links:  links,
+	}
+}
+
+func (lc LinkConfig) messageResponse(msg string) MessageResponse {
+	return MessageResponse{
+		Message: msg,
+		Links:   lc.collectionLinks(),
+	}
+}
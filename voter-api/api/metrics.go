@@ -0,0 +1,144 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// unmatchedRoute is the route label of requests no route matched, so a
+// scan of random urls can't blow up the number of series
+const unmatchedRoute = "unmatched"
+
+// metrics holds the request metrics of one VoterAPI.  They are on their own
+// registry rather than the prometheus default one, so every VoterAPI, like
+// the ones the stress tests build, starts from zero
+type metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight prometheus.Gauge
+	limited  *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "voter_api",
+			Name:      "http_requests_total",
+			Help:      "Requests handled, by method, route pattern and status class.",
+		}, []string{"method", "route", "class"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "voter_api",
+			Name:      "http_request_duration_seconds",
+			Help:      "Time to handle a request, by method and route pattern.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "voter_api",
+			Name:      "http_requests_in_flight",
+			Help:      "Requests being handled right now.",
+		}),
+		limited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "voter_api",
+			Name:      "rate_limited_requests_total",
+			Help:      "Requests answered 429 by the rate limiter, by method and route pattern.",
+		}, []string{"method", "route"}),
+	}
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		m.inFlight,
+		m.limited,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// statusClass is 2xx, 4xx and so on
+func statusClass(status int) string {
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// middleware records every request.  It has to be the last app.Use before
+// the routes: if no route matched, c.Route() is still our own middleware
+// route afterwards, and that is how we tell an unmatched request apart.
+//
+// Errors are run through the app's ErrorHandler here rather than after the
+// chain, so we count the status the client really gets
+func (m *metrics) middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		self := c.Route()
+		start := time.Now()
+		m.inFlight.Inc()
+
+		record := func(status int) {
+			//fiber reuses the request buffers, a label value we keep
+			//has to be a copy
+			method := utils.CopyString(c.Method())
+			route := c.Route().Path
+			if c.Route() == self {
+				route = unmatchedRoute
+			}
+			m.requests.WithLabelValues(method, route, statusClass(status)).Inc()
+			m.duration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+		}
+
+		//A panic goes up to the recover middleware, count it as the 500
+		//that will turn into
+		defer func() {
+			m.inFlight.Dec()
+			if r := recover(); r != nil {
+				record(http.StatusInternalServerError)
+				panic(r)
+			}
+		}()
+
+		if err := c.Next(); err != nil {
+			if err := c.App().ErrorHandler(c, err); err != nil {
+				_ = c.SendStatus(http.StatusInternalServerError)
+			}
+		}
+		record(c.Response().StatusCode())
+		return nil
+	}
+}
+
+// handler serves the registry in the prometheus text format
+func (m *metrics) handler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// totals adds up the request counter over every route, and over the 5xx
+// class for the errors.  HealthCheck reports these, so the health numbers
+// and the dashboards can't disagree
+func (m *metrics) totals() (requests, errors uint64) {
+	families, err := m.registry.Gather()
+	if err != nil {
+		return 0, 0
+	}
+	for _, f := range families {
+		if f.GetName() != "voter_api_http_requests_total" {
+			continue
+		}
+		for _, s := range f.GetMetric() {
+			n := uint64(s.GetCounter().GetValue())
+			requests += n
+			for _, l := range s.GetLabel() {
+				if l.GetName() == "class" && l.GetValue() == statusClass(http.StatusInternalServerError) {
+					errors += n
+				}
+			}
+		}
+	}
+	return requests, errors
+}
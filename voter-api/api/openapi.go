@@ -0,0 +1,640 @@
+package api
+
+import (
+	"html/template"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/validate"
+	"github.com/gofiber/fiber/v2"
+)
+
+// OpenAPIVersion is the version of the OpenAPI spec /openapi.json is
+// written to
+const OpenAPIVersion = "3.1.0"
+
+// object is one JSON object of the spec
+type object = map[string]any
+
+// operation is one method on one route of the spec.  route is the fiber
+// pattern from the route table, so the spec can only show paths we serve,
+// tests/openapi checks the other way round.  roles nil is an open route.
+// status 0 is a route with no success answer, like /crash
+type operation struct {
+	method  string
+	route   string
+	id      string
+	summary string
+	tag     string
+	roles   []string
+
+	//limited is behind rateLimit, store goes to the voter store, so it can
+	//be a 503 or 504
+	limited bool
+	store   bool
+
+	params []object
+	body   object
+
+	status     int
+	result     object
+	resultType string
+	headers    []string
+	errors     []int
+
+	//extra responses go in as they are, for the probes that answer 503
+	//with their usual body
+	extra object
+}
+
+func ref(kind, name string) object {
+	return object{"$ref": "#/components/" + kind + "/" + name}
+}
+
+func schemaRef(name string) object {
+	return ref("schemas", name)
+}
+
+func arrayOf(items object) object {
+	return object{"type": "array", "items": items}
+}
+
+func query(name, description string, schema object) object {
+	return object{"name": name, "in": "query", "description": description, "schema": schema}
+}
+
+func jsonBody(schema object) object {
+	return object{"required": true, "content": object{fiber.MIMEApplicationJSON: object{"schema": schema}}}
+}
+
+var (
+	ifMatchParam = ref("parameters", "If-Match")
+	nameQuery    = query("name", "Full text match on the name, end a word in * for a prefix match", object{"type": "string"})
+	emailQuery   = query("email", "Exact match on the email, end it in * for a prefix match", object{"type": "string"})
+
+	voterBody   = jsonBody(schemaRef("Voter"))
+	historyBody = jsonBody(schemaRef("VoterHistory"))
+	patchBody   = object{"required": true, "content": object{
+		db.MergePatchType: object{"schema": object{"type": "object", "description": "A JSON Merge Patch, RFC 7396, of a Voter.  Fields left out are kept"}},
+		db.JSONPatchType:  object{"schema": schemaRef("JSONPatch")},
+	}}
+
+	voterResult   = schemaRef("VoterResponse")
+	historyResult = schemaRef("VoterHistoryResponse")
+	messageResult = schemaRef("MessageResponse")
+)
+
+// operations is every route RegisterRoutes serves
+var operations = []operation{
+	{method: http.MethodGet, route: VotersPath, id: "listVoters", tag: "voters", roles: staffRoles, limited: true, store: true,
+		summary: "List voters, a page at a time with limit",
+		params: []object{
+			query("limit", "Voters per page, "+strconv.Itoa(defaultPageLimit)+" when left out, capped at "+strconv.Itoa(maxPageLimit), object{"type": "integer", "minimum": 1}),
+			query("cursor", "The cursor of the next page, from the next link", object{"type": "string"}),
+			query("sort", "Sort order, by voter_id when left out", object{"type": "string", "enum": []string{db.SortName, db.SortVoterId}}),
+			query("name", "Only voters with this name", object{"type": "string"}),
+			query("email", "Only voters with this email", object{"type": "string"}),
+		},
+		status: http.StatusOK, result: schemaRef("VoterPageResponse"), headers: []string{fiber.HeaderLink},
+		errors: []int{http.StatusBadRequest}},
+	{method: http.MethodPost, route: VotersPath, id: "addVoter", tag: "voters", roles: staffRoles, limited: true, store: true,
+		summary: "Add a voter, leave voter_id out for the next free one",
+		body:    voterBody,
+		status:  http.StatusCreated, result: voterResult, headers: []string{fiber.HeaderETag, fiber.HeaderLocation},
+		errors: []int{http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity}},
+	{method: http.MethodDelete, route: VotersPath, id: "deleteAllVoters", tag: "voters", roles: adminRoles, limited: true, store: true,
+		summary: "Delete every voter",
+		status:  http.StatusOK, result: messageResult},
+	{method: http.MethodGet, route: VoterSearchPath, id: "searchVoters", tag: "voters", roles: staffRoles, limited: true, store: true,
+		summary: "Search voters by name or email, one of them is required",
+		params: []object{nameQuery, emailQuery,
+			query("limit", "Most voters to return, "+strconv.Itoa(db.DefaultSearchLimit)+" when left out", object{"type": "integer", "minimum": 0}),
+		},
+		status: http.StatusOK, result: arrayOf(voterResult),
+		errors: []int{http.StatusBadRequest}},
+	{method: http.MethodGet, route: VoterPath, id: "getVoter", tag: "voters", roles: readOwnRoles, limited: true, store: true,
+		summary: "Get a voter",
+		status:  http.StatusOK, result: voterResult, headers: []string{fiber.HeaderETag},
+		errors: []int{http.StatusNotFound}},
+	{method: http.MethodPut, route: VoterPath, id: "updateVoter", tag: "voters", roles: writeOwnRoles, limited: true, store: true,
+		summary: "Replace a voter",
+		params:  []object{ifMatchParam}, body: voterBody,
+		status: http.StatusOK, result: voterResult, headers: []string{fiber.HeaderETag},
+		errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed, http.StatusUnprocessableEntity}},
+	{method: http.MethodPatch, route: VoterPath, id: "patchVoter", tag: "voters", roles: writeOwnRoles, limited: true, store: true,
+		summary: "Change some fields of a voter with a merge patch or a JSON patch",
+		params:  []object{ifMatchParam}, body: patchBody,
+		status: http.StatusOK, result: voterResult, headers: []string{fiber.HeaderETag},
+		errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity}},
+	{method: http.MethodDelete, route: VoterPath, id: "deleteVoter", tag: "voters", roles: adminRoles, limited: true, store: true,
+		summary: "Delete a voter",
+		params:  []object{ifMatchParam},
+		status:  http.StatusOK, result: messageResult,
+		errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed}},
+	{method: http.MethodGet, route: VoterPollsPath, id: "getVoteHistory", tag: "vote history", roles: readOwnRoles, limited: true, store: true,
+		summary: "Get the poll history of a voter",
+		status:  http.StatusOK, result: arrayOf(historyResult),
+		errors: []int{http.StatusNotFound}},
+	{method: http.MethodPost, route: VoterPollsPath, id: "addVoteHistory", tag: "vote history", roles: staffRoles, limited: true, store: true,
+		summary: "Add a poll to the history of a voter",
+		body:    historyBody,
+		status:  http.StatusOK, result: historyResult,
+		errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity}},
+	{method: http.MethodGet, route: VoterPollPath, id: "getVoteHistoryEntry", tag: "vote history", roles: readOwnRoles, limited: true, store: true,
+		summary: "Get one poll of the history of a voter",
+		status:  http.StatusOK, result: historyResult,
+		errors: []int{http.StatusNotFound}},
+	{method: http.MethodPut, route: VoterPollPath, id: "updateVoteHistoryEntry", tag: "vote history", roles: adminRoles, limited: true, store: true,
+		summary: "Replace one poll of the history of a voter",
+		params:  []object{ifMatchParam}, body: historyBody,
+		status: http.StatusOK, result: historyResult, headers: []string{fiber.HeaderETag},
+		errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed, http.StatusUnprocessableEntity}},
+	{method: http.MethodDelete, route: VoterPollPath, id: "deleteVoteHistoryEntry", tag: "vote history", roles: adminRoles, limited: true, store: true,
+		summary: "Delete one poll from the history of a voter",
+		params:  []object{ifMatchParam},
+		status:  http.StatusOK, result: messageResult,
+		errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed}},
+
+	{method: http.MethodGet, route: VoterHealthPath, id: "healthCheck", tag: "health",
+		summary: "Readiness, build and request counts",
+		status:  http.StatusOK, result: schemaRef("Health"),
+		extra: object{"503": object{"description": "The store is down or we are shutting down",
+			"content": object{fiber.MIMEApplicationJSON: object{"schema": schemaRef("Health")}}}}},
+	{method: http.MethodGet, route: LivezPath, id: "livez", tag: "health",
+		summary: "Liveness probe, up as long as the process serves",
+		status:  http.StatusOK, result: schemaRef("Live")},
+	{method: http.MethodGet, route: ReadyzPath, id: "readyz", tag: "health",
+		summary: "Readiness probe, pings the store",
+		status:  http.StatusOK, result: schemaRef("ReadyResponse"),
+		extra: object{"503": object{"description": "The store is down or we are shutting down",
+			"content": object{fiber.MIMEApplicationJSON: object{"schema": schemaRef("ReadyResponse")}}}}},
+	{method: http.MethodGet, route: MetricsPath, id: "metrics", tag: "health",
+		summary: "Prometheus metrics",
+		status:  http.StatusOK, result: object{"type": "string"}, resultType: fiber.MIMETextPlain},
+
+	{method: http.MethodGet, route: OpenAPIPath, id: "openAPI", tag: "docs",
+		summary: "This document",
+		status:  http.StatusOK, result: object{"type": "object"}},
+	{method: http.MethodGet, route: DocsPath, id: "docs", tag: "docs",
+		summary: "Interactive docs for this document",
+		status:  http.StatusOK, result: object{"type": "string"}, resultType: fiber.MIMETextHTML},
+
+	{method: http.MethodGet, route: "/crash", id: "crash", tag: "debug", roles: adminRoles,
+		summary: "Panic in a handler, the recover middleware answers 500"},
+	{method: http.MethodGet, route: "/crash2", id: "crash2", tag: "debug", roles: adminRoles,
+		summary: "Divide by zero in a handler, the recover middleware answers 500"},
+	{method: http.MethodGet, route: "/crash3", id: "crash3", tag: "debug", roles: adminRoles,
+		summary: "Exit the process, there is no answer at all"},
+}
+
+// problemResponses describes each error status the API answers with.
+// Every one is a Problem
+var problemResponses = map[int]string{
+	http.StatusBadRequest:           "A bad query parameter or If-Match header, or a body that is not JSON",
+	http.StatusUnauthorized:         "No credentials, or ones we don't take",
+	http.StatusForbidden:            "The caller's role may not do this, or a voter asked for another voter",
+	http.StatusNotFound:             "No such voter or poll history entry",
+	http.StatusConflict:             "The voter or poll history entry is already there",
+	http.StatusPreconditionFailed:   "If-Match names a revision the voter has moved on from",
+	http.StatusUnsupportedMediaType: "The patch is neither a merge patch nor a JSON patch",
+	http.StatusUnprocessableEntity:  "The body is JSON but some fields are bad, errors lists them",
+	http.StatusTooManyRequests:      "The client is over its rate limit",
+	http.StatusInternalServerError:  "Something went wrong on our side",
+	http.StatusServiceUnavailable:   "The voter store is down",
+	http.StatusGatewayTimeout:       "The voter store did not answer in time",
+}
+
+// problemName is the component name of an error response, like NotFound
+func problemName(status int) string {
+	return strings.ReplaceAll(http.StatusText(status), " ", "")
+}
+
+// headers are the response headers the operations refer to
+var responseHeaders = object{
+	fiber.HeaderETag:     object{"description": "The revision of the voter, send it back in If-Match", "schema": object{"type": "string"}},
+	fiber.HeaderLocation: object{"description": "The url of the new voter", "schema": object{"type": "string"}},
+	fiber.HeaderLink:     object{"description": `The next page, rel="next", when there is one`, "schema": object{"type": "string"}},
+	"RateLimit-Limit":    object{"description": "Requests a window allows, when rate limiting is on", "schema": object{"type": "integer"}},
+	"RateLimit-Remaining": object{"description": "Requests left in this window, when rate limiting is on",
+		"schema": object{"type": "integer"}},
+	"RateLimit-Reset":  object{"description": "Seconds until the window resets, when rate limiting is on", "schema": object{"type": "integer"}},
+	"RateLimit-Policy": object{"description": "The limit and window, like 100;w=60", "schema": object{"type": "string"}},
+	fiber.HeaderRetryAfter: object{"description": "Seconds to wait before trying again",
+		"schema": object{"type": "integer"}},
+	fiber.HeaderWWWAuthenticate: object{"description": "The scheme to authenticate with", "schema": object{"type": "string"}},
+	"Accept-Patch":              object{"description": "The patch types PATCH takes", "schema": object{"type": "string"}},
+}
+
+var rateLimitHeaders = []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy"}
+
+// errorHeaders are the headers sent with an error status
+var errorHeaders = map[int][]string{
+	http.StatusUnauthorized:         {fiber.HeaderWWWAuthenticate},
+	http.StatusUnsupportedMediaType: {"Accept-Patch"},
+	http.StatusTooManyRequests:      append([]string{fiber.HeaderRetryAfter}, rateLimitHeaders...),
+}
+
+func headerRefs(names []string) object {
+	refs := object{}
+	for _, name := range names {
+		refs[name] = ref("headers", name)
+	}
+	return refs
+}
+
+//------------------------------------------------------------
+// SCHEMAS
+//------------------------------------------------------------
+
+// components are the schemas reflected from the types the handlers bind
+// and send, so a field added to db.Voter shows up in the spec without
+// anyone touching this file
+var components = []struct {
+	name string
+	typ  reflect.Type
+}{
+	{"Voter", reflect.TypeOf(db.Voter{})},
+	{"VoterHistory", reflect.TypeOf(db.VoterHistory{})},
+	{"VoterResponse", reflect.TypeOf(VoterResponse{})},
+	{"VoterHistoryResponse", reflect.TypeOf(VoterHistoryResponse{})},
+	{"VoterPageResponse", reflect.TypeOf(VoterPageResponse{})},
+	{"MessageResponse", reflect.TypeOf(MessageResponse{})},
+	{"Links", reflect.TypeOf(Links{})},
+	{"Link", reflect.TypeOf(Link{})},
+	{"Problem", reflect.TypeOf(Problem{})},
+	{"FieldError", reflect.TypeOf(validate.FieldError{})},
+	{"ReadyResponse", reflect.TypeOf(ReadyResponse{})},
+	{"Check", reflect.TypeOf(Check{})},
+	{"BuildInfo", reflect.TypeOf(BuildInfo{})},
+}
+
+// handWritten are the schemas of bodies with no Go type, the health
+// answers are a fiber.Map
+var handWritten = object{
+	"Health": object{
+		"type": "object",
+		"properties": object{
+			"status":             object{"type": "string", "enum": []string{"ready", "not_ready", "shutting_down"}},
+			"version":            object{"type": "string"},
+			"build":              schemaRef("BuildInfo"),
+			"checks":             object{"type": "object", "additionalProperties": schemaRef("Check")},
+			"uptime":             object{"type": "number", "description": "Seconds since the server started"},
+			"users_processed":    object{"type": "integer", "description": "Requests served"},
+			"errors_encountered": object{"type": "integer", "description": "Requests answered with a 5xx"},
+			"_links":             schemaRef("Links"),
+		},
+		"required": []string{"status", "version", "build", "checks", "uptime", "users_processed", "errors_encountered", "_links"},
+	},
+	"Live": object{
+		"type": "object",
+		"properties": object{
+			"status":  object{"type": "string", "const": "ok"},
+			"version": object{"type": "string"},
+		},
+		"required": []string{"status", "version"},
+	},
+	"JSONPatch": arrayOf(object{
+		"type":        "object",
+		"description": "One operation of a JSON Patch, RFC 6902",
+		"properties": object{
+			"op":    object{"type": "string", "enum": []string{"add", "remove", "replace", "move", "copy", "test"}},
+			"path":  object{"type": "string"},
+			"from":  object{"type": "string"},
+			"value": object{},
+		},
+		"required": []string{"op", "path"},
+	}),
+}
+
+// schemaNotes is what reflection can't see, the rules of the Validate
+// methods and what the store does with a field.  It goes over the
+// reflected schema, properties one by one
+var schemaNotes = map[string]object{
+	"Voter": {
+		"description": "A voter as it is sent.  The answers carry a VoterResponse",
+		"required":    []string{"name", "email"},
+		"properties": object{
+			"voter_id":     object{"description": "Left out on POST /voters the store picks the next id, on PUT it comes from the path"},
+			"name":         object{"minLength": 1, "maxLength": db.MaxNameLength},
+			"email":        object{"format": "email", "maxLength": db.MaxEmailLength},
+			"vote_history": object{"description": "Each poll_id at most once"},
+			"revision":     object{"readOnly": true, "description": "Owned by the store and ignored when sent, it is the ETag of the voter"},
+		},
+	},
+	"VoterHistory": {
+		"required": []string{"vote_id", "vote_date"},
+		"properties": object{
+			"poll_id": object{"minimum": 1, "description": "Required on POST, on PUT it comes from the path"},
+			"vote_id": object{"minimum": 1},
+		},
+	},
+	"Problem": {
+		"description": "An RFC 7807 problem, sent as " + ProblemType,
+		"properties": object{
+			"code": object{"description": "A stable snake_case name for the error, like voter_not_found"},
+		},
+	},
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+var componentNames = func() map[reflect.Type]string {
+	names := make(map[reflect.Type]string)
+	for _, c := range components {
+		names[c.typ] = c.name
+	}
+	return names
+}()
+
+// schemaOf is the JSON schema of t.  A type with a component of its own
+// is a $ref to it
+func schemaOf(t reflect.Type) object {
+	if name, ok := componentNames[t]; ok {
+		return schemaRef(name)
+	}
+	return typeSchema(t)
+}
+
+func typeSchema(t reflect.Type) object {
+	if t == timeType {
+		return object{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem())
+	case reflect.String:
+		return object{"type": "string"}
+	case reflect.Bool:
+		return object{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return object{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return object{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return object{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return arrayOf(schemaOf(t.Elem()))
+	case reflect.Map:
+		return object{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		props, required := structFields(t)
+		schema := object{"type": "object", "properties": props}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return object{}
+}
+
+// structFields lists the fields of t the way encoding/json writes them.
+// The fields of an embedded struct come up a level, and a field of the
+// outer struct shadows one of the same name in it, like vote_history in
+// VoterResponse.  A field without omitempty is always there, so it is
+// required
+func structFields(t reflect.Type) (object, []string) {
+	props := object{}
+	var required []string
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if f.Anonymous && name == "" {
+			embedded = append(embedded, f.Type)
+			continue
+		}
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schemaOf(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	for _, e := range embedded {
+		inner, innerRequired := structFields(e)
+		for name, p := range inner {
+			if _, ok := props[name]; ok {
+				continue
+			}
+			props[name] = p
+			if slices.Contains(innerRequired, name) {
+				required = append(required, name)
+			}
+		}
+	}
+	slices.Sort(required)
+	return props, required
+}
+
+// withNotes lays notes over schema
+func withNotes(schema, notes object) object {
+	for k, v := range notes {
+		if k != "properties" {
+			schema[k] = v
+			continue
+		}
+		props := schema["properties"].(object)
+		for name, note := range v.(object) {
+			prop := object{}
+			for pk, pv := range props[name].(object) {
+				prop[pk] = pv
+			}
+			for pk, pv := range note.(object) {
+				prop[pk] = pv
+			}
+			props[name] = prop
+		}
+	}
+	return schema
+}
+
+func schemas() object {
+	all := object{}
+	for _, c := range components {
+		all[c.name] = withNotes(typeSchema(c.typ), schemaNotes[c.name])
+	}
+	for name, s := range handWritten {
+		all[name] = s
+	}
+	return all
+}
+
+//------------------------------------------------------------
+// THE DOCUMENT
+//------------------------------------------------------------
+
+// specPath turns a fiber route into an OpenAPI path and its parameters,
+// /voters/:id<int> is /voters/{id}
+func specPath(route string) (string, []object) {
+	var params []object
+	path := routeParam.ReplaceAllStringFunc(route, func(p string) string {
+		name, _, _ := strings.Cut(p[1:], "<")
+		params = append(params, ref("parameters", name))
+		return "{" + name + "}"
+	})
+	return path, params
+}
+
+func (op operation) responses() object {
+	rsp := object{}
+	if op.status != 0 {
+		ok := object{"description": http.StatusText(op.status)}
+		if op.result != nil {
+			resultType := op.resultType
+			if resultType == "" {
+				resultType = fiber.MIMEApplicationJSON
+			}
+			ok["content"] = object{resultType: object{"schema": op.result}}
+		}
+		hdrs := op.headers
+		if op.limited {
+			hdrs = append(slices.Clone(hdrs), rateLimitHeaders...)
+		}
+		if len(hdrs) > 0 {
+			ok["headers"] = headerRefs(hdrs)
+		}
+		rsp[strconv.Itoa(op.status)] = ok
+	}
+
+	errs := append(slices.Clone(op.errors), http.StatusInternalServerError)
+	if op.roles != nil {
+		errs = append(errs, http.StatusUnauthorized, http.StatusForbidden)
+	}
+	if op.limited {
+		errs = append(errs, http.StatusTooManyRequests)
+	}
+	if op.store {
+		errs = append(errs, http.StatusServiceUnavailable, http.StatusGatewayTimeout)
+	}
+	for _, status := range errs {
+		rsp[strconv.Itoa(status)] = ref("responses", problemName(status))
+	}
+
+	for status, r := range op.extra {
+		rsp[status] = r
+	}
+	return rsp
+}
+
+func (op operation) spec() object {
+	_, params := specPath(op.route)
+	spec := object{
+		"operationId": op.id,
+		"summary":     op.summary,
+		"tags":        []string{op.tag},
+		"responses":   op.responses(),
+	}
+	if params = append(params, op.params...); len(params) > 0 {
+		spec["parameters"] = params
+	}
+	if op.body != nil {
+		spec["requestBody"] = op.body
+	}
+
+	//An open route says so with an empty security, the voter routes take
+	//a client certificate with a role, an API key or a token
+	spec["security"] = []object{}
+	if op.roles != nil {
+		spec["security"] = []object{{"clientCert": []string{}}, {"apiKey": []string{}}, {"bearer": []string{}}}
+		spec["x-roles"] = op.roles
+		spec["description"] = "Roles: " + strings.Join(op.roles, ", ")
+	}
+	return spec
+}
+
+// openAPISpec is the OpenAPI document of every route RegisterRoutes
+// serves.  With a VoterBaseURL it is the server, otherwise the paths are
+// relative to wherever the document came from
+func openAPISpec(links LinkConfig) object {
+	paths := object{}
+	for _, op := range operations {
+		path, _ := specPath(op.route)
+		item, ok := paths[path].(object)
+		if !ok {
+			item = object{}
+			paths[path] = item
+		}
+		item[strings.ToLower(op.method)] = op.spec()
+	}
+
+	responses := object{}
+	for status, description := range problemResponses {
+		r := object{
+			"description": description,
+			"content":     object{ProblemType: object{"schema": schemaRef("Problem")}},
+		}
+		if hdrs, ok := errorHeaders[status]; ok {
+			r["headers"] = headerRefs(hdrs)
+		}
+		responses[problemName(status)] = r
+	}
+
+	spec := object{
+		"openapi": OpenAPIVersion,
+		"info": object{
+			"title":   "Voter API",
+			"version": buildInfo().Version,
+			"description": "Voters and their poll history.  Errors are " + ProblemType +
+				".  Started without API keys, client certificate roles or a JWKS every route is open, whatever security says",
+		},
+		"paths": paths,
+		"components": object{
+			"schemas":   schemas(),
+			"responses": responses,
+			"headers":   responseHeaders,
+			"parameters": object{
+				"id":     object{"name": "id", "in": "path", "required": true, "description": "The voter_id", "schema": object{"type": "integer", "minimum": 0}},
+				"pollid": object{"name": "pollid", "in": "path", "required": true, "description": "The poll_id of a history entry", "schema": object{"type": "integer", "minimum": 0}},
+				"If-Match": object{"name": "If-Match", "in": "header",
+					"description": "The ETag the change is based on.  Left out or * the change goes in whatever the revision",
+					"schema":      object{"type": "string"}},
+			},
+			"securitySchemes": object{
+				"clientCert": object{"type": "mutualTLS", "description": "A client certificate whose subject or DNS name has a role in auth.client_certs"},
+				"apiKey":     object{"type": "apiKey", "in": "header", "name": APIKeyHeader},
+				"bearer":     object{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+	if links.VoterBaseURL != "" {
+		spec["servers"] = []object{{"url": links.VoterBaseURL}}
+	}
+	return spec
+}
+
+// implementation of GET /openapi.json
+func (vt *VoterAPI) OpenAPI(c *fiber.Ctx) error {
+	return c.JSON(vt.spec)
+}
+
+// docsPage is Swagger UI, from a CDN, reading our spec
+var docsPage = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Voter API</title>
+<link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="docs"></div>
+<script src="https://cdn.jsdelivr.net/npm/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: {{.}}, dom_id: "#docs"});</script>
+</body>
+</html>
+`))
+
+// implementation of GET /docs
+func (vt *VoterAPI) Docs(c *fiber.Ctx) error {
+	c.Type("html")
+	return docsPage.Execute(c, expand(vt.links.VoterBaseURL, OpenAPIPath))
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/validate"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ProblemType is the content type of every error response, RFC 7807
+const ProblemType = "application/problem+json"
+
+// Problem is an RFC 7807 error body.  Code is ours, a stable snake_case
+// name clients can switch on rather than parsing Detail, for example
+// voter_not_found.  Errors lists the bad fields of a 422
+type Problem struct {
+	Type     string          `json:"type"`
+	Title    string          `json:"title"`
+	Status   int             `json:"status"`
+	Detail   string          `json:"detail,omitempty"`
+	Instance string          `json:"instance,omitempty"`
+	Code     string          `json:"code"`
+	Errors   validate.Errors `json:"errors,omitempty"`
+}
+
+func (p *Problem) Error() string {
+	return p.Detail
+}
+
+// newProblem is for errors that only the api knows about, like a bad
+// header.  Handlers return it and ErrorHandler writes it as is
+func newProblem(status int, code, detail string) *Problem {
+	return &Problem{Status: status, Code: code, Detail: detail}
+}
+
+// statusCode is the code for a status without a more specific one, like
+// not_found for a route that does not exist
+func statusCode(status int) string {
+	return strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+}
+
+// kindStatus is the status for each kind of db error
+var kindStatus = []struct {
+	kind   error
+	status int
+}{
+	{db.ErrNotFound, http.StatusNotFound},
+	{db.ErrConflict, http.StatusConflict},
+	{db.ErrValidation, http.StatusUnprocessableEntity},
+	{db.ErrUnavailable, http.StatusServiceUnavailable},
+	{db.ErrTimeout, http.StatusGatewayTimeout},
+	{db.ErrPrecondition, http.StatusPreconditionFailed},
+	{db.ErrInvalidInput, http.StatusBadRequest},
+}
+
+// toProblem works out the status, code and detail for err
+func toProblem(err error) *Problem {
+	var p *Problem
+	var dbErr *db.Error
+	var fe *fiber.Error
+	var errs validate.Errors
+
+	switch {
+	case errors.As(err, &p):
+		return p
+	case errors.As(err, &dbErr):
+		p = &Problem{Status: http.StatusInternalServerError, Code: dbErr.Code, Detail: dbErr.Error()}
+		for _, ks := range kindStatus {
+			if errors.Is(dbErr, ks.kind) {
+				p.Status = ks.status
+				break
+			}
+		}
+		//The cause of an unavailable or slow backend is a dial or i/o
+		//error, that stays in our log
+		if p.Status == http.StatusServiceUnavailable || p.Status == http.StatusGatewayTimeout {
+			p.Detail = dbErr.Msg
+		}
+	case errors.As(err, &errs):
+		p = newProblem(http.StatusUnprocessableEntity, "validation_failed", "validation failed")
+	case errors.Is(err, validate.ErrMalformed):
+		p = newProblem(http.StatusBadRequest, "malformed_body", err.Error())
+	case errors.As(err, &fe):
+		p = newProblem(fe.Code, statusCode(fe.Code), "")
+
+		//fiber.NewError(status) sets the message to the status text,
+		//which is already the title
+		if fe.Message != http.StatusText(fe.Code) {
+			p.Detail = fe.Message
+		}
+	default:
+		return newProblem(http.StatusInternalServerError, "internal_error", "")
+	}
+
+	//A patch that left the voter invalid carries the field errors too
+	if errors.As(err, &errs) {
+		p.Errors = errs
+	}
+	return p
+}
+
+// ErrorHandler is the fiber ErrorHandler.  Every error a handler returns,
+// and fiber's own like an unknown route, goes out as problem+json
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	p := toProblem(err)
+	if p.Status >= http.StatusInternalServerError {
+		slog.ErrorContext(c.UserContext(), "Error handling request", "method", c.Method(), "path", c.Path(), "err", err)
+	}
+
+	res := *p
+	res.Type = "about:blank"
+	res.Title = http.StatusText(res.Status)
+	res.Instance = c.Path()
+	return c.Status(res.Status).JSON(res, ProblemType)
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitKeyPrefix is in front of every bucket key, after the redis
+// key_prefix
+const RateLimitKeyPrefix = "ratelimit:"
+
+// takeScript is the token bucket of localBuckets.take, run in redis so two
+// instances can't both take the last token.  The clock is redis's, so the
+// instances don't have to agree on the time.  The bucket expires once it
+// would be full again, a missing bucket is a full one.
+//
+// KEYS[1] is the bucket, ARGV the limit and the window in ms.  It returns
+// whether the request is allowed and the tokens left, as a string because
+// redis turns lua numbers into integers
+var takeScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = limit
+if b[1] then
+  local elapsed = math.max(0, now - tonumber(b[2]))
+  tokens = math.min(limit, tonumber(b[1]) + elapsed * limit / window)
+end
+
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], window)
+return {allowed, tostring(tokens)}
+`)
+
+// redisBuckets keeps the buckets in redis, shared by every instance
+type redisBuckets struct {
+	client    *redis.Client
+	keyPrefix string
+}
+
+func newRedisBuckets(client *redis.Client, keyPrefix string) *redisBuckets {
+	return &redisBuckets{client: client, keyPrefix: keyPrefix}
+}
+
+func (r *redisBuckets) take(ctx context.Context, key string, p RatePolicy) (rateResult, error) {
+	res, err := takeScript.Run(ctx, r.client, []string{r.keyPrefix + RateLimitKeyPrefix + key},
+		p.Limit, p.Window.Milliseconds()).Slice()
+	if err != nil {
+		return rateResult{}, err
+	}
+	if len(res) != 2 {
+		return rateResult{}, fmt.Errorf("rate limit script returned %v", res)
+	}
+
+	allowed, _ := res[0].(int64)
+	left, _ := res[1].(string)
+	tokens, err := strconv.ParseFloat(left, 64)
+	if err != nil {
+		return rateResult{}, fmt.Errorf("rate limit script returned %v: %w", res, err)
+	}
+	return bucketResult(p, tokens, allowed == 1), nil
+}
@@ -0,0 +1,401 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// Where the rate limit buckets are kept
+const (
+	RateLimitNone   = "none"
+	RateLimitMemory = "memory"
+	RateLimitRedis  = "redis"
+)
+
+const (
+	//redisRetry is how long we limit locally after redis fails before we
+	//try it again, so a dead redis costs one timeout now and then rather
+	//than one on every request
+	redisRetry = 5 * time.Second
+
+	//rateLimitTimeout caps a redis round trip.  A request would rather be
+	//limited locally than wait on a slow redis
+	rateLimitTimeout = 250 * time.Millisecond
+
+	//sweepEvery is how often the local buckets that filled up again are
+	//dropped
+	sweepEvery = time.Minute
+)
+
+// RatePolicy lets Limit requests through per Window.  It is a token
+// bucket: a client can use the whole limit at once, after that it gets one
+// request every Window/Limit.  A Limit of 0 is no limit
+type RatePolicy struct {
+	Limit  int
+	Window time.Duration
+}
+
+// RateLimitConfig is the policy of every limited route, and where the
+// buckets are kept
+type RateLimitConfig struct {
+	//Store is RateLimitMemory for buckets per instance or RateLimitRedis
+	//to share them between instances
+	Store string
+
+	//Default applies to the routes not in Routes, which is keyed like
+	//"POST /voters/:id/polls", the route pattern without the <int>
+	Default RatePolicy
+	Routes  map[string]RatePolicy
+
+	//PerIP applies to every limited route together, per client address.
+	//It is counted before the credentials are checked, so a client
+	//guessing keys or tokens runs into it too
+	PerIP RatePolicy
+
+	//IPHeader is where the load balancer puts the client address, like
+	//X-Forwarded-For.  It is only read when the connection comes from one
+	//of TrustedProxies, IPs or CIDRs, otherwise the address of the
+	//connection is the client
+	IPHeader       string
+	TrustedProxies []string
+
+	//Redis is how to reach redis for RateLimitRedis
+	Redis db.RedisConfig
+}
+
+// rateResult is one take from a bucket
+type rateResult struct {
+	allowed    bool
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+// bucketResult works out the headers from what is left in a bucket
+func bucketResult(p RatePolicy, tokens float64, allowed bool) rateResult {
+	perToken := p.Window / time.Duration(p.Limit)
+	r := rateResult{
+		allowed:   allowed,
+		remaining: int(math.Floor(tokens)),
+		reset:     time.Duration((float64(p.Limit) - tokens) * float64(perToken)),
+	}
+	if !allowed {
+		r.retryAfter = time.Duration((1 - tokens) * float64(perToken))
+	}
+	return r
+}
+
+// bucketStore takes a token from the bucket of key
+type bucketStore interface {
+	take(ctx context.Context, key string, p RatePolicy) (rateResult, error)
+}
+
+// localBucket is a bucket kept in memory
+type localBucket struct {
+	tokens float64
+	last   time.Time
+	policy RatePolicy
+}
+
+// localBuckets keeps the buckets of this instance only
+type localBuckets struct {
+	mu        sync.Mutex
+	buckets   map[string]*localBucket
+	nextSweep time.Time
+}
+
+func newLocalBuckets() *localBuckets {
+	return &localBuckets{buckets: make(map[string]*localBucket), nextSweep: time.Now().Add(sweepEvery)}
+}
+
+func (l *localBuckets) take(_ context.Context, key string, p RatePolicy) (rateResult, error) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &localBucket{tokens: float64(p.Limit), last: now, policy: p}
+		l.buckets[key] = b
+	}
+
+	//Refill for the time since the last take, up to the limit
+	rate := float64(p.Limit) / float64(p.Window)
+	b.tokens = math.Min(float64(p.Limit), b.tokens+float64(now.Sub(b.last))*rate)
+	b.last = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return bucketResult(p, b.tokens, allowed), nil
+}
+
+// sweep drops the buckets that are full again, a new one is the same.
+// Without it every client we ever saw would stay in the map
+func (l *localBuckets) sweep(now time.Time) {
+	if now.Before(l.nextSweep) {
+		return
+	}
+	l.nextSweep = now.Add(sweepEvery)
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= b.policy.Window {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RateLimiter limits requests per client and route.  With redis the
+// buckets are shared by every instance.  When redis is down we fall back
+// to buckets of our own, so each instance lets the limit through until
+// redis is back
+type RateLimiter struct {
+	cfg     RateLimitConfig
+	proxies []netip.Prefix
+	local   *localBuckets
+	shared  bucketStore
+	client  *redis.Client
+
+	//downUntil is when to try redis again after it failed
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+// NewRateLimiter makes the limiter of cfg.  A redis that is down now is not
+// an error, we limit locally until it is up
+func NewRateLimiter(cfg RateLimitConfig) *RateLimiter {
+	rl := &RateLimiter{cfg: cfg, local: newLocalBuckets()}
+	for _, p := range cfg.TrustedProxies {
+		prefix, err := ParseTrustedProxy(p)
+		if err != nil {
+			slog.Warn("Ignoring trusted proxy", "proxy", p, "err", err)
+			continue
+		}
+		rl.proxies = append(rl.proxies, prefix)
+	}
+	if cfg.Store == RateLimitRedis {
+		rl.client = redis.NewClient(db.RedisOptions(cfg.Redis))
+		db.TraceRedis(rl.client)
+		rl.shared = newRedisBuckets(rl.client, cfg.Redis.KeyPrefix)
+	}
+	return rl
+}
+
+// Close closes the redis connection, if there is one
+func (rl *RateLimiter) Close() error {
+	if rl.client == nil {
+		return nil
+	}
+	return rl.client.Close()
+}
+
+// policy is the policy of a route, from the method and route pattern
+func (rl *RateLimiter) policy(method, path string) (string, RatePolicy) {
+	key := routeKey(method, path)
+	if p, ok := rl.cfg.Routes[key]; ok {
+		return key, p
+	}
+	return key, rl.cfg.Default
+}
+
+// routeKey is how a route is named in RateLimitConfig.Routes, the method
+// and the pattern without the <int> constraints
+func routeKey(method, path string) string {
+	var b strings.Builder
+	b.WriteString(method + " ")
+	for {
+		start := strings.IndexByte(path, '<')
+		end := strings.IndexByte(path, '>')
+		if start < 0 || end < start {
+			break
+		}
+		b.WriteString(path[:start])
+		path = path[end+1:]
+	}
+	b.WriteString(path)
+	return b.String()
+}
+
+// ParseTrustedProxy reads a trusted proxy, an IP or a CIDR
+func ParseTrustedProxy(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	ip = ip.Unmap()
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+// trusted is true when ip is one of our proxies
+func (rl *RateLimiter) trusted(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, p := range rl.proxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP is the address of the client.  Every proxy appends the address
+// it got the request from to IPHeader, so walking it from the right the
+// first address that is not one of our proxies is the client.  Anything
+// left of that was sent by the client and can't be trusted
+func (rl *RateLimiter) clientIP(c *fiber.Ctx) string {
+	client, _ := netip.AddrFromSlice(c.Context().RemoteIP())
+	if rl.cfg.IPHeader == "" || !rl.trusted(client) {
+		return client.Unmap().String()
+	}
+
+	hops := strings.Split(c.Get(rl.cfg.IPHeader), ",")
+	for i := len(hops) - 1; i >= 0 && rl.trusted(client); i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			//Garbage from the client, the proxy before it is all we know
+			break
+		}
+		client = ip
+	}
+	return client.Unmap().String()
+}
+
+// clientKey is who a request is counted against: the API key or token
+// subject when there is one, the client address when not
+func (rl *RateLimiter) clientKey(c *fiber.Ctx) string {
+	if p := CurrentPrincipal(c); p != nil && p.Subject != "" {
+		return p.Method + ":" + p.Subject
+	}
+	return "ip:" + rl.clientIP(c)
+}
+
+// take counts a request.  Redis failing is logged once when it goes down
+// and once when it is back, in between we don't ask it
+func (rl *RateLimiter) take(ctx context.Context, key string, p RatePolicy) rateResult {
+	if rl.shared != nil {
+		rl.mu.Lock()
+		down := time.Now().Before(rl.downUntil)
+		rl.mu.Unlock()
+
+		if !down {
+			ctx, cancel := context.WithTimeout(ctx, rateLimitTimeout)
+			r, err := rl.shared.take(ctx, key, p)
+			cancel()
+
+			rl.mu.Lock()
+			wasDown := !rl.downUntil.IsZero()
+			if err == nil {
+				if wasDown {
+					slog.Info("Rate limiter is using redis again")
+					rl.downUntil = time.Time{}
+				}
+				rl.mu.Unlock()
+				return r
+			}
+			if !wasDown {
+				slog.WarnContext(ctx, "Error using redis for rate limits, limiting locally", "err", err)
+			}
+			rl.downUntil = time.Now().Add(redisRetry)
+			rl.mu.Unlock()
+		}
+	}
+
+	r, _ := rl.local.take(ctx, key, p)
+	return r
+}
+
+// checkRoutes logs the policies in Routes that match no route of app, most
+// likely a typo
+func (rl *RateLimiter) checkRoutes(app *fiber.App) {
+	served := map[string]bool{}
+	for _, r := range app.GetRoutes(true) {
+		served[routeKey(r.Method, r.Path)] = true
+	}
+	for key := range rl.cfg.Routes {
+		if !served[key] {
+			slog.Warn("Rate limit policy matches no route", "route", key)
+		}
+	}
+}
+
+// UseRateLimit turns on rate limiting.  Call it before RegisterRoutes,
+// without it no route is limited
+func (vt *VoterAPI) UseRateLimit(rl *RateLimiter) {
+	vt.limiter = rl
+}
+
+// ipLimit counts the request against the client address, whoever it
+// claims to be.  It goes before allow, so requests with bad credentials are
+// limited too
+func (vt *VoterAPI) ipLimit() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rl := vt.limiter
+		if rl == nil || rl.cfg.PerIP.Limit <= 0 {
+			return c.Next()
+		}
+		ip := rl.clientIP(c)
+		return vt.limit(c, "ip "+ip, "requests from "+ip, rl.cfg.PerIP)
+	}
+}
+
+// rateLimit counts the request against its client and route, and answers
+// 429 once the bucket is empty.  It goes after allow, so it knows the
+// client's API key or token
+func (vt *VoterAPI) rateLimit() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rl := vt.limiter
+		if rl == nil {
+			return c.Next()
+		}
+
+		route, p := rl.policy(c.Method(), c.Route().Path)
+		if p.Limit <= 0 {
+			return c.Next()
+		}
+		return vt.limit(c, route+" "+rl.clientKey(c), "requests to "+route, p)
+	}
+}
+
+// limit takes a token from the bucket of key and sets the headers.  what
+// names the bucket in the 429
+func (vt *VoterAPI) limit(c *fiber.Ctx, key, what string, p RatePolicy) error {
+	r := vt.limiter.take(c.UserContext(), key, p)
+
+	//The RateLimit headers of the IETF draft, the reset is the seconds
+	//until the bucket is full again.  The route's bucket is counted last,
+	//so its headers are the ones the client sees
+	c.Set("RateLimit-Limit", strconv.Itoa(p.Limit))
+	c.Set("RateLimit-Remaining", strconv.Itoa(r.remaining))
+	c.Set("RateLimit-Reset", strconv.Itoa(seconds(r.reset)))
+	c.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", p.Limit, seconds(p.Window)))
+	if r.allowed {
+		return c.Next()
+	}
+
+	vt.metrics.limited.WithLabelValues(c.Method(), c.Route().Path).Inc()
+	retry := seconds(r.retryAfter)
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retry))
+	return newProblem(http.StatusTooManyRequests, "rate_limited",
+		fmt.Sprintf("too many %s, retry in %ds", what, retry))
+}
+
+// seconds rounds up, so a client that waits that long finds a token
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"drexel.edu/todo/logging"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequestIDHeader carries the request ID.  We take the caller's, so a
+// request can be followed through the services, or make one up
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen caps a request ID we take from a caller
+const maxRequestIDLen = 128
+
+// validRequestID is true for IDs we are happy to log as they are: not too
+// long, and only letters, digits and -_.:
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID is 16 random bytes in hex
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestID puts the request ID in the request context, where the
+// handlers and the store log it from, and in the response
+func (vt *VoterAPI) requestID() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set(RequestIDHeader, id)
+		c.SetUserContext(logging.WithRequestID(c.UserContext(), id))
+		return c.Next()
+	}
+}
+
+// probePaths are logged at debug, they are called every few seconds
+var probePaths = map[string]bool{
+	LivezPath:       true,
+	ReadyzPath:      true,
+	MetricsPath:     true,
+	VoterHealthPath: true,
+}
+
+// accessLog logs every request once it is answered.  Like
+// metrics.middleware it runs the ErrorHandler itself, so it logs the
+// status the client really gets
+func (vt *VoterAPI) accessLog() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		self := c.Route()
+		start := time.Now()
+
+		record := func(status int) {
+			//fiber folds the app.Use calls in a row into one route, so as
+			//in metrics.middleware, still being on it means no route matched
+			route := c.Route().Path
+			if c.Route() == self {
+				route = unmatchedRoute
+			}
+
+			level := slog.LevelInfo
+			switch {
+			case status >= http.StatusInternalServerError:
+				level = slog.LevelError
+			case probePaths[route]:
+				level = slog.LevelDebug
+			}
+			slog.LogAttrs(c.UserContext(), level, "request",
+				slog.String("method", c.Method()),
+				slog.String("route", route),
+				slog.String("path", c.Path()),
+				slog.Int("status", status),
+				slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.Int("bytes", len(c.Response().Body())),
+				slog.String("ip", c.IP()),
+			)
+		}
+
+		//A panic goes up to the recover middleware, log it as the 500 it
+		//turns into
+		defer func() {
+			if r := recover(); r != nil {
+				record(http.StatusInternalServerError)
+				panic(r)
+			}
+		}()
+
+		if err := c.Next(); err != nil {
+			if err := c.App().ErrorHandler(c, err); err != nil {
+				_ = c.SendStatus(http.StatusInternalServerError)
+			}
+		}
+		record(c.Response().StatusCode())
+		return nil
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import "github.com/gofiber/fiber/v2"
+
+// Route table.  RegisterRoutes serves the handlers on these patterns and
+// the _links are built from the same patterns, so a link can never point
+// at a path we do not serve
+const (
+	VotersPath      = "/voters"
+	VoterPath       = "/voters/:id<int>"
+	VoterPollsPath  = "/voters/:id<int>/polls"
+	VoterPollPath   = "/voters/:id<int>/polls/:pollid<int>"
+	VoterHealthPath = "/voters/health"
+	VoterSearchPath = "/voters/search"
+	MetricsPath     = "/metrics"
+	LivezPath       = "/livez"
+	ReadyzPath      = "/readyz"
+	OpenAPIPath     = "/openapi.json"
+	DocsPath        = "/docs"
+
+	//Served by the polls and votes services
+	PollsPath         = "/polls"
+	PollPath          = "/polls/:id<int>"
+	VotesPath         = "/votes"
+	VoterVotesPath    = "/votes/voterid/:voterid<int>"
+	VoterPollVotePath = "/votes/voterid/:voterid<int>/pollid/:pollid<int>"
+)
+
+// The roles of each group of routes, the OpenAPI spec lists them too
+var (
+	adminRoles    = []string{RoleAdmin}
+	staffRoles    = []string{RoleAdmin, RoleClerk}
+	readOwnRoles  = []string{RoleAdmin, RoleClerk, RoleVoter}
+	writeOwnRoles = []string{RoleAdmin, RoleVoter}
+)
+
+// RegisterRoutes adds every voter route to app.  Both the in-memory and
+// the redis binaries call this so they always serve the same API
+func (vt *VoterAPI) RegisterRoutes(app *fiber.App) {
+
+	//The request ID and the client certificate first, so everything after
+	//them logs with them, then the span, so the access log line has the
+	//trace_id too
+	app.Use(vt.requestID())
+	app.Use(vt.clientSubject())
+	app.Use(vt.trace())
+	app.Use(vt.accessLog())
+
+	//Has to come after the other middleware and before the routes, see
+	//metrics.middleware
+	app.Use(vt.metrics.middleware())
+
+	//HTTP Standards for "REST" APIS
+	//GET - Read/Query
+	//POST - Create
+	//PUT - Update
+	//PATCH - Partial update
+	//DELETE - Delete
+
+	//The client address is counted before anything looks at the
+	//credentials, see ipLimit
+	ip := vt.ipLimit()
+
+	//Who may call what, see allow.  Deletes and updates of poll history
+	//are admin only, a voter gets to its own record and nothing else
+	admin := vt.allow(adminRoles...)
+	staff := vt.allow(staffRoles...)
+	readOwn := vt.allow(readOwnRoles...)
+	writeOwn := vt.allow(writeOwnRoles...)
+
+	//Every voter route is also rate limited per client, see rateLimit
+	limited := vt.rateLimit()
+
+	app.Put(VoterPath, ip, writeOwn, limited, vt.UpdateVoters)
+	app.Put(VoterPollPath, ip, admin, limited, vt.UpdateVotersPoll)
+	app.Patch(VoterPath, ip, writeOwn, limited, vt.PatchVoters)
+	app.Delete(VoterPath, ip, admin, limited, vt.DeleteVoters)
+	app.Delete(VoterPollPath, ip, admin, limited, vt.DeleteVotersPoll)
+	app.Delete(VotersPath, ip, admin, limited, vt.DeleteAllVoters)
+	app.Get(VotersPath, ip, staff, limited, vt.ListAllVoters)
+	app.Get(VoterSearchPath, ip, staff, limited, vt.SearchVoters)
+	app.Get(VoterPath, ip, readOwn, limited, vt.GetVoters)
+	app.Get(VoterPollsPath, ip, readOwn, limited, vt.GetVotersPoll)
+	app.Get(VoterPollPath, ip, readOwn, limited, vt.GetVotersPollId)
+	app.Post(VotersPath, ip, staff, limited, vt.AddVoters)
+	app.Post(VoterPollsPath, ip, staff, limited, vt.AddVotersPoll)
+
+	app.Get("/crash", admin, vt.CrashSim)
+	app.Get("/crash2", admin, vt.CrashSim2)
+	app.Get("/crash3", admin, vt.CrashSim3)
+
+	//The probes, health and metrics stay open, the things that call them
+	//have no credentials
+	app.Get(VoterHealthPath, vt.HealthCheck)
+	app.Get(MetricsPath, vt.metrics.handler())
+	app.Get(LivezPath, vt.Livez)
+	app.Get(ReadyzPath, vt.Readyz)
+
+	//So are the spec and its docs, a client reads them before it has
+	//credentials.  tests/openapi fails on a route the spec is missing
+	app.Get(OpenAPIPath, vt.OpenAPI)
+	app.Get(DocsPath, vt.Docs)
+
+	if vt.limiter != nil {
+		vt.limiter.checkRoutes(app)
+	}
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DefaultDrainTimeout is how long Serve waits for in-flight requests once
+// shutdown starts
+const DefaultDrainTimeout = 10 * time.Second
+
+// DefaultPreStop is how long Serve keeps taking requests after /readyz
+// turns 503, so load balancers have seen it before the listener closes
+const DefaultPreStop = 5 * time.Second
+
+// ShutdownConfig is how Serve stops.  For PreStop it answers 503 on /readyz
+// but still serves everything else, then it closes the listener and gives
+// the requests already running up to Drain to finish
+type ShutdownConfig struct {
+	PreStop time.Duration
+	Drain   time.Duration
+}
+
+// Shutdown marks the API as going away.  From here on /readyz answers 503,
+// so load balancers stop sending us new requests
+func (vt *VoterAPI) Shutdown() {
+	vt.shuttingDown.Store(true)
+}
+
+// Close closes the store, the file store writes its snapshot here, and the
+// rate limiter's redis connection
+func (vt *VoterAPI) Close() error {
+	if vt.limiter != nil {
+		if err := vt.limiter.Close(); err != nil {
+			slog.Error("Error closing the rate limiter", "err", err)
+		}
+	}
+	return vt.db.Close()
+}
+
+// Serve runs app on addr until ctx is done, the mains cancel it on SIGINT
+// or SIGTERM.  Then /readyz turns 503 and after sc.PreStop it stops
+// accepting connections, gives the requests already running up to
+// sc.Drain to finish and closes the store.  The error is from Listen, or
+// from closing the store
+func (vt *VoterAPI) Serve(ctx context.Context, app *fiber.App, addr string, sc ShutdownConfig) error {
+	return vt.ServeTLS(ctx, app, addr, sc, nil)
+}
+
+// ServeTLS is Serve over HTTPS with the certificates of certs, which it
+// keeps reloading until ctx is done.  With nil certs it is plain HTTP
+func (vt *VoterAPI) ServeTLS(ctx context.Context, app *fiber.App, addr string, sc ShutdownConfig, certs *CertReloader) error {
+	ln, err := net.Listen(app.Config().Network, addr)
+	if err != nil {
+		vt.Close()
+		return err
+	}
+	if certs != nil {
+		ln = tls.NewListener(ln, certs.TLSConfig())
+		go certs.Watch(ctx)
+	}
+
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- app.Listener(ln)
+	}()
+
+	select {
+	case err := <-listenErr:
+		vt.Close()
+		return err
+	case <-ctx.Done():
+	}
+
+	//Load balancers only see the 503 on their next probe, until then they
+	//keep sending us requests and we keep serving them
+	vt.Shutdown()
+	if sc.PreStop > 0 {
+		slog.Info("Shutting down, waiting for load balancers", "pre_stop", sc.PreStop.String())
+		time.Sleep(sc.PreStop)
+	}
+
+	slog.Info("Shutting down, draining requests", "drain", sc.Drain.String())
+	if err := app.ShutdownWithTimeout(sc.Drain); err != nil {
+		//The stragglers are cut off, we still close the store so the
+		//file store snapshot gets written
+		slog.Error("Error draining requests", "err", err)
+	}
+	<-listenErr
+
+	if err := vt.Close(); err != nil {
+		return err
+	}
+	slog.Info("Server stopped")
+	return nil
+}
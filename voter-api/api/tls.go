@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"drexel.edu/todo/logging"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Client certificate modes
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+// TLSConfig is where the server certificate and key are, and for mTLS the
+// CA bundle client certificates are verified against
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+
+	//ClientAuth is ClientAuthNone, ClientAuthOptional to verify a client
+	//certificate if one is sent, or ClientAuthRequire
+	ClientAuth string
+
+	//ReloadInterval is how often the files are checked for changes, 0
+	//loads them once
+	ReloadInterval time.Duration
+}
+
+// certState is one load of the files
+type certState struct {
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	stamp     string
+}
+
+// CertReloader serves the certificate and client CAs from TLSConfig and
+// picks up new files without a restart.  A handshake always sees one
+// consistent load, a bad set of files is logged and the last good one kept
+type CertReloader struct {
+	cfg   TLSConfig
+	state atomic.Pointer[certState]
+
+	//mu serialises Reload.  badStamp is the files that last failed to
+	//load, so we don't try and log them again on every tick
+	mu       sync.Mutex
+	badStamp string
+}
+
+// NewCertReloader loads the files once, so a bad certificate stops us at
+// startup rather than on the first handshake
+func NewCertReloader(cfg TLSConfig) (*CertReloader, error) {
+	r := &CertReloader{cfg: cfg}
+	s, err := r.load()
+	if err != nil {
+		return nil, err
+	}
+	r.state.Store(s)
+	return r, nil
+}
+
+// stamp is the size and modification time of every file.  When it changes
+// we reload.  Stat follows symlinks, so a kubernetes secret swapping its
+// ..data link counts as a change
+func (r *CertReloader) stamp() (string, error) {
+	stamp := ""
+	for _, f := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.ClientCAFile} {
+		if f == "" {
+			continue
+		}
+		info, err := os.Stat(f)
+		if err != nil {
+			return "", err
+		}
+		stamp += fmt.Sprintf("%s:%d:%d;", f, info.Size(), info.ModTime().UnixNano())
+	}
+	return stamp, nil
+}
+
+func (r *CertReloader) load() (*certState, error) {
+	stamp, err := r.stamp()
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	s := &certState{cert: &cert, stamp: stamp}
+
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		s.clientCAs = x509.NewCertPool()
+		if !s.clientCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates in " + r.cfg.ClientCAFile)
+		}
+	}
+	return s, nil
+}
+
+// Reload loads the files again if they changed since the last load.  It
+// returns whether the certificates were replaced
+func (r *CertReloader) Reload() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stamp, err := r.stamp()
+	if err != nil {
+		return false, err
+	}
+	if stamp == r.state.Load().stamp || stamp == r.badStamp {
+		return false, nil
+	}
+
+	s, err := r.load()
+	if err != nil {
+		r.badStamp = stamp
+		return false, err
+	}
+	r.state.Store(s)
+	return true, nil
+}
+
+// Watch calls Reload every ReloadInterval until ctx is done
+func (r *CertReloader) Watch(ctx context.Context) {
+	if r.cfg.ReloadInterval <= 0 {
+		return
+	}
+
+	tick := time.NewTicker(r.cfg.ReloadInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+
+		//A half written certificate fails to load, we keep the old one
+		//and try again on the next tick
+		reloaded, err := r.Reload()
+		if err != nil {
+			slog.Error("Error reloading certificates", "err", err)
+		} else if reloaded {
+			slog.Info("Reloaded certificates", "cert_file", r.cfg.CertFile)
+		}
+	}
+}
+
+// TLSConfig is the server side tls.Config.  Every handshake asks for the
+// current state, so a reload applies to new connections right away
+func (r *CertReloader) TLSConfig() *tls.Config {
+	clientAuth := tls.NoClientCert
+	switch r.cfg.ClientAuth {
+	case ClientAuthOptional:
+		clientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			s := r.state.Load()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*s.cert},
+				ClientAuth:   clientAuth,
+				ClientCAs:    s.clientCAs,
+			}, nil
+		},
+	}
+}
+
+// ClientCert is the verified client certificate of the request, nil over
+// plain HTTP or when the client sent none
+func ClientCert(c *fiber.Ctx) *x509.Certificate {
+	state := c.Context().TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
+// ClientSubject is the subject of the verified client certificate, like
+// CN=polls-api,O=Voting.  Empty when there is none
+func ClientSubject(c *fiber.Ctx) string {
+	cert := ClientCert(c)
+	if cert == nil {
+		return ""
+	}
+	return cert.Subject.String()
+}
+
+// clientSubject puts the subject of the verified client certificate in the
+// request context, so every line logged for the request has it
+func (vt *VoterAPI) clientSubject() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if s := ClientSubject(c); s != "" {
+			c.SetUserContext(logging.WithClientSubject(c.UserContext(), s))
+		}
+		return c.Next()
+	}
+}
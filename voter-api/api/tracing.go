@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http"
+
+	"drexel.edu/todo/tracing"
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// headerCarrier lets the propagator read the traceparent and tracestate
+// headers of a fiber request
+type headerCarrier struct {
+	c *fiber.Ctx
+}
+
+func (h headerCarrier) Get(key string) string {
+	return h.c.Get(key)
+}
+
+func (h headerCarrier) Set(key, value string) {
+	h.c.Request().Header.Set(key, value)
+}
+
+func (h headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(h.c.GetReqHeaders()))
+	for k := range h.c.GetReqHeaders() {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// trace starts the server span of a request.  A request with a W3C
+// traceparent header joins that trace, anything else starts a new one.
+// The span goes in the request context, so the store spans end up under
+// it and log lines carry its trace_id.
+//
+// The route is only known once the chain ran, so the span is renamed to
+// it at the end.  Like accessLog it tells an unmatched request apart by
+// still being on its own route
+func (vt *VoterAPI) trace() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		self := c.Route()
+		ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), headerCarrier{c})
+		ctx, span := tracing.Tracer().Start(ctx, c.Method(),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Method()),
+				semconv.URLPath(c.Path()),
+				semconv.ClientAddress(c.IP()),
+			))
+		c.SetUserContext(ctx)
+
+		finish := func(status int) {
+			route := c.Route().Path
+			if c.Route() == self {
+				route = unmatchedRoute
+			}
+			span.SetName(c.Method() + " " + route)
+			span.SetAttributes(semconv.HTTPRoute(route), semconv.HTTPResponseStatusCode(status))
+			if status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(status))
+			}
+			span.End()
+		}
+
+		//A panic goes up to the recover middleware and turns into a 500
+		defer func() {
+			if r := recover(); r != nil {
+				finish(http.StatusInternalServerError)
+				panic(r)
+			}
+		}()
+
+		//accessLog, after us, already ran the ErrorHandler on an error,
+		//so the status is the one the client gets
+		err := c.Next()
+		finish(c.Response().StatusCode())
+		return err
+	}
+}
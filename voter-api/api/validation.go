@@ -0,0 +1,51 @@
+package api
+
+import (
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/validate"
+	"github.com/gofiber/fiber/v2"
+)
+
+// bindVoter strictly decodes a voter body and runs its rules.  pathId is
+// the :id of the route, or 0 for POST /voters.  A body without a voter_id
+// takes the one from the path, a different one is an error rather than
+// writing the voter under the wrong key.  Handlers return its errors as
+// they are, ErrorHandler makes bad fields a 422 and a body that isn't JSON
+// a 400
+func bindVoter(c *fiber.Ctx, pathId uint) (db.Voter, error) {
+	var voter db.Voter
+	if err := validate.Decode(c.Body(), &voter); err != nil {
+		return voter, err
+	}
+
+	var errs validate.Errors
+	if pathId != 0 {
+		if voter.VoterId == 0 {
+			voter.VoterId = pathId
+		} else if voter.VoterId != pathId {
+			errs.Add("voter_id", "must match the id in the path")
+		}
+	}
+	errs.Nest("", voter.Validate())
+	return voter, errs.Err()
+}
+
+// bindHistory is bindVoter for a poll history entry, pathPollId is the
+// :pollid of the route or 0 for POST
+func bindHistory(c *fiber.Ctx, pathPollId uint) (db.VoterHistory, error) {
+	var voterHistory db.VoterHistory
+	if err := validate.Decode(c.Body(), &voterHistory); err != nil {
+		return voterHistory, err
+	}
+
+	var errs validate.Errors
+	if pathPollId != 0 {
+		if voterHistory.PollId == 0 {
+			voterHistory.PollId = pathPollId
+		} else if voterHistory.PollId != pathPollId {
+			errs.Add("poll_id", "must match the pollid in the path")
+		}
+	}
+	errs.Nest("", voterHistory.Validate())
+	return voterHistory, errs.Err()
+}
@@ -0,0 +1,632 @@
+// Package config loads the voter API settings.  Every setting has a
+// default and can be set in a YAML or TOML file, with an env var and with a
+// flag.  Each of those overrides the one before it, so a flag always wins
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/logging"
+	"drexel.edu/todo/tracing"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is every setting of the voter API.  The yaml and toml names are
+// the keys of the config file
+type Config struct {
+	Host      string `yaml:"host" toml:"host"`
+	Port      uint   `yaml:"port" toml:"port"`
+	Store     string `yaml:"store" toml:"store"`
+	LogLevel  string `yaml:"log_level" toml:"log_level"`
+	LogFormat string `yaml:"log_format" toml:"log_format"`
+
+	TLS       TLSConfig       `yaml:"tls" toml:"tls"`
+	Auth      AuthConfig      `yaml:"auth" toml:"auth"`
+	RateLimit RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+	Tracing   TracingConfig   `yaml:"tracing" toml:"tracing"`
+	Timeouts  TimeoutConfig   `yaml:"timeouts" toml:"timeouts"`
+	CORS      CORSConfig      `yaml:"cors" toml:"cors"`
+	Links     LinksConfig     `yaml:"links" toml:"links"`
+	File      FileConfig      `yaml:"file" toml:"file"`
+	Redis     RedisConfig     `yaml:"redis" toml:"redis"`
+}
+
+// TLSConfig turns on HTTPS when a certificate and key are set, see
+// api.TLSConfig
+type TLSConfig struct {
+	CertFile       string        `yaml:"cert_file" toml:"cert_file"`
+	KeyFile        string        `yaml:"key_file" toml:"key_file"`
+	ClientCAFile   string        `yaml:"client_ca_file" toml:"client_ca_file"`
+	ClientAuth     string        `yaml:"client_auth" toml:"client_auth"`
+	ReloadInterval time.Duration `yaml:"reload_interval" toml:"reload_interval"`
+}
+
+// Enabled is true when we serve HTTPS
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// AuthConfig turns on authentication when there are API keys, client
+// certificate roles or a JWKS file, see api.AuthConfig
+type AuthConfig struct {
+	APIKeys     []APIKey   `yaml:"api_keys" toml:"api_keys"`
+	ClientCerts []CertRole `yaml:"client_certs" toml:"client_certs"`
+	JWKSFile    string     `yaml:"jwks_file" toml:"jwks_file"`
+	Issuer      string     `yaml:"issuer" toml:"issuer"`
+	Audience    string     `yaml:"audience" toml:"audience"`
+}
+
+// APIKey is one static key and the role it has
+type APIKey struct {
+	Name string `yaml:"name" toml:"name"`
+	Key  string `yaml:"key" toml:"key"`
+	Role string `yaml:"role" toml:"role"`
+}
+
+// CertRole is the role of the client certificates with a subject or DNS
+// name, see api.CertRole
+type CertRole struct {
+	Subject string `yaml:"subject" toml:"subject"`
+	Role    string `yaml:"role" toml:"role"`
+}
+
+// Enabled is true when requests have to authenticate
+func (a AuthConfig) Enabled() bool {
+	return len(a.APIKeys) > 0 || len(a.ClientCerts) > 0 || a.JWKSFile != ""
+}
+
+// RateLimitConfig turns on rate limiting unless the store is none, see
+// api.RateLimitConfig.  The redis store connects with the redis settings
+type RateLimitConfig struct {
+	Store          string                `yaml:"store" toml:"store"`
+	Limit          int                   `yaml:"limit" toml:"limit"`
+	Window         time.Duration         `yaml:"window" toml:"window"`
+	Routes         map[string]RatePolicy `yaml:"routes" toml:"routes"`
+	PerIP          RatePolicy            `yaml:"per_ip" toml:"per_ip"`
+	IPHeader       string                `yaml:"ip_header" toml:"ip_header"`
+	TrustedProxies []string              `yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+// RatePolicy is the limit of one route, like "POST /voters/:id/polls"
+type RatePolicy struct {
+	Limit  int           `yaml:"limit" toml:"limit"`
+	Window time.Duration `yaml:"window" toml:"window"`
+}
+
+// Enabled is true when requests are rate limited
+func (r RateLimitConfig) Enabled() bool {
+	return r.Store != api.RateLimitNone
+}
+
+// TracingConfig picks where spans go, see tracing.Config
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" toml:"exporter"`
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint"`
+	ServiceName string  `yaml:"service_name" toml:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+}
+
+// TimeoutConfig is the server side timeouts.  Zero means no timeout, except
+// for Drain and PreStop, see api.Serve
+type TimeoutConfig struct {
+	Read    time.Duration `yaml:"read" toml:"read"`
+	Write   time.Duration `yaml:"write" toml:"write"`
+	Idle    time.Duration `yaml:"idle" toml:"idle"`
+	PreStop time.Duration `yaml:"pre_stop" toml:"pre_stop"`
+	Drain   time.Duration `yaml:"drain" toml:"drain"`
+}
+
+// CORSConfig lists the origins browsers may call us from, * for any
+type CORSConfig struct {
+	AllowOrigins []string `yaml:"allow_origins" toml:"allow_origins"`
+}
+
+// LinksConfig is the base urls used in _links, see api.LinkConfig
+type LinksConfig struct {
+	BaseURL  string `yaml:"base_url" toml:"base_url"`
+	PollsURL string `yaml:"polls_url" toml:"polls_url"`
+	VotesURL string `yaml:"votes_url" toml:"votes_url"`
+}
+
+// FileConfig is the file store settings, see db.FileConfig
+type FileConfig struct {
+	Dir              string        `yaml:"dir" toml:"dir"`
+	SnapshotEvery    int           `yaml:"snapshot_every" toml:"snapshot_every"`
+	SnapshotInterval time.Duration `yaml:"snapshot_interval" toml:"snapshot_interval"`
+}
+
+// RedisConfig is the redis store settings, see db.RedisConfig
+type RedisConfig struct {
+	Addr         string        `yaml:"addr" toml:"addr"`
+	Password     string        `yaml:"password" toml:"password"`
+	DB           int           `yaml:"db" toml:"db"`
+	TLS          bool          `yaml:"tls" toml:"tls"`
+	KeyPrefix    string        `yaml:"key_prefix" toml:"key_prefix"`
+	DialTimeout  time.Duration `yaml:"dial_timeout" toml:"dial_timeout"`
+	ReadTimeout  time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	OpTimeout    time.Duration `yaml:"op_timeout" toml:"op_timeout"`
+}
+
+// Log levels
+const (
+	LogDebug = logging.LevelDebug
+	LogInfo  = logging.LevelInfo
+	LogWarn  = logging.LevelWarn
+	LogError = logging.LevelError
+)
+
+// Default is the config with nothing set.  The redis timeouts are the
+// go-redis defaults
+func Default() Config {
+	return Config{
+		Host:      "0.0.0.0",
+		Port:      1080,
+		Store:     db.StoreMemory,
+		LogLevel:  LogInfo,
+		LogFormat: logging.FormatJSON,
+		TLS: TLSConfig{
+			ClientAuth:     api.ClientAuthNone,
+			ReloadInterval: 10 * time.Second,
+		},
+		Auth: AuthConfig{
+			APIKeys:     []APIKey{},
+			ClientCerts: []CertRole{},
+		},
+		RateLimit: RateLimitConfig{
+			Store:  api.RateLimitNone,
+			Limit:  100,
+			Window: time.Minute,
+			Routes: map[string]RatePolicy{},
+			PerIP:  RatePolicy{Limit: 300, Window: time.Minute},
+
+			TrustedProxies: []string{},
+		},
+		Tracing: TracingConfig{
+			Exporter:    tracing.ExporterNone,
+			Endpoint:    tracing.DefaultEndpoint,
+			ServiceName: "voter-api",
+			SampleRatio: 1,
+		},
+		Timeouts: TimeoutConfig{
+			//api.DefaultPreStop and api.DefaultDrainTimeout
+			PreStop: 5 * time.Second,
+			Drain:   10 * time.Second,
+		},
+		CORS: CORSConfig{
+			AllowOrigins: []string{"*"},
+		},
+		File: FileConfig{
+			Dir:              db.FileDefaultLocation,
+			SnapshotEvery:    db.DefaultSnapshotEvery,
+			SnapshotInterval: db.DefaultSnapshotInterval,
+		},
+		Redis: RedisConfig{
+			Addr:         db.RedisDefaultLocation,
+			DialTimeout:  5 * time.Second,
+			ReadTimeout:  3 * time.Second,
+			WriteTimeout: 3 * time.Second,
+			OpTimeout:    5 * time.Second,
+		},
+	}
+}
+
+// Load builds the config from def, then the config file, the env vars and
+// the flags in args.  The file is the -config flag or VOTER_CONFIG, there
+// is none by default.  The result is validated.  For -help the usage is
+// printed and the error is flag.ErrHelp
+func Load(def Config, args []string) (Config, error) {
+	//A first pass over the flags only to find -config, and to fail on a
+	//bad flag before we read anything
+	var path string
+	scratch := def
+	fs := newFlagSet(&scratch, &path)
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if fs.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if path == "" {
+		path = os.Getenv(EnvConfig)
+	}
+
+	cfg := def
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	//The flags are defined on top of what the file set, so their defaults
+	//are the file values.  The env vars set them, and then the real parse
+	//only touches the flags on the command line
+	fs = newFlagSet(&cfg, &path)
+	if err := applyEnv(fs); err != nil {
+		return Config{}, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// loadFile reads a .yaml, .yml or .toml file over c.  Keys the file does
+// not have keep their value, keys we don't know are an error so a typo
+// does not go unnoticed
+func (c *Config) loadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		//An empty file is io.EOF, and fine
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(b), c)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("%s: unknown key %s", path, undecoded[0])
+		}
+	default:
+		return fmt.Errorf("%s: config file must be .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// Validate checks every setting and reports all the problems at once
+func (c Config) Validate() error {
+	var errs []error
+	bad := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	if c.Port == 0 || c.Port > 65535 {
+		bad("port must be between 1 and 65535, not %d", c.Port)
+	}
+	switch c.Store {
+	case db.StoreMemory, db.StoreFile, db.StoreRedis:
+	default:
+		bad("store must be %s, %s or %s, not %q", db.StoreMemory, db.StoreFile, db.StoreRedis, c.Store)
+	}
+	switch c.LogLevel {
+	case LogDebug, LogInfo, LogWarn, LogError:
+	default:
+		bad("log_level must be debug, info, warn or error, not %q", c.LogLevel)
+	}
+	switch c.LogFormat {
+	case logging.FormatJSON, logging.FormatText:
+	default:
+		bad("log_format must be json or text, not %q", c.LogFormat)
+	}
+
+	for _, d := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"tls.reload_interval", c.TLS.ReloadInterval},
+		{"timeouts.read", c.Timeouts.Read},
+		{"timeouts.write", c.Timeouts.Write},
+		{"timeouts.idle", c.Timeouts.Idle},
+		{"timeouts.pre_stop", c.Timeouts.PreStop},
+		{"timeouts.drain", c.Timeouts.Drain},
+		{"file.snapshot_interval", c.File.SnapshotInterval},
+		{"redis.dial_timeout", c.Redis.DialTimeout},
+		{"redis.read_timeout", c.Redis.ReadTimeout},
+		{"redis.write_timeout", c.Redis.WriteTimeout},
+		{"redis.op_timeout", c.Redis.OpTimeout},
+	} {
+		if d.d < 0 {
+			bad("%s can't be negative", d.name)
+		}
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		bad("tls.cert_file and tls.key_file have to be set together")
+	}
+	switch c.TLS.ClientAuth {
+	case api.ClientAuthNone:
+		if c.TLS.ClientCAFile != "" {
+			bad("tls.client_ca_file is set but tls.client_auth is none, use optional or require")
+		}
+	case api.ClientAuthOptional, api.ClientAuthRequire:
+		if !c.TLS.Enabled() || c.TLS.ClientCAFile == "" {
+			bad("tls.client_auth %s needs tls.cert_file, tls.key_file and tls.client_ca_file", c.TLS.ClientAuth)
+		}
+	default:
+		bad("tls.client_auth must be none, optional or require, not %q", c.TLS.ClientAuth)
+	}
+
+	names := map[string]bool{}
+	for i, k := range c.Auth.APIKeys {
+		if k.Name == "" {
+			bad("auth.api_keys[%d] needs a name", i)
+		} else if names[k.Name] {
+			bad("auth.api_keys: %s is there twice", k.Name)
+		}
+		names[k.Name] = true
+		if k.Role != api.RoleAdmin && k.Role != api.RoleClerk {
+			bad("auth.api_keys: %s must have role admin or clerk, not %q", k.Name, k.Role)
+		}
+		if len(k.Key) < 16 {
+			bad("auth.api_keys: the key of %s must be at least 16 characters", k.Name)
+		}
+	}
+	subjects := map[string]bool{}
+	for i, cr := range c.Auth.ClientCerts {
+		if cr.Subject == "" {
+			bad("auth.client_certs[%d] needs a subject", i)
+		} else if subjects[cr.Subject] {
+			bad("auth.client_certs: %s is there twice", cr.Subject)
+		}
+		subjects[cr.Subject] = true
+		if cr.Role != api.RoleAdmin && cr.Role != api.RoleClerk {
+			bad("auth.client_certs: %s must have role admin or clerk, not %q", cr.Subject, cr.Role)
+		}
+	}
+	if len(c.Auth.ClientCerts) > 0 && c.TLS.ClientAuth != api.ClientAuthOptional && c.TLS.ClientAuth != api.ClientAuthRequire {
+		bad("auth.client_certs needs tls.client_auth optional or require")
+	}
+	if (c.Auth.Issuer != "" || c.Auth.Audience != "") && c.Auth.JWKSFile == "" {
+		bad("auth.issuer and auth.audience need auth.jwks_file")
+	}
+
+	switch c.RateLimit.Store {
+	case api.RateLimitNone, api.RateLimitMemory:
+	case api.RateLimitRedis:
+		if _, _, err := net.SplitHostPort(c.Redis.Addr); err != nil {
+			bad("rate_limit.store redis needs redis.addr as host:port, not %q", c.Redis.Addr)
+		}
+	default:
+		bad("rate_limit.store must be none, memory or redis, not %q", c.RateLimit.Store)
+	}
+	checkPolicy := func(name string, limit int, window time.Duration) {
+		if limit < 0 {
+			bad("%s.limit can't be negative", name)
+		}
+		if limit > 0 && window <= 0 {
+			bad("%s.window must be positive", name)
+		}
+	}
+	checkPolicy("rate_limit", c.RateLimit.Limit, c.RateLimit.Window)
+	for _, route := range sortedKeys(c.RateLimit.Routes) {
+		method, path, _ := strings.Cut(route, " ")
+		if !httpMethods[method] || !strings.HasPrefix(path, "/") {
+			bad("rate_limit.routes: %q is not a method and path like \"POST /voters/:id/polls\"", route)
+		}
+		p := c.RateLimit.Routes[route]
+		checkPolicy(fmt.Sprintf("rate_limit.routes[%s]", route), p.Limit, p.Window)
+	}
+	checkPolicy("rate_limit.per_ip", c.RateLimit.PerIP.Limit, c.RateLimit.PerIP.Window)
+	for _, p := range c.RateLimit.TrustedProxies {
+		if _, err := api.ParseTrustedProxy(p); err != nil {
+			bad("rate_limit.trusted_proxies: %q is not an IP or CIDR", p)
+		}
+	}
+	//Without a proxy to trust the header is whatever the client says
+	if c.RateLimit.IPHeader != "" && len(c.RateLimit.TrustedProxies) == 0 {
+		bad("rate_limit.ip_header needs rate_limit.trusted_proxies")
+	}
+
+	switch c.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterStdout:
+	case tracing.ExporterOTLP:
+		if !isBaseURL(c.Tracing.Endpoint) {
+			bad("tracing.endpoint: %q is not an http or https url", c.Tracing.Endpoint)
+		}
+	default:
+		bad("tracing.exporter must be none, stdout or otlp, not %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.ServiceName == "" {
+		bad("tracing.service_name can't be empty")
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		bad("tracing.sample_ratio must be between 0 and 1, not %g", c.Tracing.SampleRatio)
+	}
+
+	if len(c.CORS.AllowOrigins) == 0 {
+		bad("cors.allow_origins can't be empty, use * to allow any origin")
+	}
+	for _, o := range c.CORS.AllowOrigins {
+		if o != "*" && !isBaseURL(o) {
+			bad("cors.allow_origins: %q is not * or an origin like https://example.com", o)
+		}
+	}
+
+	for _, u := range []struct{ name, url string }{
+		{"links.base_url", c.Links.BaseURL},
+		{"links.polls_url", c.Links.PollsURL},
+		{"links.votes_url", c.Links.VotesURL},
+	} {
+		if u.url != "" && !isBaseURL(u.url) {
+			bad("%s: %q is not an http or https url", u.name, u.url)
+		}
+	}
+
+	//Only the settings of the store we use have to make sense
+	switch c.Store {
+	case db.StoreFile:
+		if c.File.Dir == "" {
+			bad("file.dir can't be empty")
+		}
+		if c.File.SnapshotEvery <= 0 {
+			bad("file.snapshot_every must be a positive number")
+		}
+	case db.StoreRedis:
+		if _, _, err := net.SplitHostPort(c.Redis.Addr); err != nil {
+			bad("redis.addr must be host:port, not %q", c.Redis.Addr)
+		}
+		if c.Redis.DB < 0 {
+			bad("redis.db can't be negative")
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// httpMethods are the methods of the routes we serve
+var httpMethods = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// sortedKeys is the keys of m in order, so the errors about them are too
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isBaseURL is true for http and https urls with a host
+func isBaseURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// StoreConfig is the part of the config db.OpenVoterStore needs
+func (c Config) StoreConfig() db.StoreConfig {
+	return db.StoreConfig{
+		Type: c.Store,
+		File: db.FileConfig{
+			Dir:              c.File.Dir,
+			SnapshotEvery:    c.File.SnapshotEvery,
+			SnapshotInterval: c.File.SnapshotInterval,
+		},
+		Redis: db.RedisConfig{
+			Addr:         c.Redis.Addr,
+			Password:     c.Redis.Password,
+			DB:           c.Redis.DB,
+			TLS:          c.Redis.TLS,
+			KeyPrefix:    c.Redis.KeyPrefix,
+			DialTimeout:  c.Redis.DialTimeout,
+			ReadTimeout:  c.Redis.ReadTimeout,
+			WriteTimeout: c.Redis.WriteTimeout,
+			OpTimeout:    c.Redis.OpTimeout,
+		},
+	}
+}
+
+// TLSConfig is the part of the config api.NewCertReloader needs
+func (c Config) TLSConfig() api.TLSConfig {
+	return api.TLSConfig{
+		CertFile:       c.TLS.CertFile,
+		KeyFile:        c.TLS.KeyFile,
+		ClientCAFile:   c.TLS.ClientCAFile,
+		ClientAuth:     c.TLS.ClientAuth,
+		ReloadInterval: c.TLS.ReloadInterval,
+	}
+}
+
+// ShutdownConfig is the part of the config api.Serve needs
+func (c Config) ShutdownConfig() api.ShutdownConfig {
+	return api.ShutdownConfig{
+		PreStop: c.Timeouts.PreStop,
+		Drain:   c.Timeouts.Drain,
+	}
+}
+
+// AuthConfig is the part of the config api.NewAuthenticator needs
+func (c Config) AuthConfig() api.AuthConfig {
+	cfg := api.AuthConfig{
+		JWKSFile: c.Auth.JWKSFile,
+		Issuer:   c.Auth.Issuer,
+		Audience: c.Auth.Audience,
+	}
+	for _, k := range c.Auth.APIKeys {
+		cfg.APIKeys = append(cfg.APIKeys, api.APIKey{Name: k.Name, Key: k.Key, Role: k.Role})
+	}
+	for _, cr := range c.Auth.ClientCerts {
+		cfg.ClientCerts = append(cfg.ClientCerts, api.CertRole{Subject: cr.Subject, Role: cr.Role})
+	}
+	return cfg
+}
+
+// RateLimitConfig is the part of the config api.NewRateLimiter needs
+func (c Config) RateLimitConfig() api.RateLimitConfig {
+	cfg := api.RateLimitConfig{
+		Store:          c.RateLimit.Store,
+		Default:        api.RatePolicy{Limit: c.RateLimit.Limit, Window: c.RateLimit.Window},
+		Routes:         make(map[string]api.RatePolicy, len(c.RateLimit.Routes)),
+		PerIP:          api.RatePolicy{Limit: c.RateLimit.PerIP.Limit, Window: c.RateLimit.PerIP.Window},
+		IPHeader:       c.RateLimit.IPHeader,
+		TrustedProxies: c.RateLimit.TrustedProxies,
+		Redis:          c.StoreConfig().Redis,
+	}
+	for route, p := range c.RateLimit.Routes {
+		cfg.Routes[route] = api.RatePolicy{Limit: p.Limit, Window: p.Window}
+	}
+	return cfg
+}
+
+// TracingConfig is the part of the config tracing.Setup needs
+func (c Config) TracingConfig() tracing.Config {
+	return tracing.Config{
+		Exporter:    c.Tracing.Exporter,
+		Endpoint:    c.Tracing.Endpoint,
+		ServiceName: c.Tracing.ServiceName,
+		SampleRatio: c.Tracing.SampleRatio,
+	}
+}
+
+// masked replaces a secret that is set, so we can still see whether it is
+const masked = "********"
+
+// Redacted is c with the secrets masked, for printing
+func (c Config) Redacted() Config {
+	if c.Redis.Password != "" {
+		c.Redis.Password = masked
+	}
+	if len(c.Auth.APIKeys) > 0 {
+		keys := make([]APIKey, len(c.Auth.APIKeys))
+		for i, k := range c.Auth.APIKeys {
+			k.Key = masked
+			keys[i] = k
+		}
+		c.Auth.APIKeys = keys
+	}
+	return c
+}
+
+// String is the config as YAML with the secrets masked, so it is safe to
+// log
+func (c Config) String() string {
+	var b strings.Builder
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redacted()); err != nil {
+		return err.Error()
+	}
+	return b.String()
+}
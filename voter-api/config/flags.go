@@ -0,0 +1,283 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvConfig names the config file when there is no -config flag
+const EnvConfig = "VOTER_CONFIG"
+
+// envVars maps env vars to the flag they set.  The service urls, the store
+// and the redis and file store settings keep the names they always had
+var envVars = []struct{ env, flag string }{
+	{"VOTER_HOST", "h"},
+	{"VOTER_PORT", "p"},
+	{"VOTER_STORE", "store"},
+	{"VOTER_LOG_LEVEL", "log-level"},
+	{"VOTER_LOG_FORMAT", "log-format"},
+	{"VOTER_TLS_CERT_FILE", "tls-cert"},
+	{"VOTER_TLS_KEY_FILE", "tls-key"},
+	{"VOTER_TLS_CLIENT_CA_FILE", "tls-client-ca"},
+	{"VOTER_TLS_CLIENT_AUTH", "tls-client-auth"},
+	{"VOTER_TLS_RELOAD_INTERVAL", "tls-reload-interval"},
+	{"VOTER_API_KEYS", "api-keys"},
+	{"VOTER_CLIENT_CERTS", "client-certs"},
+	{"VOTER_JWKS_FILE", "jwks-file"},
+	{"VOTER_JWT_ISSUER", "jwt-issuer"},
+	{"VOTER_JWT_AUDIENCE", "jwt-audience"},
+	{"VOTER_RATE_LIMIT_STORE", "rate-limit-store"},
+	{"VOTER_RATE_LIMIT", "rate-limit"},
+	{"VOTER_RATE_LIMIT_WINDOW", "rate-limit-window"},
+	{"VOTER_RATE_LIMIT_ROUTES", "rate-limit-routes"},
+	{"VOTER_RATE_LIMIT_PER_IP", "rate-limit-per-ip"},
+	{"VOTER_RATE_LIMIT_PER_IP_WINDOW", "rate-limit-per-ip-window"},
+	{"VOTER_RATE_LIMIT_IP_HEADER", "rate-limit-ip-header"},
+	{"VOTER_RATE_LIMIT_TRUSTED_PROXIES", "rate-limit-trusted-proxies"},
+	{"VOTER_TRACE_EXPORTER", "trace-exporter"},
+	{"VOTER_TRACE_ENDPOINT", "trace-endpoint"},
+	{"VOTER_TRACE_SERVICE_NAME", "trace-service-name"},
+	{"VOTER_TRACE_SAMPLE_RATIO", "trace-sample-ratio"},
+	{"VOTER_READ_TIMEOUT", "read-timeout"},
+	{"VOTER_WRITE_TIMEOUT", "write-timeout"},
+	{"VOTER_IDLE_TIMEOUT", "idle-timeout"},
+	{"VOTER_PRE_STOP", "pre-stop"},
+	{"VOTER_DRAIN_TIMEOUT", "drain"},
+	{"VOTER_CORS_ORIGINS", "cors-origins"},
+	{"VOTER_API_URL", "baseurl"},
+	{"POLLS_API_URL", "pollsurl"},
+	{"VOTES_API_URL", "votesurl"},
+	{"VOTER_DATA_DIR", "data-dir"},
+	{"VOTER_SNAPSHOT_EVERY", "snapshot-every"},
+	{"VOTER_SNAPSHOT_INTERVAL", "snapshot-interval"},
+	{"REDIS_URL", "redis-addr"},
+	{"REDIS_PASSWORD", "redis-password"},
+	{"REDIS_DB", "redis-db"},
+	{"REDIS_TLS", "redis-tls"},
+	{"REDIS_KEY_PREFIX", "redis-key-prefix"},
+	{"REDIS_DIAL_TIMEOUT", "redis-dial-timeout"},
+	{"REDIS_READ_TIMEOUT", "redis-read-timeout"},
+	{"REDIS_WRITE_TIMEOUT", "redis-write-timeout"},
+	{"REDIS_OP_TIMEOUT", "redis-op-timeout"},
+}
+
+// listValue is a comma separated flag.  Setting it replaces the list
+type listValue struct {
+	list *[]string
+}
+
+func (l listValue) String() string {
+	if l.list == nil {
+		return ""
+	}
+	return strings.Join(*l.list, ",")
+}
+
+func (l listValue) Set(s string) error {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	*l.list = list
+	return nil
+}
+
+// apiKeysValue is a comma separated list of name:role:key.  Setting it
+// replaces the list
+type apiKeysValue struct {
+	keys *[]APIKey
+}
+
+// String never shows the keys, the flag package prints it as the default
+func (a apiKeysValue) String() string {
+	if a.keys == nil {
+		return ""
+	}
+	names := make([]string, len(*a.keys))
+	for i, k := range *a.keys {
+		names[i] = k.Name + ":" + k.Role + ":" + masked
+	}
+	return strings.Join(names, ",")
+}
+
+func (a apiKeysValue) Set(s string) error {
+	keys := []APIKey{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		parts := strings.SplitN(v, ":", 3)
+		if len(parts) != 3 {
+			return errors.New("API keys are name:role:key")
+		}
+		keys = append(keys, APIKey{Name: parts[0], Role: parts[1], Key: parts[2]})
+	}
+	*a.keys = keys
+	return nil
+}
+
+// certRolesValue is a semicolon separated list of role=subject, like
+// "clerk=CN=polls-api,O=Voting;admin=ops.example.com".  Subjects have
+// commas of their own.  Setting it replaces the list
+type certRolesValue struct {
+	certs *[]CertRole
+}
+
+func (cv certRolesValue) String() string {
+	if cv.certs == nil {
+		return ""
+	}
+	list := make([]string, len(*cv.certs))
+	for i, cr := range *cv.certs {
+		list[i] = cr.Role + "=" + cr.Subject
+	}
+	return strings.Join(list, ";")
+}
+
+func (cv certRolesValue) Set(s string) error {
+	certs := []CertRole{}
+	for _, v := range strings.Split(s, ";") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		role, subject, found := strings.Cut(v, "=")
+		if !found {
+			return errors.New("client certificate roles are role=subject")
+		}
+		certs = append(certs, CertRole{Subject: subject, Role: role})
+	}
+	*cv.certs = certs
+	return nil
+}
+
+// routesValue is a comma separated list of route=limit/window, like
+// "POST /voters/:id/polls=10/1m".  Setting it replaces the policies
+type routesValue struct {
+	routes *map[string]RatePolicy
+}
+
+func (r routesValue) String() string {
+	if r.routes == nil {
+		return ""
+	}
+	var list []string
+	for _, route := range sortedKeys(*r.routes) {
+		p := (*r.routes)[route]
+		list = append(list, fmt.Sprintf("%s=%d/%s", route, p.Limit, p.Window))
+	}
+	return strings.Join(list, ",")
+}
+
+func (r routesValue) Set(s string) error {
+	routes := map[string]RatePolicy{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		route, policy, ok := strings.Cut(v, "=")
+		limit, window, ok2 := strings.Cut(policy, "/")
+		if !ok || !ok2 {
+			return errors.New("route limits are method path=limit/window")
+		}
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return err
+		}
+		d, err := time.ParseDuration(window)
+		if err != nil {
+			return err
+		}
+		routes[strings.TrimSpace(route)] = RatePolicy{Limit: n, Window: d}
+	}
+	*r.routes = routes
+	return nil
+}
+
+// newFlagSet defines a flag for every setting, writing into c.  A flag's
+// default is whatever c holds when it is defined
+func newFlagSet(c *Config, path *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	fs.StringVar(path, "config", *path, "YAML or TOML config file, also "+EnvConfig)
+
+	fs.StringVar(&c.Host, "h", c.Host, "Interface to listen on, 0.0.0.0 for all")
+	fs.UintVar(&c.Port, "p", c.Port, "Port to listen on")
+	fs.StringVar(&c.Store, "store", c.Store, "Voter store to use, memory, file or redis")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "Log level, debug, info, warn or error")
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "Log format, json or text")
+
+	fs.StringVar(&c.TLS.CertFile, "tls-cert", c.TLS.CertFile, "PEM certificate to serve HTTPS with, empty for plain HTTP")
+	fs.StringVar(&c.TLS.KeyFile, "tls-key", c.TLS.KeyFile, "PEM key of the certificate")
+	fs.StringVar(&c.TLS.ClientCAFile, "tls-client-ca", c.TLS.ClientCAFile, "PEM bundle of the CAs client certificates are verified against")
+	fs.StringVar(&c.TLS.ClientAuth, "tls-client-auth", c.TLS.ClientAuth, "Client certificates, none, optional or require")
+	fs.DurationVar(&c.TLS.ReloadInterval, "tls-reload-interval", c.TLS.ReloadInterval, "How often to check the certificate files for changes, 0 to never")
+
+	fs.Var(apiKeysValue{&c.Auth.APIKeys}, "api-keys", "Comma separated name:role:key API keys, better set with VOTER_API_KEYS")
+	fs.Var(certRolesValue{&c.Auth.ClientCerts}, "client-certs", "Semicolon separated role=subject roles of client certificates, by subject or DNS name")
+	fs.StringVar(&c.Auth.JWKSFile, "jwks-file", c.Auth.JWKSFile, "JWKS file with the keys JWTs are verified against")
+	fs.StringVar(&c.Auth.Issuer, "jwt-issuer", c.Auth.Issuer, "iss a JWT must have, empty for any")
+	fs.StringVar(&c.Auth.Audience, "jwt-audience", c.Auth.Audience, "aud a JWT must have, empty for any")
+
+	fs.StringVar(&c.RateLimit.Store, "rate-limit-store", c.RateLimit.Store, "Where rate limits are counted, none, memory or redis")
+	fs.IntVar(&c.RateLimit.Limit, "rate-limit", c.RateLimit.Limit, "Requests a client may make to a route per window, 0 for no limit")
+	fs.DurationVar(&c.RateLimit.Window, "rate-limit-window", c.RateLimit.Window, "Window of the rate limit")
+	fs.Var(routesValue{&c.RateLimit.Routes}, "rate-limit-routes", "Comma separated route limits, like \"POST /voters/:id/polls=10/1m\"")
+	fs.IntVar(&c.RateLimit.PerIP.Limit, "rate-limit-per-ip", c.RateLimit.PerIP.Limit, "Requests a client address may make to all routes per window, counted before auth, 0 for no limit")
+	fs.DurationVar(&c.RateLimit.PerIP.Window, "rate-limit-per-ip-window", c.RateLimit.PerIP.Window, "Window of the per address rate limit")
+	fs.StringVar(&c.RateLimit.IPHeader, "rate-limit-ip-header", c.RateLimit.IPHeader, "Header with the client address behind a load balancer, like X-Forwarded-For")
+	fs.Var(listValue{&c.RateLimit.TrustedProxies}, "rate-limit-trusted-proxies", "Comma separated IPs or CIDRs of the load balancers allowed to set the ip header")
+
+	fs.StringVar(&c.Tracing.Exporter, "trace-exporter", c.Tracing.Exporter, "Where spans go, none, stdout or otlp")
+	fs.StringVar(&c.Tracing.Endpoint, "trace-endpoint", c.Tracing.Endpoint, "OTLP/HTTP url of the collector")
+	fs.StringVar(&c.Tracing.ServiceName, "trace-service-name", c.Tracing.ServiceName, "service.name of our spans")
+	fs.Float64Var(&c.Tracing.SampleRatio, "trace-sample-ratio", c.Tracing.SampleRatio, "Share of new traces recorded, 0 to 1")
+
+	fs.DurationVar(&c.Timeouts.Read, "read-timeout", c.Timeouts.Read, "Longest time to read a request, 0 for none")
+	fs.DurationVar(&c.Timeouts.Write, "write-timeout", c.Timeouts.Write, "Longest time to write a response, 0 for none")
+	fs.DurationVar(&c.Timeouts.Idle, "idle-timeout", c.Timeouts.Idle, "How long a keep-alive connection may sit idle, 0 for the read timeout")
+	fs.DurationVar(&c.Timeouts.PreStop, "pre-stop", c.Timeouts.PreStop, "How long to keep serving on shutdown after /readyz turns 503")
+	fs.DurationVar(&c.Timeouts.Drain, "drain", c.Timeouts.Drain, "How long to wait for in-flight requests on shutdown")
+	fs.Var(listValue{&c.CORS.AllowOrigins}, "cors-origins", "Comma separated origins allowed by CORS, * for any")
+
+	fs.StringVar(&c.Links.BaseURL, "baseurl", c.Links.BaseURL, "Base url of this API used in _links, empty for relative links")
+	fs.StringVar(&c.Links.PollsURL, "pollsurl", c.Links.PollsURL, "Base url of the polls API used in _links")
+	fs.StringVar(&c.Links.VotesURL, "votesurl", c.Links.VotesURL, "Base url of the votes API used in _links")
+
+	fs.StringVar(&c.File.Dir, "data-dir", c.File.Dir, "Directory of the file store's snapshot and log")
+	fs.IntVar(&c.File.SnapshotEvery, "snapshot-every", c.File.SnapshotEvery, "Log records the file store writes before it compacts")
+	fs.DurationVar(&c.File.SnapshotInterval, "snapshot-interval", c.File.SnapshotInterval, "How often the file store compacts a log that isn't empty, 0 to only compact by count")
+
+	fs.StringVar(&c.Redis.Addr, "redis-addr", c.Redis.Addr, "Redis host:port")
+	fs.StringVar(&c.Redis.Password, "redis-password", c.Redis.Password, "Redis password, better set with REDIS_PASSWORD")
+	fs.IntVar(&c.Redis.DB, "redis-db", c.Redis.DB, "Redis database index")
+	fs.BoolVar(&c.Redis.TLS, "redis-tls", c.Redis.TLS, "Connect to redis over TLS")
+	fs.StringVar(&c.Redis.KeyPrefix, "redis-key-prefix", c.Redis.KeyPrefix, "Prefix for every redis key, to share one redis")
+	fs.DurationVar(&c.Redis.DialTimeout, "redis-dial-timeout", c.Redis.DialTimeout, "Timeout to connect to redis")
+	fs.DurationVar(&c.Redis.ReadTimeout, "redis-read-timeout", c.Redis.ReadTimeout, "Timeout of a redis read")
+	fs.DurationVar(&c.Redis.WriteTimeout, "redis-write-timeout", c.Redis.WriteTimeout, "Timeout of a redis write")
+	fs.DurationVar(&c.Redis.OpTimeout, "redis-op-timeout", c.Redis.OpTimeout, "Longest a store operation may wait on redis, 0 for none")
+
+	return fs
+}
+
+// applyEnv sets the flags of the env vars that are set.  A bad value is an
+// error rather than quietly ignored
+func applyEnv(fs *flag.FlagSet) error {
+	for _, e := range envVars {
+		v, ok := os.LookupEnv(e.env)
+		if !ok || v == "" {
+			continue
+		}
+		if err := fs.Set(e.flag, v); err != nil {
+			return fmt.Errorf("%s: invalid value %q: %w", e.env, v, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The kinds of store error.  Every *Error wraps one of these, so callers
+// can ask errors.Is(err, db.ErrNotFound) without knowing the exact error,
+// and the api turns each kind into one status code
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrValidation   = errors.New("validation failed")
+	ErrUnavailable  = errors.New("backend unavailable")
+	ErrPrecondition = errors.New("precondition failed")
+	ErrInvalidInput = errors.New("invalid input")
+	ErrTimeout      = errors.New("timed out")
+)
+
+// Error is a store error with a stable Code for clients to switch on, like
+// voter_not_found.  Kind is one of the kinds above, Err the cause if any
+type Error struct {
+	Kind error
+	Code string
+	Msg  string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Msg + ": " + e.Err.Error()
+	}
+	return e.Msg
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err != nil {
+		return []error{e.Kind, e.Err}
+	}
+	return []error{e.Kind}
+}
+
+func newError(kind error, code, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Code: code, Msg: fmt.Sprintf(format, args...)}
+}
+
+func voterNotFound(id uint) error {
+	return newError(ErrNotFound, "voter_not_found", "voter %d does not exist", id)
+}
+
+func voterPollNotFound(id, pollId uint) error {
+	return newError(ErrNotFound, "voter_poll_not_found", "voter %d has no vote for poll %d", id, pollId)
+}
+
+func voterExists(id uint) error {
+	return newError(ErrConflict, "voter_exists", "voter %d already exists", id)
+}
+
+func voterPollExists(id, pollId uint) error {
+	return newError(ErrConflict, "voter_poll_exists", "voter %d already has a vote for poll %d", id, pollId)
+}
+
+// unavailable marks err as the backend being down rather than a bug
+func unavailable(code string, err error) error {
+	return &Error{Kind: ErrUnavailable, Code: code, Msg: "voter store is unavailable", Err: err}
+}
+
+// timedOut marks err as the backend not answering before the deadline
+func timedOut(err error) error {
+	return &Error{Kind: ErrTimeout, Code: "backend_timeout", Msg: "voter store did not answer in time", Err: err}
+}
@@ -0,0 +1,517 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	FileDefaultLocation = "./data"
+
+	//DefaultSnapshotEvery is how many log records we write before the
+	//log is compacted into a new snapshot
+	DefaultSnapshotEvery = 1000
+
+	//DefaultSnapshotInterval is how often the log is compacted anyway,
+	//so a quiet store doesn't sit on a long log
+	DefaultSnapshotInterval = time.Minute
+
+	snapshotFile = "voters.json"
+	walFile      = "voters.wal"
+)
+
+// Log record ops, one per VoterStore mutation
+const (
+	opAddVoter        = "add_voter"
+	opUpdateVoter     = "update_voter"
+	opDeleteVoter     = "delete_voter"
+	opDeleteAll       = "delete_all"
+	opAddVoterPoll    = "add_voter_poll"
+	opUpdateVoterPoll = "update_voter_poll"
+	opDeleteVoterPoll = "delete_voter_poll"
+)
+
+// walRecord is one line of the write-ahead log.  Seq numbers every
+// record, a snapshot remembers the last one it holds
+type walRecord struct {
+	Seq     uint64        `json:"seq"`
+	Op      string        `json:"op"`
+	Id      uint          `json:"id,omitempty"`
+	PollId  uint          `json:"poll_id,omitempty"`
+	Rev     uint64        `json:"rev,omitempty"`
+	Voter   *Voter        `json:"voter,omitempty"`
+	History *VoterHistory `json:"history,omitempty"`
+}
+
+// snapshot is the voters.json file
+type snapshot struct {
+	Seq    uint64  `json:"seq"`
+	NextId uint    `json:"next_id"`
+	Voters []Voter `json:"voters"`
+}
+
+// FileConfig is where the file store keeps its snapshot and log, and how
+// often it compacts.  It compacts every SnapshotEvery records and every
+// SnapshotInterval, whichever comes first.  A SnapshotInterval of 0 only
+// compacts by count
+type FileConfig struct {
+	Dir              string
+	SnapshotEvery    int
+	SnapshotInterval time.Duration
+}
+
+// VoterFile is the memory store made durable.  Voters live in a VoterList
+// like the memory store, and every mutation that succeeds is appended to
+// voters.wal and synced before we return.  Every snapshotEvery records, and
+// every SnapshotInterval if the log isn't empty, the whole map is written to
+// voters.json and the log starts over.  On startup the snapshot is loaded
+// and the log replayed on top of it
+type VoterFile struct {
+	*VoterList
+
+	//mu serialises the mutations so the log has them in the same order
+	//they were applied to the map
+	mu            sync.Mutex
+	seq           uint64
+	dir           string
+	wal           *os.File
+	walRecords    int
+	snapshotEvery int
+
+	//stop ends compactEvery and done is closed once it has, see Close
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewVoterFile() (*VoterFile, error) {
+	dir := os.Getenv("VOTER_DATA_DIR")
+	if dir == "" {
+		dir = FileDefaultLocation
+	}
+
+	snapshotEvery := DefaultSnapshotEvery
+	if s := os.Getenv("VOTER_SNAPSHOT_EVERY"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return nil, errors.New("VOTER_SNAPSHOT_EVERY must be a positive number")
+		}
+		snapshotEvery = n
+	}
+
+	snapshotInterval := DefaultSnapshotInterval
+	if s := os.Getenv("VOTER_SNAPSHOT_INTERVAL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return nil, errors.New("VOTER_SNAPSHOT_INTERVAL must be a duration like 1m")
+		}
+		snapshotInterval = d
+	}
+
+	return NewWithFileConfig(FileConfig{Dir: dir, SnapshotEvery: snapshotEvery, SnapshotInterval: snapshotInterval})
+}
+
+// NewWithFileInstance is a file store that only compacts by count
+func NewWithFileInstance(dir string, snapshotEvery int) (*VoterFile, error) {
+	return NewWithFileConfig(FileConfig{Dir: dir, SnapshotEvery: snapshotEvery})
+}
+
+func NewWithFileConfig(cfg FileConfig) (*VoterFile, error) {
+	dir, snapshotEvery := cfg.Dir, cfg.SnapshotEvery
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	voterList, _ := NewVoterList()
+	v := &VoterFile{
+		VoterList:     voterList,
+		dir:           dir,
+		snapshotEvery: snapshotEvery,
+	}
+
+	if err := v.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	replayed, err := v.replay()
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Loaded voters", "voters", len(v.Voters), "dir", dir, "replayed", replayed)
+
+	//Start every run with a fresh snapshot and an empty log
+	if err := v.compact(context.Background()); err != nil {
+		return nil, err
+	}
+
+	if cfg.SnapshotInterval > 0 {
+		v.stop = make(chan struct{})
+		v.done = make(chan struct{})
+		go v.compactEvery(cfg.SnapshotInterval)
+	}
+	return v, nil
+}
+
+// compactEvery compacts the log every interval until Close, skipping the
+// ticks that find it empty
+func (v *VoterFile) compactEvery(interval time.Duration) {
+	defer close(v.done)
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-v.stop:
+			return
+		case <-tick.C:
+		}
+
+		v.mu.Lock()
+		if v.walRecords > 0 {
+			if err := v.compact(context.Background()); err != nil {
+				//The log still has every record, try again next tick
+				slog.Error("Error compacting voter log", "err", err)
+			}
+		}
+		v.mu.Unlock()
+	}
+}
+
+func (v *VoterFile) path(name string) string {
+	return filepath.Join(v.dir, name)
+}
+
+func (v *VoterFile) loadSnapshot() error {
+	b, err := os.ReadFile(v.path(snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return fmt.Errorf("reading %s: %w", snapshotFile, err)
+	}
+	//Snapshots from before next_id was written start from the highest id
+	v.nextId = snap.NextId
+	for _, voter := range snap.Voters {
+		v.Voters[voter.VoterId] = voter
+		v.nextId = max(v.nextId, voter.VoterId)
+	}
+	v.seq = snap.Seq
+	return nil
+}
+
+// replay applies the log on top of the snapshot.  Records the snapshot
+// already has are skipped, that happens if we crashed after writing a
+// snapshot but before emptying the log.  A crash in the middle of an
+// append leaves a torn last line, that record was never acknowledged so
+// we drop it.  A bad line anywhere else means the log is corrupt
+func (v *VoterFile) replay() (int, error) {
+	f, err := os.Open(v.path(walFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	rdr := bufio.NewReader(f)
+	n := 0
+	for {
+		line, err := rdr.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) > 0 {
+				slog.Warn("Dropping torn record at the end of the log", "file", walFile)
+			}
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+
+		var rec walRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return n, fmt.Errorf("reading %s after record %d: %w", walFile, v.seq, err)
+		}
+		if rec.Seq <= v.seq {
+			continue
+		}
+		if err := apply(context.Background(), v.VoterList, rec); err != nil {
+			return n, fmt.Errorf("replaying %s record %d: %w", walFile, rec.Seq, err)
+		}
+		v.seq = rec.Seq
+		n++
+	}
+}
+
+// apply runs one record against an in-memory list.  Live requests and
+// replay both go through here so they can't drift apart
+func apply(ctx context.Context, list *VoterList, rec walRecord) error {
+	switch rec.Op {
+	case opAddVoter:
+		//Log the id we picked, so replay adds the voter under the same one
+		id, err := list.AddVoter(ctx, *rec.Voter)
+		if err == nil {
+			rec.Voter.VoterId = id
+		}
+		return err
+	case opUpdateVoter:
+		return list.UpdateVoter(ctx, rec.Id, rec.Rev, *rec.Voter)
+	case opDeleteVoter:
+		return list.DeleteVoter(ctx, rec.Id, rec.Rev)
+	case opDeleteAll:
+		_, err := list.DeleteAll(ctx)
+		return err
+	case opAddVoterPoll:
+		return list.AddVoterPoll(ctx, rec.Id, *rec.History)
+	case opUpdateVoterPoll:
+		return list.UpdateVoterPoll(ctx, rec.Id, rec.PollId, rec.Rev, *rec.History)
+	case opDeleteVoterPoll:
+		return list.DeleteVoterPoll(ctx, rec.Id, rec.PollId, rec.Rev)
+	default:
+		return errors.New("unknown op: " + rec.Op)
+	}
+}
+
+// scratch is a list with a copy of the one voter rec touches and the id
+// counter, all a record needs to apply.  mutateLocked tries the record on
+// it before anything is written
+func (v *VoterFile) scratch(rec walRecord) *VoterList {
+	v.VoterList.mu.RLock()
+	defer v.VoterList.mu.RUnlock()
+
+	s := &VoterList{Voters: make(map[uint]Voter), nextId: v.nextId}
+	id := rec.Id
+	if rec.Op == opAddVoter {
+		id = rec.Voter.VoterId
+		if id == 0 {
+			id = v.nextId + 1
+		}
+	}
+	if voter, ok := v.Voters[id]; ok {
+		s.Voters[id] = voter.clone()
+	}
+	return s
+}
+
+// writeFileSync writes a file next to its final name and renames it into
+// place, so a crash leaves either the old file or the new one
+func writeFileSync(name string, b []byte) error {
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// compact writes the map to a new snapshot and empties the log.  Callers
+// hold v.mu, or are the constructor
+func (v *VoterFile) compact(ctx context.Context) error {
+	voters, _ := v.VoterList.GetAllVoters(ctx)
+	VoterQuery{Sort: SortVoterId}.sortVoters(voters)
+	if voters == nil {
+		voters = []Voter{}
+	}
+
+	b, err := json.MarshalIndent(snapshot{Seq: v.seq, NextId: v.nextId, Voters: voters}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(v.path(snapshotFile), b); err != nil {
+		return err
+	}
+
+	//The snapshot has everything in the log now, start a new one
+	if v.wal != nil {
+		v.wal.Close()
+	}
+	v.wal, err = os.OpenFile(v.path(walFile), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	v.walRecords = 0
+	return nil
+}
+
+// mutate logs rec and applies it to the map.  The record is tried on a
+// copy first, written and synced, and only then applied to the map, so a
+// failed write leaves the map and the log as they were.  The client gets
+// the error and the change is gone
+func (v *VoterFile) mutate(ctx context.Context, rec walRecord) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	return v.mutateLocked(ctx, rec)
+}
+
+func (v *VoterFile) mutateLocked(ctx context.Context, rec walRecord) error {
+	if err := apply(ctx, v.scratch(rec), rec); err != nil {
+		return err
+	}
+	rec.Seq = v.seq + 1
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	off, err := v.wal.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return unavailable("storage_unavailable", err)
+	}
+	if _, err := v.wal.Write(append(b, '\n')); err != nil {
+		v.rollback(ctx, off)
+		return unavailable("storage_unavailable", err)
+	}
+	if err := v.wal.Sync(); err != nil {
+		v.rollback(ctx, off)
+		return unavailable("storage_unavailable", err)
+	}
+	slog.DebugContext(ctx, "Appended log record", "op", rec.Op, "seq", rec.Seq)
+
+	//It applied to the copy, so it applies here.  The id an add picked
+	//is in the record now, the map gets the voter under the same one
+	if err := apply(ctx, v.VoterList, rec); err != nil {
+		return err
+	}
+	v.seq = rec.Seq
+
+	v.walRecords++
+	if v.walRecords >= v.snapshotEvery {
+		if err := v.compact(ctx); err != nil {
+			//The log still has every record, so nothing is lost.  We
+			//try again on the next write
+			slog.ErrorContext(ctx, "Error compacting voter log", "err", err)
+		}
+	}
+	return nil
+}
+
+// rollback cuts the log back to off after a failed append.  Whatever part
+// of the record made it to the file would otherwise end up in the middle
+// of the log once the next record is appended, and replay would take the
+// log for corrupt.  If the log can't be cut a compaction starts a new one,
+// the map never had the record so the snapshot doesn't either
+func (v *VoterFile) rollback(ctx context.Context, off int64) {
+	err := v.wal.Truncate(off)
+	if err == nil {
+		_, err = v.wal.Seek(off, io.SeekStart)
+	}
+	if err == nil {
+		return
+	}
+	slog.ErrorContext(ctx, "Error cutting back the voter log, compacting", "err", err)
+	if err := v.compact(ctx); err != nil {
+		slog.ErrorContext(ctx, "Error compacting voter log", "err", err)
+	}
+}
+
+// Close stops the timed compaction, snapshots the store and closes the log
+func (v *VoterFile) Close() error {
+	if v.stop != nil {
+		close(v.stop)
+		<-v.done
+		v.stop = nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if err := v.compact(context.Background()); err != nil {
+		return err
+	}
+	return v.wal.Close()
+}
+
+func (v *VoterFile) AddVoter(ctx context.Context, voter Voter) (uint, error) {
+	if err := v.mutate(ctx, walRecord{Op: opAddVoter, Voter: &voter}); err != nil {
+		return 0, err
+	}
+	return voter.VoterId, nil
+}
+
+func (v *VoterFile) UpdateVoter(ctx context.Context, id uint, rev uint64, voter Voter) error {
+	return v.mutate(ctx, walRecord{Op: opUpdateVoter, Id: id, Rev: rev, Voter: &voter})
+}
+
+// PatchVoter logs the patched voter rather than the patch, so replay
+// doesn't depend on the patch code
+func (v *VoterFile) PatchVoter(ctx context.Context, id uint, rev uint64, patch VoterPatch) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	//Only mutations change the map and we hold v.mu, so the voter can't
+	//change between this read and the update below
+	cur, err := v.VoterList.GetVoter(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := checkRevision(cur.Revision, rev); err != nil {
+		return err
+	}
+
+	voter, err := patch.Apply(cur)
+	if err != nil {
+		return err
+	}
+	return v.mutateLocked(ctx, walRecord{Op: opUpdateVoter, Id: id, Rev: cur.Revision, Voter: &voter})
+}
+
+func (v *VoterFile) DeleteVoter(ctx context.Context, id uint, rev uint64) error {
+	return v.mutate(ctx, walRecord{Op: opDeleteVoter, Id: id, Rev: rev})
+}
+
+// Ping checks the data directory is still there.  A failed log write
+// shows up on the write itself, as ErrUnavailable
+func (v *VoterFile) Ping(ctx context.Context) error {
+	if _, err := os.Stat(v.dir); err != nil {
+		return unavailable("storage_unavailable", err)
+	}
+	return ctx.Err()
+}
+
+func (v *VoterFile) DeleteAll(ctx context.Context) (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	//Only mutations change the map and we hold v.mu, so the count is
+	//exactly what delete_all removes
+	voters, _ := v.VoterList.GetAllVoters(ctx)
+	return len(voters), v.mutateLocked(ctx, walRecord{Op: opDeleteAll})
+}
+
+func (v *VoterFile) AddVoterPoll(ctx context.Context, id uint, voterPoll VoterHistory) error {
+	return v.mutate(ctx, walRecord{Op: opAddVoterPoll, Id: id, History: &voterPoll})
+}
+
+func (v *VoterFile) UpdateVoterPoll(ctx context.Context, id, pollId uint, rev uint64, voterHistory VoterHistory) error {
+	return v.mutate(ctx, walRecord{Op: opUpdateVoterPoll, Id: id, PollId: pollId, Rev: rev, History: &voterHistory})
+}
+
+func (v *VoterFile) DeleteVoterPoll(ctx context.Context, id, pollId uint, rev uint64) error {
+	return v.mutate(ctx, walRecord{Op: opDeleteVoterPoll, Id: id, PollId: pollId, Rev: rev})
+}
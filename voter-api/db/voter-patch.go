@@ -0,0 +1,148 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+
+	"drexel.edu/todo/validate"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+const (
+	//RFC 7396, a partial voter document.  null removes a field
+	MergePatchType = "application/merge-patch+json"
+
+	//RFC 6902, a list of add/remove/replace/move/copy/test operations
+	JSONPatchType = "application/json-patch+json"
+)
+
+var (
+	//ErrUnsupportedPatch is a content type that is neither patch format
+	ErrUnsupportedPatch = newError(ErrInvalidInput, "unsupported_patch_type", "patch content type must be %s or %s", MergePatchType, JSONPatchType)
+
+	//ErrInvalidPatch is a patch document that does not parse
+	ErrInvalidPatch = newError(ErrInvalidInput, "invalid_patch", "invalid patch document")
+
+	//ErrPatchConflict is a JSON Patch test operation that did not match
+	//the stored voter
+	ErrPatchConflict = newError(ErrConflict, "patch_test_failed", "patch test operation failed")
+
+	//ErrPatchFailed is a patch that parsed but could not be applied, or
+	//that left the voter invalid
+	ErrPatchFailed = newError(ErrValidation, "patch_failed", "patch could not be applied")
+)
+
+// VoterPatch is a parsed PATCH body.  The stores apply it to the stored
+// voter under the same lock or transaction as the write, so a patch never
+// works on a stale copy
+type VoterPatch struct {
+	contentType string
+	merge       []byte
+	ops         jsonpatch.Patch
+
+	//keepHistory refuses a patch that changes vote_history
+	keepHistory bool
+}
+
+// NewVoterPatch checks the content type and parses the patch document
+func NewVoterPatch(contentType string, body []byte) (VoterPatch, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return VoterPatch{}, ErrUnsupportedPatch
+	}
+
+	p := VoterPatch{contentType: mediaType}
+	switch mediaType {
+	case MergePatchType:
+		//A merge patch for a voter has to be an object, anything else
+		//would replace the whole voter
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return VoterPatch{}, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+		p.merge = body
+	case JSONPatchType:
+		p.ops, err = jsonpatch.DecodePatch(body)
+		if err != nil {
+			return VoterPatch{}, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+	default:
+		return VoterPatch{}, ErrUnsupportedPatch
+	}
+	return p, nil
+}
+
+// KeepHistory is the patch with vote_history made read only.  The api
+// uses it for a voter editing its own record, it may fix its name and email
+// but not how it voted
+func (p VoterPatch) KeepHistory() VoterPatch {
+	p.keepHistory = true
+	return p
+}
+
+// Apply patches voter and returns the result.  The result has to decode
+// back into a Voter with no unknown fields and pass Voter.Validate, and the
+// voter_id and revision can't be patched.  The store sets the new revision
+// when it writes
+func (p VoterPatch) Apply(voter Voter) (Voter, error) {
+	doc, err := json.Marshal(voter)
+	if err != nil {
+		return Voter{}, err
+	}
+
+	switch p.contentType {
+	case MergePatchType:
+		doc, err = jsonpatch.MergePatch(doc, p.merge)
+	case JSONPatchType:
+		doc, err = p.ops.Apply(doc)
+	default:
+		return Voter{}, ErrUnsupportedPatch
+	}
+	if errors.Is(err, jsonpatch.ErrTestFailed) {
+		return Voter{}, fmt.Errorf("%w: %v", ErrPatchConflict, err)
+	}
+	if err != nil {
+		return Voter{}, fmt.Errorf("%w: %v", ErrPatchFailed, err)
+	}
+
+	//The field errors are wrapped too, so the api can list them
+	var patched Voter
+	if err := validate.Decode(doc, &patched); err != nil {
+		return Voter{}, fmt.Errorf("%w: %w", ErrPatchFailed, err)
+	}
+
+	var errs validate.Errors
+	if patched.VoterId != voter.VoterId {
+		errs.Add("voter_id", "can't be changed")
+	}
+	if patched.Revision != voter.Revision {
+		errs.Add("revision", "can't be changed")
+	}
+	if p.keepHistory && !sameHistory(patched.VoteHistory, voter.VoteHistory) {
+		errs.Add("vote_history", "can't be changed")
+	}
+	errs.Nest("", patched.Validate())
+	if err := errs.Err(); err != nil {
+		return Voter{}, fmt.Errorf("%w: %w", ErrPatchFailed, err)
+	}
+
+	if patched.VoteHistory == nil {
+		patched.VoteHistory = make([]VoterHistory, 0)
+	}
+	return patched, nil
+}
+
+// sameHistory compares two histories the way they are stored, as JSON, so
+// a nil history is the same as an empty one and the vote dates compare by
+// value
+func sameHistory(a, b []VoterHistory) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
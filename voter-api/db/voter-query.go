@@ -0,0 +1,113 @@
+package db
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	SortNone    = ""
+	SortName    = "name"
+	SortVoterId = "voter_id"
+)
+
+// ErrInvalidCursor is returned by ListVoters for a cursor it did not hand out
+var ErrInvalidCursor = newError(ErrInvalidInput, "invalid_cursor", "invalid cursor")
+
+// VoterQuery describes one page of GET /voters.  A zero Limit means no
+// paging, every matching voter comes back in one page.  Cursor is opaque
+// to callers, it is whatever NextCursor the previous page returned
+type VoterQuery struct {
+	Limit  int
+	Cursor string
+	Sort   string
+	Name   string
+	Email  string
+}
+
+// VoterPage is one page of voters.  NextCursor is empty on the last page
+type VoterPage struct {
+	Voters     []Voter
+	NextCursor string
+}
+
+func (q VoterQuery) Validate() error {
+	switch q.Sort {
+	case SortNone, SortName, SortVoterId:
+	default:
+		return errors.New("sort must be name or voter_id")
+	}
+	if q.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+	return nil
+}
+
+// Matches reports whether a voter passes the name and email filters.  Both
+// are case insensitive substring matches, an empty filter matches anything
+func (q VoterQuery) Matches(v Voter) bool {
+	if q.Name != "" && !strings.Contains(strings.ToLower(v.Name), strings.ToLower(q.Name)) {
+		return false
+	}
+	if q.Email != "" && !strings.Contains(strings.ToLower(v.Email), strings.ToLower(q.Email)) {
+		return false
+	}
+	return true
+}
+
+func (q VoterQuery) filter(voters []Voter) []Voter {
+	res := make([]Voter, 0, len(voters))
+	for _, v := range voters {
+		if q.Matches(v) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// sortVoters orders voters by q.Sort.  Ties on name fall back to the
+// voter id so the order, and with it the paging, is stable
+func (q VoterQuery) sortVoters(voters []Voter) {
+	switch q.Sort {
+	case SortName:
+		sort.Slice(voters, func(i, j int) bool {
+			if voters[i].Name != voters[j].Name {
+				return voters[i].Name < voters[j].Name
+			}
+			return voters[i].VoterId < voters[j].VoterId
+		})
+	default:
+		sort.Slice(voters, func(i, j int) bool {
+			return voters[i].VoterId < voters[j].VoterId
+		})
+	}
+}
+
+// page cuts one page out of voters, which are already filtered and sorted.
+// The cursor is the offset the page starts at
+func (q VoterQuery) page(voters []Voter) (VoterPage, error) {
+	offset := 0
+	if q.Cursor != "" {
+		n, err := strconv.Atoi(q.Cursor)
+		if err != nil || n < 0 {
+			return VoterPage{}, ErrInvalidCursor
+		}
+		offset = n
+	}
+
+	if offset > len(voters) {
+		offset = len(voters)
+	}
+	end := len(voters)
+	if q.Limit > 0 && offset+q.Limit < end {
+		end = offset + q.Limit
+	}
+
+	page := VoterPage{Voters: voters[offset:end]}
+	if end < len(voters) {
+		page.NextCursor = strconv.Itoa(end)
+	}
+	return page, nil
+}
@@ -0,0 +1,902 @@
+package db
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	RedisNilError        = "redis: nil"
+	RedisDefaultLocation = "0.0.0.0:6379"
+	RedisKeyPrefix       = "voter:"
+
+	//RedisNextIdKey is the counter for server assigned voter ids.  It is
+	//outside the voter:* keyspace so SCAN and DeleteAll leave it alone
+	RedisNextIdKey = "voters:next_id"
+)
+
+type cache struct {
+	client *redis.Client
+}
+
+// VoterCache is the redis backed VoterStore.  Voters are stored as
+// RedisJSON documents under voter:<id>
+type VoterCache struct {
+	cache
+
+	//keyPrefix goes in front of every key and the search index name, so
+	//several deployments can share one redis.  Empty by default
+	keyPrefix string
+
+	//set once the RediSearch index exists, see createSearchIndex
+	searchEnabled bool
+
+	//opTimeout bounds each store operation, see withTimeout
+	opTimeout time.Duration
+}
+
+// RedisConfig is how to reach redis.  Zero timeouts keep the go-redis
+// defaults.  OpTimeout is the longest one store operation, with all the
+// commands it takes, may run.  Zero leaves it to the caller's context
+type RedisConfig struct {
+	Addr         string
+	Password     string
+	DB           int
+	TLS          bool
+	KeyPrefix    string
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	OpTimeout    time.Duration
+}
+
+func NewVoterCache() (*VoterCache, error) {
+	redisUrl := os.Getenv("REDIS_URL")
+	if redisUrl == "" {
+		redisUrl = RedisDefaultLocation
+	}
+	return NewWithCacheInstance(redisUrl)
+}
+
+func NewWithCacheInstance(location string) (*VoterCache, error) {
+	return NewWithRedisConfig(RedisConfig{Addr: location})
+}
+
+// RedisOptions is the go-redis options for cfg.  Anything not in cfg keeps
+// the go-redis default.  The rate limiter connects with these too.
+//
+// go-redis only waits out the read and write timeouts unless told to
+// honour the context deadline as well
+func RedisOptions(cfg RedisConfig) *redis.Options {
+	opts := &redis.Options{
+		Addr:                  cfg.Addr,
+		Password:              cfg.Password,
+		DB:                    cfg.DB,
+		DialTimeout:           cfg.DialTimeout,
+		ReadTimeout:           cfg.ReadTimeout,
+		WriteTimeout:          cfg.WriteTimeout,
+		ContextTimeoutEnabled: true,
+	}
+	if cfg.TLS {
+		opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return opts
+}
+
+func NewWithRedisConfig(cfg RedisConfig) (*VoterCache, error) {
+
+	//Connect to redis
+	client := redis.NewClient(RedisOptions(cfg))
+
+	//Requests bring their own context, this one is only for connecting
+	//and setting up the index
+	ctx := context.Background()
+
+	//A span for every command, see tracingHook.  Errors that don't come
+	//from redis itself, like a refused connection or a timeout, become
+	//ErrUnavailable so the api can answer 503
+	TraceRedis(client)
+	client.AddHook(unavailableHook{})
+
+	//This is the reccomended way to ensure that our redis connection
+	//is working
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		slog.Error("Error connecting to redis", "addr", cfg.Addr, "err", err)
+		client.Close()
+		return nil, err
+	}
+
+	//Return a pointer to a new VoterCache struct
+	voterCache := &VoterCache{
+		cache: cache{
+			client: client,
+		},
+		keyPrefix: cfg.KeyPrefix,
+		opTimeout: cfg.OpTimeout,
+	}
+	voterCache.createSearchIndex(ctx)
+
+	return voterCache, nil
+}
+
+//------------------------------------------------------------
+// REDIS HELPERS
+//------------------------------------------------------------
+
+// We will use this later, you can ignore for now
+func isRedisNilError(err error) bool {
+	return errors.Is(err, redis.Nil) || err.Error() == RedisNilError
+}
+
+// unavailableHook marks errors that did not come back from the redis server
+// as the backend being unavailable, or too slow if we gave up waiting.
+// redis.Nil and TxFailedErr are redis.Errors, so the store logic that
+// checks for them still works
+type unavailableHook struct{}
+
+func backendError(err error) error {
+	var rerr redis.Error
+	var nerr net.Error
+	switch {
+	case err == nil, errors.As(err, &rerr):
+		return err
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &nerr) && nerr.Timeout():
+		return timedOut(err)
+	}
+	return unavailable("backend_unavailable", err)
+}
+
+func (unavailableHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		return conn, backendError(err)
+	}
+}
+
+func (unavailableHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		return backendError(next(ctx, cmd))
+	}
+}
+
+func (unavailableHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		return backendError(next(ctx, cmds))
+	}
+}
+
+// withTimeout is ctx bounded by opTimeout.  Every exported method starts
+// with it, so a slow redis costs a request at most opTimeout no matter how
+// many commands or WATCH retries the operation takes
+func (v *VoterCache) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if v.opTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, v.opTimeout)
+}
+
+// In redis, our keys will be strings, they will look like
+// voter:<number>.  This function will take an integer and
+// return a string that can be used as a key in redis
+func (v *VoterCache) redisKeyFromId(id uint) string {
+	return fmt.Sprintf("%s%s%d", v.keyPrefix, RedisKeyPrefix, id)
+}
+
+// key puts the configured prefix in front of one of our fixed key names
+func (v *VoterCache) key(name string) string {
+	return v.keyPrefix + name
+}
+
+// scanBatchSize is the COUNT hint for SCAN and the most keys we ask for
+// in one JSON.MGET when walking the whole keyspace
+const scanBatchSize = 500
+
+// scanKeys runs one SCAN step over the voter keys.  Unlike KEYS it only
+// looks at about count keys per call, so it never blocks redis for long
+func (v *VoterCache) scanKeys(ctx context.Context, cursor uint64, count int64) ([]string, uint64, error) {
+	key := v.key(RedisKeyPrefix + "*")
+	return v.client.Scan(ctx, cursor, key, count).Result()
+}
+
+// getAllKeys will return all keys in the database that match the prefix
+// used in this application - RedisKeyPrefix.  It will return a string slice
+// of all keys.  Used by GetAll and DeleteAll
+func (v *VoterCache) getAllKeys(ctx context.Context) ([]string, error) {
+	var keyList []string
+
+	//SCAN may hand back a key more than once, so dedupe as we go
+	seen := make(map[string]bool)
+	cursor := uint64(0)
+	for {
+		keys, next, err := v.scanKeys(ctx, cursor, scanBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			if !seen[k] {
+				seen[k] = true
+				keyList = append(keyList, k)
+			}
+		}
+		if next == 0 {
+			return keyList, nil
+		}
+		cursor = next
+	}
+}
+
+// getItemsFromRedis fetches many voters with a single JSON.MGET rather
+// than one JSON.GET per key.  Keys deleted since they were scanned come
+// back empty and are skipped
+func (v *VoterCache) getItemsFromRedis(ctx context.Context, keys []string) ([]Voter, error) {
+	resList := make([]Voter, 0, len(keys))
+	if len(keys) == 0 {
+		return resList, nil
+	}
+
+	items, err := v.client.JSONMGet(ctx, ".", keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		itemJson, ok := item.(string)
+		if !ok || itemJson == "" {
+			continue
+		}
+		var voter Voter
+		if err := fromJsonString(itemJson, &voter); err != nil {
+			return nil, err
+		}
+		resList = append(resList, voter)
+	}
+	return resList, nil
+}
+
+func fromJsonString(s string, item *Voter) error {
+	err := json.Unmarshal([]byte(s), &item)
+	if err != nil {
+		return err
+	}
+	//Voters written before there were revisions have none, they get the
+	//revision every new voter starts at.  0 is AnyRevision in If-Match,
+	//so it could never be asked for
+	if item.Revision == 0 {
+		item.Revision = 1
+	}
+	return nil
+}
+
+// jsonString is s as a JSON string.  JSONSet sends a Go string as it is,
+// but RedisJSON only takes JSON, so John has to go over as "John"
+func jsonString(s string) []byte {
+	b, _ := json.Marshal(s)
+	return b
+}
+
+// insertVoter writes a new voter with JSON.SET NX, which only sets the
+// key if it does not exist.  Checking and writing in one command means two
+// creates of the same id can't both succeed
+func (v *VoterCache) insertVoter(ctx context.Context, item *Voter) error {
+	//The poll history operations append to $.vote_history, so it has to
+	//be stored as [] rather than null
+	if item.VoteHistory == nil {
+		item.VoteHistory = make([]VoterHistory, 0)
+	}
+	slog.DebugContext(ctx, "Adding voter", "key", v.redisKeyFromId(item.VoterId))
+
+	//NX answers nil rather than OK when the key is already there
+	err := v.client.JSONSetMode(ctx, v.redisKeyFromId(item.VoterId), "$", item, "NX").Err()
+	if err != nil && isRedisNilError(err) {
+		return voterExists(item.VoterId)
+	}
+	return err
+}
+
+// Helper to return a Voter from redis provided an id
+func (v *VoterCache) getItemFromRedis(ctx context.Context, id uint, item *Voter) error {
+
+	//Lets query redis for the item, note we can return parts of the
+	//json structure, the second parameter "." means return the entire
+	//json structure
+	itemJson, err := v.client.JSONGet(ctx, v.redisKeyFromId(id), ".").Result()
+	if err != nil && !isRedisNilError(err) {
+		return err
+	}
+	if itemJson == "" {
+		return voterNotFound(id)
+	}
+
+	return fromJsonString(itemJson, item)
+}
+
+//------------------------------------------------------------
+// REDIS HELPERS-END
+//------------------------------------------------------------
+
+// raiseNextId moves the RedisNextIdKey counter up to id if it is below
+// it, so INCR never hands out an id a caller picked.  The counter is
+// WATCHed, a racing INCR or raise makes us read it again
+func (v *VoterCache) raiseNextId(ctx context.Context, id uint) error {
+	key := v.key(RedisNextIdKey)
+	txf := func(tx *redis.Tx) error {
+		cur, err := tx.Get(ctx, key).Uint64()
+		if err != nil && !isRedisNilError(err) {
+			return err
+		}
+		if cur >= uint64(id) {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return pipe.Set(ctx, key, uint64(id), 0).Err()
+		})
+		return err
+	}
+
+	for i := 0; i < maxWatchRetries; i++ {
+		err := v.client.Watch(ctx, txf, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return newError(ErrConflict, "voter_busy", "voter id counter kept changing, giving up")
+}
+
+// AddVoter takes the next id from the RedisNextIdKey counter when the voter
+// has none.  INCR never hands out the same number twice, and a caller
+// supplied id raises the counter before it is inserted.  Voters stored
+// before the counter was raised can still be ahead of it, so if the insert
+// finds the id taken we move on to the next one
+func (v *VoterCache) AddVoter(ctx context.Context, item Voter) (uint, error) {
+	ctx, cancel := v.withTimeout(ctx)
+	defer cancel()
+	item.Revision = 1
+	if item.VoterId != 0 {
+		if err := v.raiseNextId(ctx, item.VoterId); err != nil {
+			return 0, err
+		}
+		if err := v.insertVoter(ctx, &item); err != nil {
+			return 0, err
+		}
+		return item.VoterId, nil
+	}
+
+	for i := 0; i < maxWatchRetries; i++ {
+		id, err := v.client.Incr(ctx, v.key(RedisNextIdKey)).Uint64()
+		if err != nil {
+			return 0, err
+		}
+		item.VoterId = uint(id)
+
+		err = v.insertVoter(ctx, &item)
+		if err == nil {
+			return item.VoterId, nil
+		}
+		if !errors.Is(err, ErrConflict) {
+			return 0, err
+		}
+	}
+	return 0, newError(ErrConflict, "voter_busy", "no free voter id after %d tries", maxWatchRetries)
+}
+
+func (v *VoterCache) GetVoter(ctx context.Context, id uint) (Voter, error) {
+	ctx, cancel := v.withTimeout(ctx)
+	defer cancel()
+	var voter Voter
+	err := v.getItemFromRedis(ctx, id, &voter)
+	if err != nil {
+		return Voter{}, err
+	}
+	return voter, nil
+}
+
+func (v *VoterCache) GetAllVoters(ctx context.Context) ([]Voter, error) {
+	ctx, cancel := v.withTimeout(ctx)
+	defer cancel()
+
+	keyList, err := v.getAllKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	//preallocate the slice, will make things faster
+	resList := make([]Voter, 0, len(keyList))
+
+	for start := 0; start < len(keyList); start += scanBatchSize {
+		end := min(start+scanBatchSize, len(keyList))
+		batch, err := v.getItemsFromRedis(ctx, keyList[start:end])
+		if err != nil {
+			return nil, err
+		}
+		resList = append(resList, batch...)
+	}
+
+	return resList, nil
+}
+
+// redisCursor is where an unsorted listing picks up again: the SCAN
+// cursor, and the ids of voters an earlier SCAN step found that didn't
+// fit on their page.  It reads "<scan>" or "<scan>:<id>,<id>,..."
+type redisCursor struct {
+	scan uint64
+	held []uint
+}
+
+func parseRedisCursor(s string) (redisCursor, error) {
+	var cur redisCursor
+	if s == "" {
+		return cur, nil
+	}
+	scan, held, _ := strings.Cut(s, ":")
+	n, err := strconv.ParseUint(scan, 10, 64)
+	if err != nil {
+		return cur, ErrInvalidCursor
+	}
+	cur.scan = n
+	if held == "" {
+		return cur, nil
+	}
+	for _, idStr := range strings.Split(held, ",") {
+		id, err := strconv.ParseUint(idStr, 10, 0)
+		if err != nil {
+			return cur, ErrInvalidCursor
+		}
+		cur.held = append(cur.held, uint(id))
+	}
+	return cur, nil
+}
+
+func (c redisCursor) String() string {
+	s := strconv.FormatUint(c.scan, 10)
+	if len(c.held) == 0 {
+		return s
+	}
+	ids := make([]string, len(c.held))
+	for i, id := range c.held {
+		ids[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return s + ":" + strings.Join(ids, ",")
+}
+
+// ListVoters returns one page of voters.  Unsorted, the cursor carries the
+// redis SCAN cursor, so a page only costs one or a few SCAN steps plus a
+// JSON.MGET.  SCAN has no order and COUNT is only a hint, so a step can
+// find more voters than the page has room for.  Those ride along in the
+// cursor and start the next page, a page never holds more than Limit.
+// SCAN can't sort across pages, so a sorted listing, or one without a
+// Limit, reads every voter and pages by offset like the memory store
+func (v *VoterCache) ListVoters(ctx context.Context, q VoterQuery) (VoterPage, error) {
+	ctx, cancel := v.withTimeout(ctx)
+	defer cancel()
+
+	if q.Limit == 0 || q.Sort != SortNone {
+		voterList, err := v.GetAllVoters(ctx)
+		if err != nil {
+			return VoterPage{}, err
+		}
+		voterList = q.filter(voterList)
+		q.sortVoters(voterList)
+		return q.page(voterList)
+	}
+
+	cur, err := parseRedisCursor(q.Cursor)
+	if err != nil {
+		return VoterPage{}, err
+	}
+
+	//The voters held over from the last page go first.  Any deleted since
+	//are simply missing from the JSON.MGET
+	heldKeys := make([]string, len(cur.held))
+	for i, id := range cur.held {
+		heldKeys[i] = v.redisKeyFromId(id)
+	}
+	voterList, err := v.getItemsFromRedis(ctx, heldKeys)
+	if err != nil {
+		return VoterPage{}, err
+	}
+	voterList = q.filter(voterList)
+
+	//Keep scanning until the page is full or the keyspace is done, the
+	//filters can throw away most of a SCAN step
+	scanning := q.Cursor == "" || cur.scan != 0
+	for scanning && len(voterList) < q.Limit {
+		keys, next, err := v.scanKeys(ctx, cur.scan, int64(q.Limit-len(voterList)))
+		if err != nil {
+			return VoterPage{}, err
+		}
+		batch, err := v.getItemsFromRedis(ctx, keys)
+		if err != nil {
+			return VoterPage{}, err
+		}
+		voterList = append(voterList, q.filter(batch)...)
+		cur.scan = next
+		scanning = next != 0
+	}
+
+	cur.held = nil
+	if len(voterList) > q.Limit {
+		for _, voter := range voterList[q.Limit:] {
+			cur.held = append(cur.held, voter.VoterId)
+		}
+		voterList = voterList[:q.Limit]
+	}
+
+	page := VoterPage{Voters: voterList}
+	if scanning || len(cur.held) > 0 {
+		page.NextCursor = cur.String()
+	}
+	return page, nil
+}
+
+func (v *VoterCache) UpdateVoter(ctx context.Context, id uint, rev uint64, item Voter) error {
+	ctx, cancel := v.withTimeout(ctx)
+	defer cancel()
+	return v.watchVoter(ctx, id, rev, func(tx *redis.Tx, cur uint64) error {
+		item.VoterId = id
+		item.Revision = cur + 1
+		if item.VoteHistory == nil {
+			item.VoteHistory = make([]VoterHistory, 0)
+		}
+
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return pipe.JSONSet(ctx, v.redisKeyFromId(id), ".", &item).Err()
+		})
+		return err
+	})
+}
+
+// PatchVoter applies the patch to the voter read under WATCH and then only
+// writes the fields the patch changed, each with its own JSON.SET
+func (v *VoterCache) PatchVoter(ctx context.Context, id uint, rev uint64, patch VoterPatch) error {
+	ctx, cancel := v.withTimeout(ctx)
+	defer cancel()
+	key := v.redisKeyFromId(id)
+
+	return v.watchVoter(ctx, id, rev, func(tx *redis.Tx, cur uint64) error {
+		itemJson, err := tx.JSONGet(ctx, key, ".").Result()
+		if err != nil && !isRedisNilError(err) {
+			return err
+		}
+		if itemJson == "" {
+			return voterNotFound(id)
+		}
+		var voter Voter
+		if err := fromJsonString(itemJson, &voter); err != nil {
+			return err
+		}
+
+		patched, err := patch.Apply(voter)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if patched.Name != voter.Name {
+				pipe.JSONSet(ctx, key, "$.name", jsonString(patched.Name))
+			}
+			if patched.Email != voter.Email {
+				pipe.JSONSet(ctx, key, "$.email", jsonString(patched.Email))
+			}
+			if !reflect.DeepEqual(patched.VoteHistory, voter.VoteHistory) {
+				pipe.JSONSet(ctx, key, "$.vote_history", patched.VoteHistory)
+			}
+			pipe.JSONSet(ctx, key, "$.revision", cur+1)
+			return nil
+		})
+		return err
+	})
+}
+
+// Ping sends a redis PING.  The hook already turns a dead connection into
+// ErrUnavailable
+func (v *VoterCache) Ping(ctx context.Context) error {
+	ctx, cancel := v.withTimeout(ctx)
+	defer cancel()
+	return v.client.Ping(ctx).Err()
+}
+
+// Close closes the redis client and its connection pool.  Every write
+// is done by the time its call returns, so there is nothing to flush
+func (v *VoterCache) Close() error {
+	return v.client.Close()
+}
+
+func (v *VoterCache) DeleteAll(ctx context.Context) (int, error) {
+	ctx, cancel := v.withTimeout(ctx)
+	defer cancel()
+
+	keyList, err := v.getAllKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(keyList) == 0 {
+		return 0, nil
+	}
+
+	//Notice how we can deconstruct the slice into a variadic argument
+	//for the Del function by using the ... operator
+	numDeleted, err := v.client.Del(ctx, keyList...).Result()
+	return int(numDeleted), err
+}
+
+func (v *VoterCache) DeleteVoter(ctx context.Context, id uint, rev uint64) error {
+	ctx, cancel := v.withTimeout(ctx)
+	defer cancel()
+	return v.watchVoter(ctx, id, rev, func(tx *redis.Tx, cur uint64) error {
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return pipe.Del(ctx, v.redisKeyFromId(id)).Err()
+		})
+		return err
+	})
+}
+
+//------------------------------------------------------------
+// REVISIONS
+//
+// A conditional write has to read the revision and write in one atomic
+// step.  We WATCH the voter key, read $.revision, and do the write in
+// MULTI/EXEC.  If anyone else wrote the key in between, EXEC does nothing
+// and we try again with the new revision
+//------------------------------------------------------------
+
+// maxWatchRetries is how often watchVoter starts over when the voter keeps
+// changing under it
+const maxWatchRetries = 10
+
+// getRevision reads $.revision inside a WATCH.  Voters written before
+// there were revisions have none and count as revision 1, like they read
+// in fromJsonString
+func (v *VoterCache) getRevision(ctx context.Context, tx *redis.Tx, id uint) (uint64, error) {
+	revJson, err := tx.JSONGet(ctx, v.redisKeyFromId(id), "$.revision").Result()
+	if err != nil && !isRedisNilError(err) {
+		return 0, err
+	}
+	if revJson == "" {
+		return 0, voterNotFound(id)
+	}
+
+	var revs []uint64
+	if err := json.Unmarshal([]byte(revJson), &revs); err != nil {
+		return 0, err
+	}
+	if len(revs) == 0 || revs[0] == 0 {
+		return 1, nil
+	}
+	return revs[0], nil
+}
+
+// watchVoter checks the voter's revision against rev and then runs write,
+// which has to do its writes in tx.TxPipelined so they only land if the
+// voter did not change since we read the revision
+func (v *VoterCache) watchVoter(ctx context.Context, id uint, rev uint64, write func(tx *redis.Tx, cur uint64) error) error {
+	txf := func(tx *redis.Tx) error {
+		cur, err := v.getRevision(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := checkRevision(cur, rev); err != nil {
+			return err
+		}
+		return write(tx, cur)
+	}
+
+	for i := 0; i < maxWatchRetries; i++ {
+		err := v.client.Watch(ctx, txf, v.redisKeyFromId(id))
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return newError(ErrConflict, "voter_busy", "voter %d kept changing, giving up", id)
+}
+
+//------------------------------------------------------------
+// POLL HISTORY
+//
+// The poll history operations work on the vote_history array inside the
+// voter document with JSON path commands, they never read and rewrite the
+// whole voter.  Each one runs in MULTI together with the revision bump, so
+// two votes landing on the same voter at once cannot overwrite each other
+//------------------------------------------------------------
+
+// historyPath is the JSON path of every vote_history entry for a poll.
+// Using a filter rather than an array index means there is no window
+// where another write can shift the entry we meant to change
+func historyPath(pollId uint) string {
+	return fmt.Sprintf("$.vote_history[?(@.poll_id==%d)]", pollId)
+}
+
+// getHistory runs a JSON.GET with a $ path and decodes the matches
+func (v *VoterCache) getHistory(ctx context.Context, id uint, path string) ([]VoterHistory, error) {
+	historyJson, err := v.client.JSONGet(ctx, v.redisKeyFromId(id), path).Result()
+	if err != nil && !isRedisNilError(err) {
+		return nil, err
+	}
+	if historyJson == "" {
+		return nil, voterNotFound(id)
+	}
+
+	var matches []VoterHistory
+	if err := json.Unmarshal([]byte(historyJson), &matches); err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+func (v *VoterCache) GetVoterPoll(ctx context.Context, id uint) ([]VoterHistory, error) {
+	ctx, cancel := v.withTimeout(ctx)
+	defer cancel()
+
+	//$.vote_history matches one value, the array itself, so redis
+	//answers with an array holding that array
+	historyJson, err := v.client.JSONGet(ctx, v.redisKeyFromId(id), "$.vote_history").Result()
+	if err != nil && !isRedisNilError(err) {
+		return []VoterHistory{}, err
+	}
+	if historyJson == "" {
+		return []VoterHistory{}, voterNotFound(id)
+	}
+
+	var matches [][]VoterHistory
+	if err := json.Unmarshal([]byte(historyJson), &matches); err != nil {
+		return []VoterHistory{}, err
+	}
+	//A null history, from before insertVoter wrote [], is no history
+	if len(matches) == 0 || matches[0] == nil {
+		return []VoterHistory{}, nil
+	}
+	return matches[0], nil
+}
+
+func (v *VoterCache) GetVoterPollId(ctx context.Context, id, pollId uint) (VoterHistory, error) {
+	ctx, cancel := v.withTimeout(ctx)
+	defer cancel()
+	matches, err := v.getHistory(ctx, id, historyPath(pollId))
+	if err != nil {
+		return VoterHistory{}, err
+	}
+	if len(matches) == 0 {
+		return VoterHistory{}, voterPollNotFound(id, pollId)
+	}
+	return matches[0], nil
+}
+
+func (v *VoterCache) AddVoterPoll(ctx context.Context, id uint, voterPoll VoterHistory) error {
+	ctx, cancel := v.withTimeout(ctx)
+	defer cancel()
+
+	//Unlike JSONSet, JSONArrAppend expects values that are already json
+	pollJson, err := json.Marshal(voterPoll)
+	if err != nil {
+		return err
+	}
+
+	//A poll can only be in the history once, so check for it under the
+	//WATCH.  MULTI keeps the append and the revision bump together
+	return v.watchVoter(ctx, id, AnyRevision, func(tx *redis.Tx, cur uint64) error {
+		found, err := v.hasHistory(ctx, tx, id, voterPoll.PollId)
+		if err != nil {
+			return err
+		}
+		if found {
+			return voterPollExists(id, voterPoll.PollId)
+		}
+
+		//Voters stored before insertVoter wrote [] can have a null
+		//history, which ARRAPPEND can't append to.  The same MULTI makes
+		//it an array first
+		noArray, err := v.historyNotArray(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		var appendCmd *redis.IntSliceCmd
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if noArray {
+				pipe.JSONSet(ctx, v.redisKeyFromId(id), "$.vote_history", "[]")
+			}
+			appendCmd = pipe.JSONArrAppend(ctx, v.redisKeyFromId(id), "$.vote_history", string(pollJson))
+			pipe.JSONSet(ctx, v.redisKeyFromId(id), "$.revision", cur+1)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		//JSON.ARRAPPEND answers with the new array length for every match,
+		//or nil (read back as 0) if the path is not an array
+		lengths := appendCmd.Val()
+		if len(lengths) == 0 || lengths[0] == 0 {
+			return errors.New("voter has no vote history array")
+		}
+		return nil
+	})
+}
+
+// historyNotArray reports whether the voter's vote_history is null or
+// missing rather than an array, read inside the WATCH
+func (v *VoterCache) historyNotArray(ctx context.Context, tx *redis.Tx, id uint) (bool, error) {
+	historyJson, err := tx.JSONGet(ctx, v.redisKeyFromId(id), "$.vote_history").Result()
+	if err != nil && !isRedisNilError(err) {
+		return false, err
+	}
+
+	var matches []json.RawMessage
+	if historyJson != "" {
+		if err := json.Unmarshal([]byte(historyJson), &matches); err != nil {
+			return false, err
+		}
+	}
+	return len(matches) == 0 || string(matches[0]) == "null", nil
+}
+
+// hasHistory reports whether the voter has an entry for pollId, read
+// inside the WATCH so the write that follows sees the same history
+func (v *VoterCache) hasHistory(ctx context.Context, tx *redis.Tx, id, pollId uint) (bool, error) {
+	historyJson, err := tx.JSONGet(ctx, v.redisKeyFromId(id), historyPath(pollId)).Result()
+	if err != nil && !isRedisNilError(err) {
+		return false, err
+	}
+
+	var matches []VoterHistory
+	if historyJson != "" {
+		if err := json.Unmarshal([]byte(historyJson), &matches); err != nil {
+			return false, err
+		}
+	}
+	return len(matches) > 0, nil
+}
+
+func (v *VoterCache) UpdateVoterPoll(ctx context.Context, id, pollId uint, rev uint64, voterHistory VoterHistory) error {
+	ctx, cancel := v.withTimeout(ctx)
+	defer cancel()
+	return v.watchVoter(ctx, id, rev, func(tx *redis.Tx, cur uint64) error {
+		found, err := v.hasHistory(ctx, tx, id, pollId)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return voterPollNotFound(id, pollId)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.JSONSet(ctx, v.redisKeyFromId(id), historyPath(pollId), &voterHistory)
+			pipe.JSONSet(ctx, v.redisKeyFromId(id), "$.revision", cur+1)
+			return nil
+		})
+		return err
+	})
+}
+
+func (v *VoterCache) DeleteVoterPoll(ctx context.Context, id, pollId uint, rev uint64) error {
+	ctx, cancel := v.withTimeout(ctx)
+	defer cancel()
+	return v.watchVoter(ctx, id, rev, func(tx *redis.Tx, cur uint64) error {
+		found, err := v.hasHistory(ctx, tx, id, pollId)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return voterPollNotFound(id, pollId)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.JSONDel(ctx, v.redisKeyFromId(id), historyPath(pollId))
+			pipe.JSONSet(ctx, v.redisKeyFromId(id), "$.revision", cur+1)
+			return nil
+		})
+		return err
+	})
+}
@@ -0,0 +1,17 @@
+package db
+
+// AnyRevision makes an update or delete unconditional
+const AnyRevision uint64 = 0
+
+// ErrRevisionMismatch is returned when the voter changed since the caller
+// read it, the api answers 412 Precondition Failed
+var ErrRevisionMismatch = newError(ErrPrecondition, "revision_mismatch", "voter revision does not match")
+
+// checkRevision compares the stored revision with the one the caller
+// expects
+func checkRevision(cur, want uint64) error {
+	if want != AnyRevision && want != cur {
+		return ErrRevisionMismatch
+	}
+	return nil
+}
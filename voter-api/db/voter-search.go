@@ -0,0 +1,222 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode"
+)
+
+const (
+	RedisSearchIndex = "idx:voters"
+
+	//DefaultSearchLimit is how many voters a search returns when the
+	//caller does not say
+	DefaultSearchLimit = 100
+)
+
+// ErrSearchUnavailable is returned when redis has no RediSearch module,
+// for example a plain redis image instead of redis-stack
+var ErrSearchUnavailable = newError(ErrUnavailable, "search_unavailable", "voter search is not available")
+
+// VoterSearch is a lookup by name and/or email.  Name is full text, every
+// word has to appear in the voter's name.  Email is an exact match.  A
+// word or email ending in * matches as a prefix, so jo* finds John
+type VoterSearch struct {
+	Name  string
+	Email string
+	Limit int
+}
+
+func (s VoterSearch) Validate() error {
+	if strings.TrimSpace(s.Name) == "" && strings.TrimSpace(s.Email) == "" {
+		return errors.New("name or email is required")
+	}
+	if s.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+	return nil
+}
+
+func (s VoterSearch) limit() int {
+	if s.Limit == 0 {
+		return DefaultSearchLimit
+	}
+	return s.Limit
+}
+
+// splitWords breaks text into the lower case words RediSearch would index
+func splitWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '*'
+	})
+}
+
+func matchTerm(term, value string) bool {
+	if prefix, ok := strings.CutSuffix(term, "*"); ok {
+		return strings.HasPrefix(value, prefix)
+	}
+	return term == value
+}
+
+// Matches applies the search to a single voter the same way the redis
+// index would.  The memory store uses it to scan the map
+func (s VoterSearch) Matches(v Voter) bool {
+	if email := strings.ToLower(strings.TrimSpace(s.Email)); email != "" {
+		if !matchTerm(email, strings.ToLower(v.Email)) {
+			return false
+		}
+	}
+
+	nameWords := splitWords(v.Name)
+	for _, term := range splitWords(s.Name) {
+		found := false
+		for _, w := range nameWords {
+			if matchTerm(term, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (v *VoterList) SearchVoters(ctx context.Context, s VoterSearch) ([]Voter, error) {
+	voterList, _ := v.GetAllVoters(ctx)
+
+	res := make([]Voter, 0)
+	for _, voter := range voterList {
+		if s.Matches(voter) {
+			res = append(res, voter)
+		}
+	}
+
+	VoterQuery{Sort: SortVoterId}.sortVoters(res)
+	if len(res) > s.limit() {
+		res = res[:s.limit()]
+	}
+	return res, nil
+}
+
+//------------------------------------------------------------
+// REDISEARCH
+//------------------------------------------------------------
+
+// escapeSearchTerm backslash escapes everything RediSearch treats as
+// syntax, like the @ and . in an email.  A trailing * is left alone so it
+// still works as a prefix match
+func escapeSearchTerm(term string) string {
+	prefix := strings.HasSuffix(term, "*")
+	term = strings.TrimSuffix(term, "*")
+
+	var b strings.Builder
+	for _, r := range term {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	if prefix {
+		b.WriteRune('*')
+	}
+	return b.String()
+}
+
+// searchQuery builds the FT.SEARCH query string, for example
+// @name:(john do*) @email:{john\@example\.com}
+func (s VoterSearch) searchQuery() string {
+	var parts []string
+
+	if words := splitWords(s.Name); len(words) > 0 {
+		for i, w := range words {
+			words[i] = escapeSearchTerm(w)
+		}
+		parts = append(parts, fmt.Sprintf("@name:(%s)", strings.Join(words, " ")))
+	}
+	if email := strings.ToLower(strings.TrimSpace(s.Email)); email != "" {
+		parts = append(parts, fmt.Sprintf("@email:{%s}", escapeSearchTerm(email)))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// createSearchIndex makes sure the RediSearch index over the voter:*
+// JSON documents exists.  Redis indexes documents as they are written, so
+// this only has to run once at startup.  Without the search module the
+// rest of the store still works, only SearchVoters is unavailable
+func (v *VoterCache) createSearchIndex(ctx context.Context) {
+	err := v.client.Do(ctx, "FT.CREATE", v.key(RedisSearchIndex),
+		"ON", "JSON",
+		"PREFIX", "1", v.key(RedisKeyPrefix),
+		"SCHEMA",
+		"$.name", "AS", "name", "TEXT",
+		"$.email", "AS", "email", "TAG").Err()
+
+	switch {
+	case err == nil:
+		v.searchEnabled = true
+		slog.Info("Created search index", "index", v.key(RedisSearchIndex))
+	case strings.Contains(strings.ToLower(err.Error()), "index already exists"):
+		v.searchEnabled = true
+	default:
+		slog.Warn("Voter search disabled, could not create index", "err", err)
+	}
+}
+
+// searchResultKeys pulls the document keys out of an FT.SEARCH NOCONTENT
+// reply.  RESP2 answers [total, key, key, ...], RESP3 answers a map with
+// a results array of {id: key} maps
+func searchResultKeys(reply interface{}) ([]string, error) {
+	var keys []string
+
+	switch r := reply.(type) {
+	case []interface{}:
+		for _, k := range r[min(1, len(r)):] {
+			if key, ok := k.(string); ok {
+				keys = append(keys, key)
+			}
+		}
+	case map[interface{}]interface{}:
+		results, _ := r["results"].([]interface{})
+		for _, res := range results {
+			if m, ok := res.(map[interface{}]interface{}); ok {
+				if key, ok := m["id"].(string); ok {
+					keys = append(keys, key)
+				}
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unexpected FT.SEARCH reply %T", reply)
+	}
+
+	return keys, nil
+}
+
+func (v *VoterCache) SearchVoters(ctx context.Context, s VoterSearch) ([]Voter, error) {
+	if !v.searchEnabled {
+		return nil, ErrSearchUnavailable
+	}
+	ctx, cancel := v.withTimeout(ctx)
+	defer cancel()
+
+	reply, err := v.client.Do(ctx, "FT.SEARCH", v.key(RedisSearchIndex), s.searchQuery(),
+		"NOCONTENT",
+		"LIMIT", "0", s.limit(),
+		"DIALECT", "2").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := searchResultKeys(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	//The index only gives us keys, fetch the voters in one round trip
+	return v.getItemsFromRedis(ctx, keys)
+}
@@ -0,0 +1,227 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"drexel.edu/todo/tracing"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span attributes of our own, semconv has nothing for them
+const (
+	attrStore   = "voter.store"
+	attrVoterId = "voter.id"
+	attrPollId  = "voter.poll_id"
+)
+
+// TracedStore wraps a VoterStore and starts a span for every operation, so
+// a trace shows the time a request spent in the store apart from the
+// rest.  With redis each command is a span of its own under it, see
+// tracingHook
+type TracedStore struct {
+	store     VoterStore
+	storeType string
+}
+
+// NewTracedStore wraps store, of type storeType
+func NewTracedStore(store VoterStore, storeType string) *TracedStore {
+	return &TracedStore{store: store, storeType: storeType}
+}
+
+// clientError is true for the errors that are the caller's doing, like a
+// voter that is not there.  Those don't mark the span as failed
+func clientError(err error) bool {
+	for _, kind := range []error{ErrNotFound, ErrConflict, ErrValidation, ErrPrecondition, ErrInvalidInput} {
+		if errors.Is(err, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// endSpan records err, if any, and ends span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		if !clientError(err) {
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+	span.End()
+}
+
+func (t *TracedStore) start(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String(attrStore, t.storeType))
+	return tracing.Tracer().Start(ctx, "store."+op, trace.WithAttributes(attrs...))
+}
+
+func voterId(id uint) attribute.KeyValue {
+	return attribute.Int64(attrVoterId, int64(id))
+}
+
+func pollId(id uint) attribute.KeyValue {
+	return attribute.Int64(attrPollId, int64(id))
+}
+
+func (t *TracedStore) AddVoter(ctx context.Context, voter Voter) (id uint, err error) {
+	ctx, span := t.start(ctx, "AddVoter")
+	defer func() { span.SetAttributes(voterId(id)); endSpan(span, err) }()
+	return t.store.AddVoter(ctx, voter)
+}
+
+func (t *TracedStore) GetVoter(ctx context.Context, id uint) (v Voter, err error) {
+	ctx, span := t.start(ctx, "GetVoter", voterId(id))
+	defer func() { endSpan(span, err) }()
+	return t.store.GetVoter(ctx, id)
+}
+
+func (t *TracedStore) GetAllVoters(ctx context.Context) (voters []Voter, err error) {
+	ctx, span := t.start(ctx, "GetAllVoters")
+	defer func() { span.SetAttributes(attribute.Int("voter.count", len(voters))); endSpan(span, err) }()
+	return t.store.GetAllVoters(ctx)
+}
+
+func (t *TracedStore) ListVoters(ctx context.Context, q VoterQuery) (page VoterPage, err error) {
+	ctx, span := t.start(ctx, "ListVoters", attribute.Int("voter.limit", q.Limit))
+	defer func() { span.SetAttributes(attribute.Int("voter.count", len(page.Voters))); endSpan(span, err) }()
+	return t.store.ListVoters(ctx, q)
+}
+
+func (t *TracedStore) SearchVoters(ctx context.Context, s VoterSearch) (voters []Voter, err error) {
+	ctx, span := t.start(ctx, "SearchVoters")
+	defer func() { span.SetAttributes(attribute.Int("voter.count", len(voters))); endSpan(span, err) }()
+	return t.store.SearchVoters(ctx, s)
+}
+
+func (t *TracedStore) UpdateVoter(ctx context.Context, id uint, rev uint64, voter Voter) (err error) {
+	ctx, span := t.start(ctx, "UpdateVoter", voterId(id))
+	defer func() { endSpan(span, err) }()
+	return t.store.UpdateVoter(ctx, id, rev, voter)
+}
+
+func (t *TracedStore) PatchVoter(ctx context.Context, id uint, rev uint64, patch VoterPatch) (err error) {
+	ctx, span := t.start(ctx, "PatchVoter", voterId(id))
+	defer func() { endSpan(span, err) }()
+	return t.store.PatchVoter(ctx, id, rev, patch)
+}
+
+func (t *TracedStore) DeleteVoter(ctx context.Context, id uint, rev uint64) (err error) {
+	ctx, span := t.start(ctx, "DeleteVoter", voterId(id))
+	defer func() { endSpan(span, err) }()
+	return t.store.DeleteVoter(ctx, id, rev)
+}
+
+func (t *TracedStore) DeleteAll(ctx context.Context) (n int, err error) {
+	ctx, span := t.start(ctx, "DeleteAll")
+	defer func() { span.SetAttributes(attribute.Int("voter.count", n)); endSpan(span, err) }()
+	return t.store.DeleteAll(ctx)
+}
+
+func (t *TracedStore) GetVoterPoll(ctx context.Context, id uint) (h []VoterHistory, err error) {
+	ctx, span := t.start(ctx, "GetVoterPoll", voterId(id))
+	defer func() { endSpan(span, err) }()
+	return t.store.GetVoterPoll(ctx, id)
+}
+
+func (t *TracedStore) GetVoterPollId(ctx context.Context, id, poll uint) (h VoterHistory, err error) {
+	ctx, span := t.start(ctx, "GetVoterPollId", voterId(id), pollId(poll))
+	defer func() { endSpan(span, err) }()
+	return t.store.GetVoterPollId(ctx, id, poll)
+}
+
+func (t *TracedStore) AddVoterPoll(ctx context.Context, id uint, voterPoll VoterHistory) (err error) {
+	ctx, span := t.start(ctx, "AddVoterPoll", voterId(id), pollId(voterPoll.PollId))
+	defer func() { endSpan(span, err) }()
+	return t.store.AddVoterPoll(ctx, id, voterPoll)
+}
+
+func (t *TracedStore) UpdateVoterPoll(ctx context.Context, id, poll uint, rev uint64, voterHistory VoterHistory) (err error) {
+	ctx, span := t.start(ctx, "UpdateVoterPoll", voterId(id), pollId(poll))
+	defer func() { endSpan(span, err) }()
+	return t.store.UpdateVoterPoll(ctx, id, poll, rev, voterHistory)
+}
+
+func (t *TracedStore) DeleteVoterPoll(ctx context.Context, id, poll uint, rev uint64) (err error) {
+	ctx, span := t.start(ctx, "DeleteVoterPoll", voterId(id), pollId(poll))
+	defer func() { endSpan(span, err) }()
+	return t.store.DeleteVoterPoll(ctx, id, poll, rev)
+}
+
+func (t *TracedStore) Ping(ctx context.Context) (err error) {
+	ctx, span := t.start(ctx, "Ping")
+	defer func() { endSpan(span, err) }()
+	return t.store.Ping(ctx)
+}
+
+// Close is not traced, it runs once on the way out
+func (t *TracedStore) Close() error {
+	return t.store.Close()
+}
+
+//------------------------------------------------------------
+// REDIS COMMANDS
+//------------------------------------------------------------
+
+// tracingHook starts a client span for every redis command, and one for
+// every pipeline or MULTI/EXEC with the commands in it
+type tracingHook struct{}
+
+// TraceRedis adds tracingHook to client.  Add it before the other hooks so
+// its spans cover them
+func TraceRedis(client *redis.Client) {
+	client.AddHook(tracingHook{})
+}
+
+// redisError is true for errors that are a failure, redis.Nil is only a
+// missing key
+func redisError(err error) bool {
+	return err != nil && !errors.Is(err, redis.Nil)
+}
+
+func (tracingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (tracingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, span := tracing.Tracer().Start(ctx, strings.ToUpper(cmd.FullName()),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemRedis, semconv.DBOperationName(cmd.FullName())))
+		defer span.End()
+
+		err := next(ctx, cmd)
+		if redisError(err) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	}
+}
+
+func (tracingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		names := make([]string, len(cmds))
+		for i, cmd := range cmds {
+			names[i] = cmd.FullName()
+		}
+		ctx, span := tracing.Tracer().Start(ctx, "PIPELINE",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemRedis,
+				semconv.DBOperationName(strings.Join(names, " ")),
+				attribute.Int("db.redis.commands", len(cmds))))
+		defer span.End()
+
+		err := next(ctx, cmds)
+		if redisError(err) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	}
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"drexel.edu/todo/validate"
+)
+
+// The longest name and email Validate takes, the OpenAPI spec shows them
+// too
+const (
+	MaxNameLength  = 100
+	MaxEmailLength = 254
+)
+
+// Validate is the rule set for a voter payload.  Revision is not checked,
+// the store owns it.  Neither is voter_id, 0 asks AddVoter for a new id and
+// the api takes it from the path on updates
+func (v Voter) Validate() error {
+	var errs validate.Errors
+
+	name := strings.TrimSpace(v.Name)
+	switch {
+	case name == "":
+		errs.Add("name", "is required")
+	case utf8.RuneCountInString(name) > MaxNameLength:
+		errs.Add("name", fmt.Sprintf("must be at most %d characters", MaxNameLength))
+	}
+
+	switch {
+	case v.Email == "":
+		errs.Add("email", "is required")
+	case len(v.Email) > MaxEmailLength:
+		errs.Add("email", fmt.Sprintf("must be at most %d characters", MaxEmailLength))
+	case !validEmail(v.Email):
+		errs.Add("email", "must be an email address like name@example.com")
+	}
+
+	seen := make(map[uint]bool)
+	for i, h := range v.VoteHistory {
+		field := fmt.Sprintf("vote_history[%d]", i)
+		errs.Nest(field, h.Validate())
+		if h.PollId != 0 && seen[h.PollId] {
+			errs.Add(field+".poll_id", "is already in the vote history")
+		}
+		seen[h.PollId] = true
+	}
+
+	return errs.Err()
+}
+
+// validEmail accepts a bare address only, mail.ParseAddress on its own
+// would also take "Name <name@example.com>"
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email && strings.Contains(email[strings.LastIndex(email, "@"):], ".")
+}
+
+// Validate is the rule set for one poll history entry
+func (h VoterHistory) Validate() error {
+	var errs validate.Errors
+
+	if h.PollId == 0 {
+		errs.Add("poll_id", "is required and must be greater than 0")
+	}
+	if h.VoteId == 0 {
+		errs.Add("vote_id", "is required and must be greater than 0")
+	}
+	if h.VoteDate.IsZero() {
+		errs.Add("vote_date", "is required")
+	}
+
+	return errs.Err()
+}
@@ -1,7 +1,9 @@
 package db
 
 import (
+	"context"
 	"errors"
+	"sync"
 	"time"
 )
 
@@ -11,15 +13,126 @@ type VoterHistory struct {
 	VoteDate time.Time `json:"vote_date"`
 }
 
+// Voter is one voter and their poll history.  Revision is owned by the
+// store, it starts at 1 and goes up on every change to the voter or its
+// history.  Whatever a client sends in it is ignored
 type Voter struct {
 	VoterId     uint           `json:"voter_id"`
 	Name        string         `json:"name"`
 	Email       string         `json:"email"`
 	VoteHistory []VoterHistory `json:"vote_history"`
+	Revision    uint64         `json:"revision"`
 }
 
+// VoterStore covers every voter and poll history operation the api
+// package needs.  VoterList keeps voters in memory, VoterFile adds a log
+// and snapshot on disk to that, and VoterCache keeps them in redis.  The
+// -store flag picks one at startup.
+//
+// The update and delete calls take the revision the caller last saw.  If
+// the voter has moved on since they fail with ErrRevisionMismatch, pass
+// AnyRevision to skip the check.
+//
+// AddVoter returns the id the voter was stored under.  A voter_id of 0
+// means the store picks the next free one, any other id is used as is
+// and fails with a conflict if it is taken
+type VoterStore interface {
+	AddVoter(ctx context.Context, voter Voter) (uint, error)
+	GetVoter(ctx context.Context, id uint) (Voter, error)
+	GetAllVoters(ctx context.Context) ([]Voter, error)
+	ListVoters(ctx context.Context, q VoterQuery) (VoterPage, error)
+	SearchVoters(ctx context.Context, s VoterSearch) ([]Voter, error)
+	UpdateVoter(ctx context.Context, id uint, rev uint64, voter Voter) error
+	PatchVoter(ctx context.Context, id uint, rev uint64, patch VoterPatch) error
+	DeleteVoter(ctx context.Context, id uint, rev uint64) error
+	DeleteAll(ctx context.Context) (int, error)
+
+	GetVoterPoll(ctx context.Context, id uint) ([]VoterHistory, error)
+	GetVoterPollId(ctx context.Context, id, pollId uint) (VoterHistory, error)
+	AddVoterPoll(ctx context.Context, id uint, voterPoll VoterHistory) error
+	UpdateVoterPoll(ctx context.Context, id, pollId uint, rev uint64, voterHistory VoterHistory) error
+	DeleteVoterPoll(ctx context.Context, id, pollId uint, rev uint64) error
+
+	//Ping checks the store can serve requests, for the readiness probe.
+	//It gives up when ctx is done
+	Ping(ctx context.Context) error
+
+	//Close flushes anything not yet durable and lets go of connections
+	//and files.  The store can't be used afterwards
+	Close() error
+}
+
+const (
+	StoreMemory = "memory"
+	StoreFile   = "file"
+	StoreRedis  = "redis"
+)
+
+// NewVoterStore returns the backend named by storeType, StoreMemory,
+// StoreFile or StoreRedis, set up from the environment like NewVoterFile
+// and NewVoterCache do
+func NewVoterStore(storeType string) (VoterStore, error) {
+	switch storeType {
+	case StoreMemory, "":
+		return NewVoterList()
+	case StoreFile:
+		return NewVoterFile()
+	case StoreRedis:
+		return NewVoterCache()
+	default:
+		return nil, errors.New("unknown store type: " + storeType)
+	}
+}
+
+// StoreConfig is everything needed to open a store.  Only the section for
+// Type is used
+type StoreConfig struct {
+	Type  string
+	File  FileConfig
+	Redis RedisConfig
+}
+
+// OpenVoterStore is NewVoterStore with the settings passed in rather than
+// read from the environment, the config package builds them
+func OpenVoterStore(cfg StoreConfig) (VoterStore, error) {
+	switch cfg.Type {
+	case StoreMemory, "":
+		return NewVoterList()
+	case StoreFile:
+		return NewWithFileConfig(cfg.File)
+	case StoreRedis:
+		return NewWithRedisConfig(cfg.Redis)
+	default:
+		return nil, errors.New("unknown store type: " + cfg.Type)
+	}
+}
+
+// VoterList is the in-memory VoterStore.  Fiber runs handlers on many
+// goroutines, so every access to the map goes through the mutex.  Holding
+// the write lock across a whole read-modify-write, like appending to a
+// voter's history, makes that operation atomic for the voter
 type VoterList struct {
+	mu     sync.RWMutex
 	Voters map[uint]Voter //A map of VoterIDs as keys and Voter structs as values
+
+	//nextId is the highest id ever added, caller supplied or not.  The
+	//next assigned id is one more, so it never hits a voter we have or
+	//had.  Like the map it is only touched under mu
+	nextId uint
+}
+
+// cloneHistory copies a vote history so the slice we keep in the map
+// never shares its backing array with one a caller holds
+func cloneHistory(h []VoterHistory) []VoterHistory {
+	if h == nil {
+		return nil
+	}
+	return append(make([]VoterHistory, 0, len(h)), h...)
+}
+
+func (v Voter) clone() Voter {
+	v.VoteHistory = cloneHistory(v.VoteHistory)
+	return v
 }
 
 func NewVoterList() (*VoterList, error) {
@@ -31,30 +144,47 @@ func NewVoterList() (*VoterList, error) {
 	return voterList, nil
 }
 
-func (v *VoterList) AddVoter(item Voter) error {
+func (v *VoterList) AddVoter(ctx context.Context, item Voter) (uint, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if item.VoterId == 0 {
+		item.VoterId = v.nextId + 1
+	}
 
 	//Before we add an item to the DB, lets make sure
 	//it does not exist, if it does, return an error
 	_, ok := v.Voters[item.VoterId]
 	if ok {
-		return errors.New("voter already exists")
+		return 0, voterExists(item.VoterId)
 	}
 
 	//Now that we know the item doesn't exist, lets add it to our map
-	v.Voters[item.VoterId] = item
+	item.Revision = 1
+	v.Voters[item.VoterId] = item.clone()
+	v.nextId = max(v.nextId, item.VoterId)
 
-	//If everything is ok, return nil for the error
-	return nil
+	//If everything is ok, return the id
+	return item.VoterId, nil
 }
 
-func (v *VoterList) AddVoterPoll(voterID uint, voterPoll VoterHistory) error {
+func (v *VoterList) AddVoterPoll(ctx context.Context, voterID uint, voterPoll VoterHistory) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
 
 	voter, ok := v.Voters[voterID]
 	if !ok {
-		return errors.New("voter does not exist")
+		return voterNotFound(voterID)
 	}
+	for _, poll := range voter.VoteHistory {
+		if poll.PollId == voterPoll.PollId {
+			return voterPollExists(voterID, voterPoll.PollId)
+		}
+	}
+
 	//Now that we know the item doesn't exist, lets add it to our map
-	voter.VoteHistory = append(voter.VoteHistory, voterPoll)
+	voter.VoteHistory = append(cloneHistory(voter.VoteHistory), voterPoll)
+	voter.Revision++
 	v.Voters[voterID] = voter
 
 	//If everything is ok, return nil for the error
@@ -141,31 +271,37 @@ func (v *VoterList) AddVoterPoll(voterID uint, voterPoll VoterHistory) error {
 // //		(2) If there is an error, it will be returned
 // //			along with an empty ToDoItem
 // //		(3) The database file will not be modified
-func (v *VoterList) GetVoter(id uint) (Voter, error) {
+func (v *VoterList) GetVoter(ctx context.Context, id uint) (Voter, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
 
 	voter, ok := v.Voters[id]
 	if !ok {
-		return Voter{}, errors.New("voter does not exist")
+		return Voter{}, voterNotFound(id)
 	}
 
-	return voter, nil
+	return voter.clone(), nil
 }
 
-func (v *VoterList) GetVoterPoll(id uint) ([]VoterHistory, error) {
+func (v *VoterList) GetVoterPoll(ctx context.Context, id uint) ([]VoterHistory, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
 
 	voter, ok := v.Voters[id]
 	if !ok {
-		return []VoterHistory{}, errors.New("voter does not exist")
+		return []VoterHistory{}, voterNotFound(id)
 	}
 
-	return voter.VoteHistory, nil
+	return cloneHistory(voter.VoteHistory), nil
 }
 
-func (v *VoterList) GetVoterPollId(id, pollId uint) (VoterHistory, error) {
+func (v *VoterList) GetVoterPollId(ctx context.Context, id, pollId uint) (VoterHistory, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
 
 	voter, ok := v.Voters[id]
 	if !ok {
-		return VoterHistory{}, errors.New("voter does not exist")
+		return VoterHistory{}, voterNotFound(id)
 	}
 
 	for _, poll := range voter.VoteHistory {
@@ -174,7 +310,7 @@ func (v *VoterList) GetVoterPollId(id, pollId uint) (VoterHistory, error) {
 		}
 	}
 
-	return VoterHistory{}, errors.New("voter poll not found")
+	return VoterHistory{}, voterPollNotFound(id, pollId)
 }
 
 // ChangeItemDoneStatus accepts an item id and a boolean status.
@@ -213,33 +349,64 @@ func (v *VoterList) GetVoterPollId(id, pollId uint) (VoterHistory, error) {
 //		(2) If there is an error, it will be returned
 //			along with an empty slice
 //		(3) The database file will not be modified
-func (v *VoterList) GetAllVoters() ([]Voter, error) {
+func (v *VoterList) GetAllVoters(ctx context.Context) ([]Voter, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
 
 	//Now that we have the DB loaded, lets crate a slice
 	var voterList []Voter
 
 	//Now lets iterate over our map and add each item to our slice
 	for _, item := range v.Voters {
-		voterList = append(voterList, item)
+		voterList = append(voterList, item.clone())
 	}
 
 	//Now that we have all of our items in a slice, return it
 	return voterList, nil
 }
 
-func (v *VoterList) DeleteAll() error {
+// ListVoters returns one page of voters.  The memory store has the whole
+// map at hand, so the cursor is just the offset of the next page into the
+// filtered and sorted list
+func (v *VoterList) ListVoters(ctx context.Context, q VoterQuery) (VoterPage, error) {
+	voterList, _ := v.GetAllVoters(ctx)
+	voterList = q.filter(voterList)
+	q.sortVoters(voterList)
+	return q.page(voterList)
+}
+
+// Ping always works, the map is in our own memory
+func (v *VoterList) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close has nothing to do, the voters go with the process
+func (v *VoterList) Close() error {
+	return nil
+}
+
+func (v *VoterList) DeleteAll(ctx context.Context) (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
 	//To delete everything, we can just create a new map
 	//and assign it to our existing map.  The garbage collector
 	//will clean up the old map for us
+	numDeleted := len(v.Voters)
 	v.Voters = make(map[uint]Voter)
 
-	return nil
+	return numDeleted, nil
 }
 
-func (v *VoterList) DeleteVoter(id uint) error {
+func (v *VoterList) DeleteVoter(ctx context.Context, id uint, rev uint64) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
 
-	if _, ok := v.Voters[id]; !ok {
-		return errors.New("voter does not exist")
+	voter, ok := v.Voters[id]
+	if !ok {
+		return voterNotFound(id)
+	}
+	if err := checkRevision(voter.Revision, rev); err != nil {
+		return err
 	}
 
 	delete(v.Voters, id)
@@ -247,46 +414,96 @@ func (v *VoterList) DeleteVoter(id uint) error {
 	return nil
 }
 
-func (v *VoterList) DeleteVoterPoll(id uint, pollId uint) error {
+func (v *VoterList) DeleteVoterPoll(ctx context.Context, id uint, pollId uint, rev uint64) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
 
 	voter, ok := v.Voters[id]
 	if !ok {
-		return errors.New("Voter not found")
+		return voterNotFound(id)
+	}
+	if err := checkRevision(voter.Revision, rev); err != nil {
+		return err
 	}
 
 	for i, vote := range voter.VoteHistory {
 		if vote.PollId == pollId {
-			voter.VoteHistory = append(voter.VoteHistory[:i], voter.VoteHistory[i+1:]...)
+			history := cloneHistory(voter.VoteHistory)
+			voter.VoteHistory = append(history[:i], history[i+1:]...)
+			voter.Revision++
 			v.Voters[id] = voter
 			return nil
 		}
 	}
+	return voterPollNotFound(id, pollId)
+}
+
+func (v *VoterList) UpdateVoter(ctx context.Context, id uint, rev uint64, voter Voter) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	cur, ok := v.Voters[id]
+	if !ok {
+		return voterNotFound(id)
+	}
+	if err := checkRevision(cur.Revision, rev); err != nil {
+		return err
+	}
+
+	voter.VoterId = id
+	voter.Revision = cur.Revision + 1
+	v.Voters[id] = voter.clone()
+
 	return nil
 }
 
-func (v *VoterList) UpdateVoter(id uint, voter Voter) error {
+// PatchVoter applies the patch to the stored voter under the write lock,
+// so it can't be lost to another write between the read and the write
+func (v *VoterList) PatchVoter(ctx context.Context, id uint, rev uint64, patch VoterPatch) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
 
+	cur, ok := v.Voters[id]
+	if !ok {
+		return voterNotFound(id)
+	}
+	if err := checkRevision(cur.Revision, rev); err != nil {
+		return err
+	}
+
+	voter, err := patch.Apply(cur.clone())
+	if err != nil {
+		return err
+	}
+	voter.Revision = cur.Revision + 1
 	v.Voters[id] = voter
 
 	return nil
 }
 
-func (v *VoterList) UpdateVoterPoll(id uint, pollId uint, voterHistory VoterHistory) error {
+func (v *VoterList) UpdateVoterPoll(ctx context.Context, id uint, pollId uint, rev uint64, voterHistory VoterHistory) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
 
 	voter, ok := v.Voters[id]
 	if !ok {
-		return errors.New("voter does not exist")
+		return voterNotFound(id)
+	}
+	if err := checkRevision(voter.Revision, rev); err != nil {
+		return err
 	}
 
 	for i, poll := range voter.VoteHistory {
 		if poll.PollId == pollId {
+			voter.VoteHistory = cloneHistory(voter.VoteHistory)
 			voter.VoteHistory[i] = voterHistory
+			voter.Revision++
 			v.Voters[id] = voter
 			return nil
 		}
 	}
 
-	return nil
+	return voterPollNotFound(id, pollId)
 }
 
 // PrintItem accepts a ToDoItem and prints it to the console
@@ -0,0 +1,140 @@
+// Package logging sets up the slog logger the voter API logs with.  Every
+// line is a JSON object, and a line logged with the context of a request
+// carries that request's request_id, its trace_id when it is traced and
+// its client_cert when the client sent a verified certificate
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Levels, as they are spelled in the config
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// Formats.  Text is easier on the eyes when running locally
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// The attributes the request ID, the trace and span and the client
+// certificate subject of a request are logged under
+const (
+	RequestIDKey  = "request_id"
+	TraceIDKey    = "trace_id"
+	SpanIDKey     = "span_id"
+	ClientCertKey = "client_cert"
+)
+
+// ParseLevel turns a config level into a slog.Level
+func ParseLevel(level string) (slog.Level, error) {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug, nil
+	case LevelInfo:
+		return slog.LevelInfo, nil
+	case LevelWarn:
+		return slog.LevelWarn, nil
+	case LevelError:
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", level)
+}
+
+// New is a logger writing lines of format to w, dropping those below level
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var h slog.Handler
+	switch format {
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	case FormatText:
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+// Setup makes New's logger on stderr the default.  The log package goes
+// through it from then on too, at info
+func Setup(level, format string) error {
+	l, err := New(os.Stderr, level, format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(l)
+	return nil
+}
+
+type requestIDKey struct{}
+
+// WithRequestID is ctx carrying the request ID id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID is the request ID ctx carries, empty outside a request
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+type clientSubjectKey struct{}
+
+// WithClientSubject is ctx carrying the subject of the request's verified
+// client certificate
+func WithClientSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, clientSubjectKey{}, subject)
+}
+
+// ClientSubject is the client certificate subject ctx carries, empty
+// outside a request or when the client sent no certificate
+func ClientSubject(ctx context.Context) string {
+	s, _ := ctx.Value(clientSubjectKey{}).(string)
+	return s
+}
+
+// contextHandler adds the request ID, the client certificate subject and
+// the trace of the context to each record, so the api and db code only
+// have to log with the context they were given
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String(RequestIDKey, id))
+	}
+	if s := ClientSubject(ctx); s != "" {
+		r.AddAttrs(slog.String(ClientCertKey, s))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String(TraceIDKey, sc.TraceID().String()), slog.String(SpanIDKey, sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"flag"
+	"time"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/config"
+)
+
+// healthcheck is the healthcheck subcommand.  It loads the config the way
+// the server does, from the -config file or VOTER_CONFIG and the env vars,
+// so it probes the port the server listens on, over HTTPS when the server
+// has a certificate.  -p and -tls override the config
+func healthcheck(def config.Config, args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	configFile := fs.String("config", "", "YAML or TOML config file of the server, also "+config.EnvConfig)
+	port := fs.Uint("p", 0, "Port the server listens on, 0 for the one in the config")
+	useTLS := fs.Bool("tls", false, "Probe over HTTPS even when the config has no certificate")
+	path := fs.String("path", api.ReadyzPath, "Path to probe")
+	timeout := fs.Duration("timeout", 3*time.Second, "Give up after this long")
+	certFile := fs.String("cert", "", "Client certificate for a server that wants one")
+	keyFile := fs.String("key", "", "Key of the client certificate")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var cfgArgs []string
+	if *configFile != "" {
+		cfgArgs = []string{"-config", *configFile}
+	}
+	cfg, code, ok := loadConfig(def, cfgArgs)
+	if !ok {
+		return code
+	}
+
+	probe := api.HealthcheckConfig{
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		TLS:      cfg.TLS.Enabled() || *useTLS,
+		CertFile: *certFile,
+		KeyFile:  *keyFile,
+		Path:     *path,
+		Timeout:  *timeout,
+	}
+	if *port != 0 {
+		probe.Port = *port
+	}
+	return api.Healthcheck(probe)
+}
@@ -0,0 +1,171 @@
+// Package server is the voter API program.  voter-api and Voter-Container
+// both run it, they only differ in the defaults they pass to Run
+package server
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/config"
+	"drexel.edu/todo/logging"
+	"drexel.edu/todo/tracing"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+)
+
+// loadConfig loads the config from the file, env vars and flags in args on
+// top of def.  It returns the exit code when there is nothing to run, for
+// -help or a bad config
+func loadConfig(def config.Config, args []string) (config.Config, int, bool) {
+	cfg, err := config.Load(def, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return cfg, 0, false
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid config:", err)
+		return cfg, 2, false
+	}
+	return cfg, 0, true
+}
+
+// Run runs the voter API with the config loaded on top of def and returns
+// the exit code.  args are the arguments without the program name, the
+// healthcheck and config subcommands or the flags of the server
+func Run(def config.Config, args []string) int {
+
+	//voter-api healthcheck probes a running server, see healthcheck
+	if len(args) > 0 && args[0] == "healthcheck" {
+		return healthcheck(def, args[1:])
+	}
+
+	//voter-api config prints the config the other flags add up to
+	if len(args) > 0 && args[0] == "config" {
+		cfg, code, ok := loadConfig(def, args[1:])
+		if ok {
+			fmt.Print(cfg)
+		}
+		return code
+	}
+
+	cfg, code, ok := loadConfig(def, args)
+	if !ok {
+		return code
+	}
+	//The config is validated, so the level and format are good ones
+	if err := logging.Setup(cfg.LogLevel, cfg.LogFormat); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid config:", err)
+		return 2
+	}
+	slog.Info("Effective config", "config", cfg.String())
+
+	flushTraces, err := tracing.Setup(cfg.TracingConfig())
+	if err != nil {
+		slog.Error("Error setting up tracing", "err", err)
+		return 1
+	}
+	//Spans still waiting for the next batch go out before we exit
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := flushTraces(flushCtx); err != nil {
+			slog.Error("Error flushing traces", "err", err)
+		}
+	}()
+
+	//fiber's banner would be the one line of the log that isn't JSON
+	app := fiber.New(fiber.Config{
+		ErrorHandler:          api.ErrorHandler,
+		DisableStartupMessage: true,
+		ReadTimeout:           cfg.Timeouts.Read,
+		WriteTimeout:          cfg.Timeouts.Write,
+		IdleTimeout:           cfg.Timeouts.Idle,
+	})
+	app.Use(cors.New(cors.Config{
+		AllowOrigins: strings.Join(cfg.CORS.AllowOrigins, ","),
+	}))
+	app.Use(recover.New())
+
+	//With a certificate we serve HTTPS only, there is no plain listener.
+	//Load it, and the auth keys below, before the store so a bad one
+	//can't leave the store open
+	var certs *api.CertReloader
+	if cfg.TLS.Enabled() {
+		certs, err = api.NewCertReloader(cfg.TLSConfig())
+		if err != nil {
+			slog.Error("Error loading certificates", "err", err)
+			return 1
+		}
+	}
+
+	//Without API keys or a JWKS file every route is open, fine on a
+	//laptop but not anywhere else
+	var auth *api.Authenticator
+	if cfg.Auth.Enabled() {
+		auth, err = api.NewAuthenticator(cfg.AuthConfig())
+		if err != nil {
+			slog.Error("Error setting up auth", "err", err)
+			return 1
+		}
+	} else {
+		slog.Warn("No API keys or JWKS file, authentication is off")
+	}
+
+	//A redis that is down is fine here, the limiter counts locally until
+	//it is back
+	var limiter *api.RateLimiter
+	if cfg.RateLimit.Enabled() {
+		limiter = api.NewRateLimiter(cfg.RateLimitConfig())
+	}
+
+	apiHandler, err := api.NewWithStoreConfig(cfg.StoreConfig(), api.LinkConfig{
+		VoterBaseURL: cfg.Links.BaseURL,
+		PollsBaseURL: cfg.Links.PollsURL,
+		VotesBaseURL: cfg.Links.VotesURL,
+	})
+	if err != nil {
+		slog.Error("Error opening the voter store", "err", err)
+		return 1
+	}
+
+	if auth != nil {
+		apiHandler.UseAuth(auth)
+	}
+	if limiter != nil {
+		apiHandler.UseRateLimit(limiter)
+	}
+	apiHandler.RegisterRoutes(app)
+
+	//We will now show a common way to version an API and add a new
+	//version of an API handler under /v2.  This new API will support
+	//a path parameter to search for todos based on a status
+	// v2 := app.Group("/v2")
+	// v2.Get("/todo", apiHandler.ListSelectTodos)
+
+	//SIGTERM is what docker stop sends.  Once it arrives a second signal
+	//kills us the usual way
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	serverPath := net.JoinHostPort(cfg.Host, strconv.FormatUint(uint64(cfg.Port), 10))
+	slog.Info("Starting server", "addr", serverPath)
+	if err := apiHandler.ServeTLS(ctx, app, serverPath, cfg.ShutdownConfig(), certs); err != nil {
+		slog.Error("Error running server", "err", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,96 @@
+// Package apptest runs the voter API in process for the test packages
+// under tests/.  New builds the fiber app the way main does and Do sends
+// it one request, no server needed
+package apptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Options is what a test changes about the app.  The zero value is the
+// memory store with relative links
+type Options struct {
+	Store db.StoreConfig
+	Links api.LinkConfig
+
+	//Setup runs before the routes are registered, it is where a test
+	//turns on auth or rate limits
+	Setup func(t testing.TB, vt *api.VoterAPI)
+}
+
+// New is the voter API with its routes registered on a fiber app.  The
+// store is closed when the test ends
+func New(t testing.TB, opts Options) (*fiber.App, *api.VoterAPI) {
+	t.Helper()
+	vt, err := api.NewWithStoreConfig(opts.Store, opts.Links)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { vt.Close() })
+
+	if opts.Setup != nil {
+		opts.Setup(t, vt)
+	}
+
+	app := fiber.New(fiber.Config{
+		ErrorHandler:          api.ErrorHandler,
+		DisableStartupMessage: true,
+	})
+	vt.RegisterRoutes(app)
+	return app, vt
+}
+
+// Do runs one request through app and returns the response with its body
+// read.  A string or []byte body is sent as is, anything else as JSON, and
+// headers are name, value pairs that override the JSON content type.
+//
+// Failures are reported with t.Error so Do can be used from goroutines,
+// the response is then empty
+func Do(t testing.TB, app *fiber.App, method, path string, body any, headers ...string) (*http.Response, []byte) {
+	t.Helper()
+	var rdr io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		rdr = bytes.NewReader([]byte(b))
+	case []byte:
+		rdr = bytes.NewReader(b)
+	default:
+		j, err := json.Marshal(b)
+		if err != nil {
+			t.Error(err)
+			return &http.Response{Header: http.Header{}}, nil
+		}
+		rdr = bytes.NewReader(j)
+	}
+
+	req := httptest.NewRequest(method, path, rdr)
+	if body != nil {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	rsp, err := app.Test(req, -1)
+	if err != nil {
+		t.Error(err)
+		return &http.Response{Header: http.Header{}}, nil
+	}
+	defer rsp.Body.Close()
+
+	b, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		t.Error(err)
+	}
+	return rsp, b
+}
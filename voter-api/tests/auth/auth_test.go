@@ -0,0 +1,314 @@
+package auth
+
+//The auth tests run the voter API in process with API keys and a JWKS
+//file in a temp dir.  No server needed
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/tests/apptest"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	adminKey = "admin-key-0123456789"
+	clerkKey = "clerk-key-0123456789"
+)
+
+var (
+	hmacSecret = []byte("an-hs256-secret-of-32-bytes-long")
+	rsaKey     *rsa.PrivateKey
+)
+
+func TestMain(m *testing.M) {
+	var err error
+	rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// writeJWKS puts the HS256 secret and the RSA public key in a JWKS file
+func writeJWKS(t testing.TB) string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "oct", "kid": "hs", "alg": "HS256", "k": b64(hmacSecret)},
+			{"kty": "RSA", "kid": "rs", "alg": "RS256", "use": "sig",
+				"n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		},
+	}
+	b, _ := json.Marshal(jwks)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newApp(t *testing.T) *fiber.App {
+	app, _ := apptest.New(t, apptest.Options{Setup: func(t testing.TB, vt *api.VoterAPI) {
+		auth, err := api.NewAuthenticator(api.AuthConfig{
+			APIKeys: []api.APIKey{
+				{Name: "ops", Key: adminKey, Role: api.RoleAdmin},
+				{Name: "front-desk", Key: clerkKey, Role: api.RoleClerk},
+			},
+			JWKSFile: writeJWKS(t),
+			Issuer:   "https://auth.example.com",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		vt.UseAuth(auth)
+	}})
+
+	//Two voters to work on
+	for _, id := range []uint{5, 6} {
+		code, _ := do(t, app, http.MethodPost, "/voters", db.Voter{VoterId: id, Name: "Voter", Email: "v@example.com"}, apiKey(adminKey))
+		assert.Equal(t, http.StatusCreated, code)
+	}
+	return app
+}
+
+// cred is the headers with the credentials of a request
+type cred []string
+
+func apiKey(key string) cred {
+	return cred{api.APIKeyHeader, key}
+}
+
+func bearer(token string) cred {
+	return cred{fiber.HeaderAuthorization, "Bearer " + token}
+}
+
+// token signs claims with the HS256 secret, or the RSA key for RS256
+func token(t *testing.T, method jwt.SigningMethod, claims jwt.MapClaims) string {
+	if _, ok := claims["iss"]; !ok {
+		claims["iss"] = "https://auth.example.com"
+	}
+	if _, ok := claims["exp"]; !ok {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+	}
+
+	tok := jwt.NewWithClaims(method, claims)
+	var key interface{} = hmacSecret
+	tok.Header["kid"] = "hs"
+	if method == jwt.SigningMethodRS256 {
+		key = rsaKey
+		tok.Header["kid"] = "rs"
+	}
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// do is apptest.Do with the credentials, PATCH bodies are merge patches
+func do(t *testing.T, app *fiber.App, method, path string, body interface{}, creds ...cred) (int, string) {
+	var headers []string
+	if method == http.MethodPatch {
+		headers = append(headers, fiber.HeaderContentType, db.MergePatchType)
+	}
+	for _, c := range creds {
+		headers = append(headers, c...)
+	}
+	rsp, b := apptest.Do(t, app, method, path, body, headers...)
+	return rsp.StatusCode, string(b)
+}
+
+func Test_NoCredentials(t *testing.T) {
+	app := newApp(t)
+
+	rsp, _ := apptest.Do(t, app, http.MethodDelete, "/voters", nil)
+	assert.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+	assert.Equal(t, `Bearer realm="voter-api"`, rsp.Header.Get("WWW-Authenticate"))
+
+	code, body := do(t, app, http.MethodDelete, "/voters", nil, apiKey(clerkKey), bearer(""))
+	assert.Equal(t, http.StatusForbidden, code, body)
+
+	code, _ = do(t, app, http.MethodDelete, "/voters", nil, apiKey("not-a-key-0123456789"))
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	//The probes and metrics need nothing
+	for _, path := range []string{api.LivezPath, api.ReadyzPath, api.MetricsPath, api.VoterHealthPath} {
+		code, _ := do(t, app, http.MethodGet, path, nil)
+		assert.Equal(t, http.StatusOK, code, path)
+	}
+}
+
+// Test_Roles walks each role through the routes it may and may not call
+func Test_Roles(t *testing.T) {
+	app := newApp(t)
+	voter5 := bearer(token(t, jwt.SigningMethodRS256, jwt.MapClaims{"sub": "alice", "role": api.RoleVoter, "voter_id": 5}))
+	clerk := apiKey(clerkKey)
+	admin := apiKey(adminKey)
+	poll := db.VoterHistory{PollId: 1, VoteId: 10, VoteDate: time.Now().UTC()}
+	voter := db.Voter{Name: "Renamed", Email: "r@example.com"}
+
+	for _, tc := range []struct {
+		name   string
+		cred   cred
+		method string
+		path   string
+		body   interface{}
+		want   int
+	}{
+		{"clerk lists", clerk, http.MethodGet, "/voters", nil, http.StatusOK},
+		{"clerk adds a voter", clerk, http.MethodPost, "/voters", db.Voter{Name: "New", Email: "n@example.com"}, http.StatusCreated},
+		{"clerk adds history", clerk, http.MethodPost, "/voters/5/polls", poll, http.StatusOK},
+		{"clerk reads a voter", clerk, http.MethodGet, "/voters/6", nil, http.StatusOK},
+		{"clerk can't update", clerk, http.MethodPut, "/voters/6", voter, http.StatusForbidden},
+		{"clerk can't update history", clerk, http.MethodPut, "/voters/5/polls/1", poll, http.StatusForbidden},
+		{"admin updates history", admin, http.MethodPut, "/voters/5/polls/1", poll, http.StatusOK},
+		{"clerk can't delete", clerk, http.MethodDelete, "/voters/6", nil, http.StatusForbidden},
+		{"clerk can't delete all", clerk, http.MethodDelete, "/voters", nil, http.StatusForbidden},
+
+		{"voter reads itself", voter5, http.MethodGet, "/voters/5", nil, http.StatusOK},
+		{"voter reads its history", voter5, http.MethodGet, "/voters/5/polls", nil, http.StatusOK},
+		{"voter updates itself", voter5, http.MethodPut, "/voters/5", voter, http.StatusOK},
+		{"voter patches itself", voter5, http.MethodPatch, "/voters/5", map[string]string{"name": "Patched"}, http.StatusOK},
+		{"voter can't read another", voter5, http.MethodGet, "/voters/6", nil, http.StatusForbidden},
+		{"voter can't update another", voter5, http.MethodPut, "/voters/6", voter, http.StatusForbidden},
+		{"voter can't list", voter5, http.MethodGet, "/voters", nil, http.StatusForbidden},
+		{"voter can't add history", voter5, http.MethodPost, "/voters/5/polls", poll, http.StatusForbidden},
+		{"voter can't delete itself", voter5, http.MethodDelete, "/voters/5", nil, http.StatusForbidden},
+
+		{"admin deletes", admin, http.MethodDelete, "/voters/6", nil, http.StatusOK},
+		{"admin deletes all", admin, http.MethodDelete, "/voters", nil, http.StatusOK},
+	} {
+		code, body := do(t, app, tc.method, tc.path, tc.body, tc.cred)
+		assert.Equal(t, tc.want, code, "%s: %s", tc.name, body)
+	}
+}
+
+// Test_VoterHistoryReadOnly checks a voter can edit its own name and email
+// but not its vote history, with PUT or either kind of PATCH
+func Test_VoterHistoryReadOnly(t *testing.T) {
+	app := newApp(t)
+	voter5 := bearer(token(t, jwt.SigningMethodHS256, jwt.MapClaims{"sub": "alice", "role": api.RoleVoter, "voter_id": 5}))
+	poll := db.VoterHistory{PollId: 1, VoteId: 10, VoteDate: time.Now().UTC()}
+
+	code, body := do(t, app, http.MethodPost, "/voters/5/polls", poll, apiKey(clerkKey))
+	assert.Equal(t, http.StatusOK, code, body)
+
+	history := func() []db.VoterHistory {
+		code, body := do(t, app, http.MethodGet, "/voters/5/polls", nil, voter5)
+		assert.Equal(t, http.StatusOK, code, body)
+		var polls []db.VoterHistory
+		assert.Nil(t, json.Unmarshal([]byte(body), &polls))
+		return polls
+	}
+
+	//Leaving the history out of a PUT keeps it, sending it back unchanged
+	//is fine too
+	code, body = do(t, app, http.MethodPut, "/voters/5", db.Voter{Name: "Alice", Email: "alice@example.com"}, voter5)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Len(t, history(), 1)
+	code, body = do(t, app, http.MethodPut, "/voters/5", db.Voter{Name: "Alice", Email: "alice@example.com", VoteHistory: history()}, voter5)
+	assert.Equal(t, http.StatusOK, code, body)
+
+	for _, tc := range []struct {
+		name        string
+		method      string
+		contentType string
+		body        interface{}
+	}{
+		{"put an empty history", http.MethodPut, fiber.MIMEApplicationJSON, db.Voter{Name: "Alice", Email: "alice@example.com", VoteHistory: []db.VoterHistory{}}},
+		{"put another vote", http.MethodPut, fiber.MIMEApplicationJSON, db.Voter{Name: "Alice", Email: "alice@example.com", VoteHistory: []db.VoterHistory{{PollId: 1, VoteId: 11, VoteDate: poll.VoteDate}}}},
+		{"merge patch", http.MethodPatch, db.MergePatchType, `{"vote_history":null}`},
+		{"json patch", http.MethodPatch, db.JSONPatchType, `[{"op":"remove","path":"/vote_history/0"}]`},
+	} {
+		rsp, b := apptest.Do(t, app, tc.method, "/voters/5", tc.body, append(voter5, fiber.HeaderContentType, tc.contentType)...)
+		assert.Equal(t, http.StatusUnprocessableEntity, rsp.StatusCode, "%s: %s", tc.name, b)
+		assert.Contains(t, string(b), `"field":"vote_history"`, tc.name)
+	}
+	assert.Len(t, history(), 1)
+
+	code, body = do(t, app, http.MethodPatch, "/voters/5", map[string]string{"email": "a@example.com"}, voter5)
+	assert.Equal(t, http.StatusOK, code, body)
+
+	//The admin's PUT still replaces the whole record
+	code, body = do(t, app, http.MethodPut, "/voters/5", db.Voter{Name: "Alice", Email: "alice@example.com"}, apiKey(adminKey))
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Len(t, history(), 0)
+}
+
+func Test_Tokens(t *testing.T) {
+	app := newApp(t)
+	hour := time.Hour
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"HS256 admin", token(t, jwt.SigningMethodHS256, jwt.MapClaims{"sub": "ci", "role": api.RoleAdmin}), http.StatusOK},
+		{"RS256 clerk", token(t, jwt.SigningMethodRS256, jwt.MapClaims{"sub": "desk", "role": api.RoleClerk}), http.StatusOK},
+		{"expired", token(t, jwt.SigningMethodHS256, jwt.MapClaims{"role": api.RoleAdmin, "exp": time.Now().Add(-hour).Unix()}), http.StatusUnauthorized},
+		{"no exp", token(t, jwt.SigningMethodHS256, jwt.MapClaims{"role": api.RoleAdmin, "exp": nil}), http.StatusUnauthorized},
+		{"wrong issuer", token(t, jwt.SigningMethodHS256, jwt.MapClaims{"role": api.RoleAdmin, "iss": "https://evil.example.com"}), http.StatusUnauthorized},
+		{"unknown role", token(t, jwt.SigningMethodHS256, jwt.MapClaims{"role": "root"}), http.StatusUnauthorized},
+		{"voter without id", token(t, jwt.SigningMethodHS256, jwt.MapClaims{"role": api.RoleVoter}), http.StatusUnauthorized},
+		{"garbage", "not.a.token", http.StatusUnauthorized},
+	} {
+		code, body := do(t, app, http.MethodGet, "/voters", nil, bearer(tc.token))
+		assert.Equal(t, tc.want, code, "%s: %s", tc.name, body)
+	}
+
+	//Signed with a secret we don't know
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"role": api.RoleAdmin, "iss": "https://auth.example.com", "exp": time.Now().Add(hour).Unix()})
+	s, _ := forged.SignedString([]byte("some-other-secret-of-32-bytes-ok"))
+	code, _ := do(t, app, http.MethodGet, "/voters", nil, bearer(s))
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	//alg none is never accepted
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"role": api.RoleAdmin, "iss": "https://auth.example.com", "exp": time.Now().Add(hour).Unix()})
+	s, _ = none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	code, _ = do(t, app, http.MethodGet, "/voters", nil, bearer(s))
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+// Test_AuthOff checks nothing changes without UseAuth, votes-api calls us
+// with no credentials
+func Test_AuthOff(t *testing.T) {
+	app, _ := apptest.New(t, apptest.Options{})
+
+	code, _ := do(t, app, http.MethodPost, "/voters", db.Voter{VoterId: 5, Name: "Voter", Email: "v@example.com"})
+	assert.Equal(t, http.StatusCreated, code)
+	code, _ = do(t, app, http.MethodGet, "/voters/5", nil)
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = do(t, app, http.MethodDelete, "/voters", nil)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func Test_BadConfig(t *testing.T) {
+	_, err := api.NewAuthenticator(api.AuthConfig{APIKeys: []api.APIKey{{Name: "x", Key: adminKey, Role: api.RoleVoter}}})
+	assert.NotNil(t, err, "API keys can't have the voter role")
+
+	_, err = api.NewAuthenticator(api.AuthConfig{APIKeys: []api.APIKey{{Name: "x", Key: "short", Role: api.RoleAdmin}}})
+	assert.NotNil(t, err)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(path, []byte(`{"keys":[{"kty":"oct","k":"c2hvcnQ"}]}`), 0o600)
+	_, err = api.NewAuthenticator(api.AuthConfig{JWKSFile: path})
+	assert.NotNil(t, err, "HS256 secret too short")
+
+	os.WriteFile(path, []byte(`{"keys":[{"kty":"EC","crv":"P-256"}]}`), 0o600)
+	_, err = api.NewAuthenticator(api.AuthConfig{JWKSFile: path})
+	assert.NotNil(t, err, "no usable keys")
+}
@@ -0,0 +1,311 @@
+package config
+
+//The config tests load configs from temp files, env vars and flags.  No
+//server needed
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/config"
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFile puts a config file in a temp dir and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_Defaults(t *testing.T) {
+	cfg, err := config.Load(config.Default(), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, config.Default(), cfg)
+	assert.Equal(t, uint(1080), cfg.Port)
+	assert.Equal(t, db.StoreMemory, cfg.Store)
+}
+
+// Test_Precedence sets the port in all three places, and one setting in
+// each of the file and env only, the flag has to win
+func Test_Precedence(t *testing.T) {
+	path := writeFile(t, "voter.yaml", `
+port: 2000
+store: file
+redis:
+  db: 4
+`)
+	t.Setenv("VOTER_PORT", "3000")
+	t.Setenv("VOTER_DRAIN_TIMEOUT", "30s")
+	t.Setenv("VOTER_PRE_STOP", "2s")
+
+	cfg, err := config.Load(config.Default(), []string{"-config", path, "-p", "4000"})
+	assert.Nil(t, err)
+	assert.Equal(t, uint(4000), cfg.Port)
+	assert.Equal(t, db.StoreFile, cfg.Store)
+	assert.Equal(t, 4, cfg.Redis.DB)
+	assert.Equal(t, 30*time.Second, cfg.Timeouts.Drain)
+	assert.Equal(t, 2*time.Second, cfg.Timeouts.PreStop)
+
+	//Without the flag the env var beats the file
+	cfg, err = config.Load(config.Default(), []string{"-config", path})
+	assert.Nil(t, err)
+	assert.Equal(t, uint(3000), cfg.Port)
+}
+
+func Test_TOMLFromEnv(t *testing.T) {
+	path := writeFile(t, "voter.toml", `
+store = "redis"
+
+[timeouts]
+read = "5s"
+
+[cors]
+allow_origins = ["https://a.example.com", "https://b.example.com"]
+
+[redis]
+addr = "cache:6379"
+key_prefix = "test:"
+`)
+	t.Setenv("VOTER_CONFIG", path)
+
+	cfg, err := config.Load(config.Default(), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, db.StoreRedis, cfg.Store)
+	assert.Equal(t, 5*time.Second, cfg.Timeouts.Read)
+	assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, cfg.CORS.AllowOrigins)
+	assert.Equal(t, "cache:6379", cfg.StoreConfig().Redis.Addr)
+	assert.Equal(t, "test:", cfg.StoreConfig().Redis.KeyPrefix)
+}
+
+func Test_UnknownKey(t *testing.T) {
+	for _, f := range []struct{ name, content string }{
+		{"voter.yaml", "prot: 2000\n"},
+		{"voter.toml", "prot = 2000\n"},
+	} {
+		_, err := config.Load(config.Default(), []string{"-config", writeFile(t, f.name, f.content)})
+		if assert.NotNil(t, err, f.name) {
+			assert.Contains(t, err.Error(), "prot", f.name)
+		}
+	}
+
+	_, err := config.Load(config.Default(), []string{"-config", writeFile(t, "voter.json", "{}")})
+	assert.NotNil(t, err)
+}
+
+// Test_Validate checks every problem is reported, not just the first
+func Test_Validate(t *testing.T) {
+	t.Setenv("REDIS_URL", "no-port")
+	_, err := config.Load(config.Default(), []string{
+		"-p", "70000",
+		"-store", "redis",
+		"-log-level", "loud",
+		"-log-format", "xml",
+		"-drain", "-1s",
+		"-pre-stop", "-1s",
+		"-redis-op-timeout", "-1s",
+		"-cors-origins", "example.com",
+		"-baseurl", "localhost:1080",
+	})
+	if assert.NotNil(t, err) {
+		for _, want := range []string{"port", "log_level", "log_format", "timeouts.drain", "timeouts.pre_stop", "redis.op_timeout", "cors.allow_origins", "links.base_url", "redis.addr"} {
+			assert.Contains(t, err.Error(), want)
+		}
+	}
+
+	//The file store settings don't matter with the memory store
+	_, err = config.Load(config.Default(), []string{"-snapshot-every", "0"})
+	assert.Nil(t, err)
+	_, err = config.Load(config.Default(), []string{"-store", "file", "-snapshot-every", "0"})
+	assert.NotNil(t, err)
+	_, err = config.Load(config.Default(), []string{"-store", "file", "-snapshot-interval", "-1s"})
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "file.snapshot_interval")
+	}
+}
+
+func Test_ValidateTLS(t *testing.T) {
+	for _, args := range [][]string{
+		{"-tls-cert", "server.pem"},
+		{"-tls-key", "server.key"},
+		{"-tls-cert", "server.pem", "-tls-key", "server.key", "-tls-client-auth", "require"},
+		{"-tls-cert", "server.pem", "-tls-key", "server.key", "-tls-client-ca", "ca.pem"},
+		{"-tls-client-auth", "optional", "-tls-client-ca", "ca.pem"},
+		{"-tls-client-auth", "always"},
+	} {
+		_, err := config.Load(config.Default(), args)
+		if assert.NotNil(t, err, "%v", args) {
+			assert.Contains(t, err.Error(), "tls.", "%v", args)
+		}
+	}
+
+	cfg, err := config.Load(config.Default(), []string{"-tls-cert", "server.pem", "-tls-key", "server.key", "-tls-client-ca", "ca.pem", "-tls-client-auth", "require"})
+	assert.Nil(t, err)
+	assert.True(t, cfg.TLS.Enabled())
+	assert.Equal(t, "ca.pem", cfg.TLSConfig().ClientCAFile)
+	assert.False(t, config.Default().TLS.Enabled())
+}
+
+func Test_ValidateAuth(t *testing.T) {
+	for _, args := range [][]string{
+		{"-api-keys", "ops:root:0123456789abcdef"},
+		{"-api-keys", "ops:admin:short"},
+		{"-api-keys", "ops:admin:0123456789abcdef,ops:clerk:fedcba9876543210"},
+		{"-jwt-issuer", "https://auth.example.com"},
+		{"-client-certs", "clerk=CN=polls-api,O=Voting"},
+		{"-client-certs", "root=CN=polls-api,O=Voting", "-tls-cert", "server.pem", "-tls-key", "server.key", "-tls-client-ca", "ca.pem", "-tls-client-auth", "optional"},
+	} {
+		_, err := config.Load(config.Default(), args)
+		if assert.NotNil(t, err, "%v", args) {
+			assert.Contains(t, err.Error(), "auth.", "%v", args)
+		}
+	}
+
+	_, err := config.Load(config.Default(), []string{"-api-keys", "no-role-or-key"})
+	assert.NotNil(t, err)
+
+	t.Setenv("VOTER_API_KEYS", "ops:admin:0123456789abcdef,desk:clerk:fedcba9876543210")
+	cfg, err := config.Load(config.Default(), nil)
+	assert.Nil(t, err)
+	assert.True(t, cfg.Auth.Enabled())
+	assert.Equal(t, "desk", cfg.AuthConfig().APIKeys[1].Name)
+	assert.Equal(t, "clerk", cfg.AuthConfig().APIKeys[1].Role)
+	assert.NotContains(t, cfg.String(), "0123456789abcdef")
+	assert.False(t, config.Default().Auth.Enabled())
+
+	//Subjects have commas, so the entries are split on ;
+	t.Setenv("VOTER_API_KEYS", "")
+	t.Setenv("VOTER_CLIENT_CERTS", "clerk=CN=polls-api,O=Voting;admin=ops.voting.test")
+	cfg, err = config.Load(config.Default(), []string{"-tls-cert", "server.pem", "-tls-key", "server.key", "-tls-client-ca", "ca.pem", "-tls-client-auth", "require"})
+	assert.Nil(t, err)
+	assert.True(t, cfg.Auth.Enabled())
+	assert.Equal(t, []api.CertRole{
+		{Subject: "CN=polls-api,O=Voting", Role: "clerk"},
+		{Subject: "ops.voting.test", Role: "admin"},
+	}, cfg.AuthConfig().ClientCerts)
+}
+
+func Test_RateLimit(t *testing.T) {
+	path := writeFile(t, "voter.toml", `
+[rate_limit]
+store = "redis"
+limit = 50
+
+[rate_limit.routes."POST /voters/:id/polls"]
+limit = 5
+window = "10s"
+`)
+	cfg, err := config.Load(config.Default(), []string{"-config", path, "-rate-limit-ip-header", "X-Forwarded-For", "-rate-limit-trusted-proxies", "10.0.0.0/8, 192.0.2.1"})
+	assert.Nil(t, err)
+	assert.True(t, cfg.RateLimit.Enabled())
+	rl := cfg.RateLimitConfig()
+	assert.Equal(t, 50, rl.Default.Limit)
+	assert.Equal(t, time.Minute, rl.Default.Window)
+	assert.Equal(t, 5, rl.Routes["POST /voters/:id/polls"].Limit)
+	assert.Equal(t, "X-Forwarded-For", rl.IPHeader)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.0.2.1"}, rl.TrustedProxies)
+	assert.Equal(t, 300, rl.PerIP.Limit)
+	assert.Equal(t, db.RedisDefaultLocation, rl.Redis.Addr)
+
+	//The env var replaces the routes of the file
+	t.Setenv("VOTER_RATE_LIMIT_ROUTES", "GET /voters=60/1m, DELETE /voters=1/1h")
+	cfg, err = config.Load(config.Default(), []string{"-config", path})
+	assert.Nil(t, err)
+	assert.Len(t, cfg.RateLimit.Routes, 2)
+	assert.Equal(t, time.Hour, cfg.RateLimit.Routes["DELETE /voters"].Window)
+
+	for _, args := range [][]string{
+		{"-rate-limit-store", "disk"},
+		{"-rate-limit", "-1"},
+		{"-rate-limit-window", "0s"},
+		{"-rate-limit-routes", "/voters=1/1m"},
+		{"-rate-limit-store", "redis", "-redis-addr", "no-port"},
+		{"-rate-limit-per-ip", "-1"},
+		{"-rate-limit-per-ip-window", "0s"},
+		{"-rate-limit-ip-header", "X-Forwarded-For"},
+		{"-rate-limit-ip-header", "X-Forwarded-For", "-rate-limit-trusted-proxies", "lb.example.com"},
+	} {
+		_, err := config.Load(config.Default(), args)
+		if assert.NotNil(t, err, "%v", args) {
+			assert.Contains(t, err.Error(), "rate_limit", "%v", args)
+		}
+	}
+
+	_, err = config.Load(config.Default(), []string{"-rate-limit-routes", "GET /voters=lots/1m"})
+	assert.NotNil(t, err)
+	assert.False(t, config.Default().RateLimit.Enabled())
+}
+
+func Test_Tracing(t *testing.T) {
+	path := writeFile(t, "voter.yaml", `
+tracing:
+  exporter: otlp
+  endpoint: http://collector:4318/v1/traces
+`)
+	t.Setenv("VOTER_TRACE_SAMPLE_RATIO", "0.25")
+	cfg, err := config.Load(config.Default(), []string{"-config", path})
+	assert.Nil(t, err)
+	tc := cfg.TracingConfig()
+	assert.Equal(t, tracing.ExporterOTLP, tc.Exporter)
+	assert.Equal(t, "http://collector:4318/v1/traces", tc.Endpoint)
+	assert.Equal(t, "voter-api", tc.ServiceName)
+	assert.Equal(t, 0.25, tc.SampleRatio)
+
+	for _, args := range [][]string{
+		{"-trace-exporter", "jaeger"},
+		{"-trace-exporter", "otlp", "-trace-endpoint", "collector:4318"},
+		{"-trace-service-name", ""},
+		{"-trace-sample-ratio", "1.5"},
+	} {
+		_, err := config.Load(config.Default(), args)
+		if assert.NotNil(t, err, "%v", args) {
+			assert.Contains(t, err.Error(), "tracing", "%v", args)
+		}
+	}
+
+	//The endpoint only matters when there is something to send
+	_, err = config.Load(config.Default(), []string{"-trace-exporter", "stdout", "-trace-endpoint", "collector:4318"})
+	assert.Nil(t, err)
+}
+
+func Test_BadInput(t *testing.T) {
+	t.Setenv("REDIS_DB", "two")
+	_, err := config.Load(config.Default(), nil)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "REDIS_DB")
+	}
+
+	_, err = config.Load(config.Default(), []string{"-help"})
+	assert.True(t, errors.Is(err, flag.ErrHelp))
+}
+
+func Test_SecretsMasked(t *testing.T) {
+	t.Setenv("REDIS_PASSWORD", "s3cret")
+	cfg, err := config.Load(config.Default(), nil)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "s3cret", cfg.StoreConfig().Redis.Password)
+	assert.NotContains(t, cfg.String(), "s3cret")
+	assert.Contains(t, cfg.String(), "password: '********'")
+
+	//An unset password prints as unset
+	assert.Contains(t, config.Default().String(), `password: ""`)
+
+	//The printed config loads back as the same config, bar the password
+	cfg.Redis.Password = ""
+	path := writeFile(t, "printed.yaml", strings.Replace(cfg.String(), "'********'", `""`, 1))
+	t.Setenv("REDIS_PASSWORD", "")
+	loaded, err := config.Load(config.Default(), []string{"-config", path})
+	assert.Nil(t, err)
+	assert.Equal(t, cfg, loaded)
+}
@@ -0,0 +1,147 @@
+package deadline
+
+//The deadline tests put a proxy that can stop answering between the redis
+//store and miniredis, to play a redis that hangs.  No server or redis
+//needed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/tests/apptest"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// stallProxy forwards to redis until stalled, then swallows every command
+// so the client waits for an answer that never comes
+type stallProxy struct {
+	ln      net.Listener
+	stalled atomic.Bool
+}
+
+func newStallProxy(t *testing.T, target string) *stallProxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &stallProxy{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn, target)
+		}
+	}()
+	return p
+}
+
+func (p *stallProxy) serve(conn net.Conn, target string) {
+	defer conn.Close()
+	up, err := net.Dial("tcp", target)
+	if err != nil {
+		return
+	}
+	defer up.Close()
+	go io.Copy(conn, up)
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		if p.stalled.Load() {
+			continue
+		}
+		if _, err := up.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+func (p *stallProxy) addr() string {
+	return p.ln.Addr().String()
+}
+
+func newStore(t *testing.T, opTimeout time.Duration) (*db.VoterCache, *stallProxy) {
+	mr := miniredis.RunT(t)
+	proxy := newStallProxy(t, mr.Addr())
+	store, err := db.NewWithRedisConfig(db.RedisConfig{Addr: proxy.addr(), OpTimeout: opTimeout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, proxy
+}
+
+// Test_OpTimeout checks a hanging redis gets the client a 504 after the
+// op timeout, rather than tying up the request until redis comes back
+func Test_OpTimeout(t *testing.T) {
+	mr := miniredis.RunT(t)
+	proxy := newStallProxy(t, mr.Addr())
+	app, _ := apptest.New(t, apptest.Options{Store: db.StoreConfig{
+		Type:  db.StoreRedis,
+		Redis: db.RedisConfig{Addr: proxy.addr(), OpTimeout: 200 * time.Millisecond},
+	}})
+
+	proxy.stalled.Store(true)
+	start := time.Now()
+	rsp, body := apptest.Do(t, app, http.MethodGet, "/voters/1", nil)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	assert.Equal(t, http.StatusGatewayTimeout, rsp.StatusCode)
+	var p api.Problem
+	assert.Nil(t, json.Unmarshal(body, &p))
+	assert.Equal(t, "backend_timeout", p.Code)
+	assert.Equal(t, "voter store did not answer in time", p.Detail)
+}
+
+// Test_CallerDeadline checks the store gives up when the caller's context
+// does, even with no op timeout of its own
+func Test_CallerDeadline(t *testing.T) {
+	store, proxy := newStore(t, 0)
+	proxy.stalled.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := store.GetVoter(ctx, 1)
+	assert.True(t, errors.Is(err, db.ErrTimeout), "got %v", err)
+	assert.Less(t, time.Since(start), time.Second)
+
+	//A caller that is already gone is not a timeout, and redis is not
+	//asked at all.  go-redis only watches the deadline, not cancel, once a
+	//command is sent, that is what the op timeout is for
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = store.GetAllVoters(ctx)
+	assert.True(t, errors.Is(err, db.ErrUnavailable), "got %v", err)
+	assert.True(t, errors.Is(err, context.Canceled), "got %v", err)
+}
+
+// Test_OpTimeoutIsPerOperation checks the op timeout is counted from the
+// start of each call, not from when the store was opened
+func Test_OpTimeoutIsPerOperation(t *testing.T) {
+	store, proxy := newStore(t, 300*time.Millisecond)
+
+	time.Sleep(400 * time.Millisecond)
+	assert.Nil(t, store.Ping(context.Background()))
+
+	proxy.stalled.Store(true)
+	err := store.Ping(context.Background())
+	assert.True(t, errors.Is(err, db.ErrTimeout), "got %v", err)
+}
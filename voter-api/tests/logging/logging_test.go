@@ -0,0 +1,155 @@
+package logging
+
+//The logging tests run the voter API in process with the default logger
+//writing to a buffer, and read back the JSON lines.  No server needed
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/logging"
+	"drexel.edu/todo/tests/apptest"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// capture makes a logger at level writing to the returned buffer the
+// default for the rest of the test
+func capture(t *testing.T, level string) *bytes.Buffer {
+	var buf bytes.Buffer
+	l, err := logging.New(&buf, level, logging.FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := slog.Default()
+	slog.SetDefault(l)
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return &buf
+}
+
+// lines is every line logged to buf, each one has to be a JSON object
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var out []map[string]any
+	sc := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	for sc.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &line); err != nil {
+			t.Fatalf("not a JSON line: %s", sc.Text())
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+// find is the lines with message msg
+func find(all []map[string]any, msg string) []map[string]any {
+	var out []map[string]any
+	for _, l := range all {
+		if l["msg"] == msg {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+func newApp(t *testing.T, store db.StoreConfig) *fiber.App {
+	app, _ := apptest.New(t, apptest.Options{Store: store})
+	return app
+}
+
+var hexID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func Test_RequestID(t *testing.T) {
+	capture(t, logging.LevelInfo)
+	app := newApp(t, db.StoreConfig{Type: db.StoreMemory})
+
+	rsp, _ := apptest.Do(t, app, http.MethodGet, "/voters", nil)
+	assert.Regexp(t, hexID, rsp.Header.Get(api.RequestIDHeader))
+
+	//A sane ID from the caller is kept, anything else is replaced
+	rsp, _ = apptest.Do(t, app, http.MethodGet, "/voters", nil, api.RequestIDHeader, "trace-42.a:b_c")
+	assert.Equal(t, "trace-42.a:b_c", rsp.Header.Get(api.RequestIDHeader))
+
+	for _, bad := range []string{"has space", "quote\"", strings.Repeat("a", 129)} {
+		rsp, _ = apptest.Do(t, app, http.MethodGet, "/voters", nil, api.RequestIDHeader, bad)
+		assert.Regexp(t, hexID, rsp.Header.Get(api.RequestIDHeader), bad)
+	}
+}
+
+func Test_AccessLog(t *testing.T) {
+	buf := capture(t, logging.LevelInfo)
+	app := newApp(t, db.StoreConfig{Type: db.StoreMemory})
+
+	apptest.Do(t, app, http.MethodGet, "/voters/7", nil, api.RequestIDHeader, "req-1")
+	apptest.Do(t, app, http.MethodGet, "/nowhere", nil, api.RequestIDHeader, "req-2")
+	apptest.Do(t, app, http.MethodGet, api.LivezPath, nil)
+
+	reqs := find(lines(t, buf), "request")
+	if !assert.Len(t, reqs, 2, "probes are logged at debug") {
+		return
+	}
+
+	l := reqs[0]
+	assert.Equal(t, "INFO", l["level"])
+	assert.Equal(t, "req-1", l[logging.RequestIDKey])
+	assert.Equal(t, http.MethodGet, l["method"])
+	assert.Equal(t, api.VoterPath, l["route"])
+	assert.Equal(t, "/voters/7", l["path"])
+	assert.Equal(t, float64(http.StatusNotFound), l["status"])
+	assert.Contains(t, l, "latency_ms")
+	assert.Contains(t, l, "bytes")
+	assert.Contains(t, l, "ip")
+
+	//An unmatched route is logged as one route, not per path
+	assert.Equal(t, "req-2", reqs[1][logging.RequestIDKey])
+	assert.Equal(t, "unmatched", reqs[1]["route"])
+	assert.Equal(t, "/nowhere", reqs[1]["path"])
+}
+
+// Test_StoreLogs checks the request ID makes it down into the store
+func Test_StoreLogs(t *testing.T) {
+	buf := capture(t, logging.LevelDebug)
+	app := newApp(t, db.StoreConfig{Type: db.StoreFile, File: db.FileConfig{Dir: t.TempDir(), SnapshotEvery: 100}})
+
+	rsp, _ := apptest.Do(t, app, http.MethodPost, "/voters", `{"name":"Ann","email":"ann@example.com"}`, api.RequestIDHeader, "req-store")
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode)
+
+	all := lines(t, buf)
+	appended := find(all, "Appended log record")
+	if assert.Len(t, appended, 1) {
+		assert.Equal(t, "req-store", appended[0][logging.RequestIDKey])
+		assert.Equal(t, "DEBUG", appended[0]["level"])
+	}
+	//Lines outside a request have no request ID
+	for _, l := range find(all, "Loaded voters") {
+		assert.NotContains(t, l, logging.RequestIDKey)
+	}
+}
+
+func Test_Levels(t *testing.T) {
+	buf := capture(t, logging.LevelWarn)
+	app := newApp(t, db.StoreConfig{Type: db.StoreMemory})
+
+	apptest.Do(t, app, http.MethodGet, "/voters", nil)
+	assert.Empty(t, find(lines(t, buf), "request"))
+
+	buf = capture(t, logging.LevelDebug)
+	apptest.Do(t, app, http.MethodGet, api.LivezPath, nil)
+	probes := find(lines(t, buf), "request")
+	if assert.Len(t, probes, 1) {
+		assert.Equal(t, "DEBUG", probes[0]["level"])
+	}
+
+	_, err := logging.New(buf, "verbose", logging.FormatJSON)
+	assert.NotNil(t, err)
+	_, err = logging.New(buf, logging.LevelInfo, "xml")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,380 @@
+package mtls
+
+//The TLS tests make a CA and certificates in a temp dir, and run the voter
+//API over HTTPS on a real port.  No server needed
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/logging"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// whoamiPath answers the client certificate subject the API saw
+const whoamiPath = "/whoami"
+
+type keyPair struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+var serial int64
+
+// issue makes a certificate for cn signed by parent, or self signed when
+// parent is nil
+func issue(t *testing.T, cn string, parent *keyPair, usage x509.ExtKeyUsage) keyPair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"Voting"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer := keyPair{tmpl, key}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		tmpl.ExtKeyUsage = nil
+	} else {
+		signer = *parent
+		tmpl.DNSNames = []string{cn + ".voting.test"}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer.cert, &key.PublicKey, signer.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyPair{cert, key}
+}
+
+// write puts the certificate and key in dir as <name>.pem and <name>.key
+func (kp keyPair) write(t *testing.T, dir, name string) (string, string) {
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+".key")
+
+	der, err := x509.MarshalECPrivateKey(kp.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: kp.cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (kp keyPair) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{kp.cert.Raw}, PrivateKey: kp.key}
+}
+
+// serve runs the API over HTTPS with cfg until the test ends and returns
+// its base url
+func serve(t *testing.T, cfg api.TLSConfig) string {
+	return serveAuth(t, cfg, nil)
+}
+
+// serveAuth is serve with auth on when authCfg isn't nil
+func serveAuth(t *testing.T, cfg api.TLSConfig, authCfg *api.AuthConfig) string {
+	vt, err := api.New(db.StoreMemory, api.LinkConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authCfg != nil {
+		a, err := api.NewAuthenticator(*authCfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vt.UseAuth(a)
+	}
+	certs, err := api.NewCertReloader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New(fiber.Config{
+		ErrorHandler:          api.ErrorHandler,
+		DisableStartupMessage: true,
+	})
+	app.Get(whoamiPath, func(c *fiber.Ctx) error {
+		return c.SendString(api.ClientSubject(c))
+	})
+	vt.RegisterRoutes(app)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- vt.ServeTLS(ctx, app, addr, api.ShutdownConfig{Drain: time.Second}, certs)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-served
+	})
+
+	//Wait until something listens, the handshake itself may fail
+	for i := 0; i < 100; i++ {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return "https://" + addr
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("server did not start")
+	return ""
+}
+
+// client trusts ca and presents cert, if there is one, even when the
+// server did not ask for its CA.  No keep-alives, so every request does a
+// new handshake
+func client(ca keyPair, cert *keyPair) *http.Client {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	cfg := &tls.Config{RootCAs: pool}
+	if cert != nil {
+		c := cert.tlsCert()
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &c, nil
+		}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, DisableKeepAlives: true}}
+}
+
+func get(cli *http.Client, url string) (string, error) {
+	rsp, err := cli.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer rsp.Body.Close()
+	b, err := io.ReadAll(rsp.Body)
+	return string(b), err
+}
+
+func Test_HTTPS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "Test CA", nil, 0)
+	certFile, keyFile := issue(t, "voter-api", &ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+
+	base := serve(t, api.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientAuth: api.ClientAuthNone})
+
+	rsp, err := client(ca, nil).Get(base + api.LivezPath)
+	if assert.Nil(t, err) {
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+		rsp.Body.Close()
+	}
+
+	//No client certificate asked for, so there is no subject
+	subject, err := get(client(ca, nil), base+whoamiPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "", subject)
+
+	//Plain HTTP gets nowhere
+	plain := "http" + base[len("https"):]
+	rsp, err = http.Get(plain + api.LivezPath)
+	if err == nil {
+		assert.NotEqual(t, http.StatusOK, rsp.StatusCode)
+		rsp.Body.Close()
+	}
+}
+
+func Test_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "Test CA", nil, 0)
+	certFile, keyFile := issue(t, "voter-api", &ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+	caFile, _ := ca.write(t, dir, "ca")
+	polls := issue(t, "polls-api", &ca, x509.ExtKeyUsageClientAuth)
+
+	//A client certificate from a CA we don't trust
+	otherCA := issue(t, "Other CA", nil, 0)
+	stranger := issue(t, "stranger", &otherCA, x509.ExtKeyUsageClientAuth)
+
+	t.Run("require", func(t *testing.T) {
+		base := serve(t, api.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientAuth: api.ClientAuthRequire})
+
+		subject, err := get(client(ca, &polls), base+whoamiPath)
+		assert.Nil(t, err)
+		assert.Equal(t, "CN=polls-api,O=Voting", subject)
+
+		_, err = get(client(ca, nil), base+whoamiPath)
+		assert.NotNil(t, err, "no client certificate")
+		_, err = get(client(ca, &stranger), base+whoamiPath)
+		assert.NotNil(t, err, "client certificate from another CA")
+	})
+
+	t.Run("optional", func(t *testing.T) {
+		base := serve(t, api.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientAuth: api.ClientAuthOptional})
+
+		subject, err := get(client(ca, &polls), base+whoamiPath)
+		assert.Nil(t, err)
+		assert.Equal(t, "CN=polls-api,O=Voting", subject)
+
+		subject, err = get(client(ca, nil), base+whoamiPath)
+		assert.Nil(t, err)
+		assert.Equal(t, "", subject)
+
+		//A certificate that is sent still has to verify
+		_, err = get(client(ca, &stranger), base+whoamiPath)
+		assert.NotNil(t, err)
+	})
+}
+
+// syncBuffer is a log sink the server goroutines can write to while the
+// test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// Test_ClientCertRoles checks a verified client certificate listed in
+// auth.client_certs, by subject or by DNS name, gets through to a role
+// protected route with no other credentials, and that its subject is in
+// the access log
+func Test_ClientCertRoles(t *testing.T) {
+	var logs syncBuffer
+	l, err := logging.New(&logs, logging.LevelInfo, logging.FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := slog.Default()
+	slog.SetDefault(l)
+	t.Cleanup(func() { slog.SetDefault(old) })
+
+	dir := t.TempDir()
+	ca := issue(t, "Test CA", nil, 0)
+	certFile, keyFile := issue(t, "voter-api", &ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+	caFile, _ := ca.write(t, dir, "ca")
+	polls := issue(t, "polls-api", &ca, x509.ExtKeyUsageClientAuth)
+	ops := issue(t, "ops", &ca, x509.ExtKeyUsageClientAuth)
+	visitor := issue(t, "visitor", &ca, x509.ExtKeyUsageClientAuth)
+
+	base := serveAuth(t,
+		api.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientAuth: api.ClientAuthOptional},
+		&api.AuthConfig{ClientCerts: []api.CertRole{
+			{Subject: "CN=polls-api,O=Voting", Role: api.RoleClerk},
+			{Subject: "ops.voting.test", Role: api.RoleAdmin},
+		}})
+
+	status := func(cert *keyPair, method, path string) int {
+		req, _ := http.NewRequest(method, base+path, nil)
+		rsp, err := client(ca, cert).Do(req)
+		if !assert.Nil(t, err) {
+			return 0
+		}
+		rsp.Body.Close()
+		return rsp.StatusCode
+	}
+
+	//The clerk by subject may list but not delete everyone
+	assert.Equal(t, http.StatusOK, status(&polls, http.MethodGet, api.VotersPath))
+	assert.Equal(t, http.StatusForbidden, status(&polls, http.MethodDelete, api.VotersPath))
+
+	//The admin by DNS name may
+	assert.Equal(t, http.StatusOK, status(&ops, http.MethodDelete, api.VotersPath))
+
+	//A verified certificate without a role, or none at all, still needs
+	//a key or a token
+	assert.Equal(t, http.StatusUnauthorized, status(&visitor, http.MethodGet, api.VotersPath))
+	assert.Equal(t, http.StatusUnauthorized, status(nil, http.MethodGet, api.VotersPath))
+
+	assert.Contains(t, logs.String(), `"client_cert":"CN=polls-api,O=Voting"`)
+	assert.Contains(t, logs.String(), `"client_cert":"CN=visitor,O=Voting"`)
+}
+
+// serverCN is the common name of the certificate the server presents
+func serverCN(t *testing.T, ca keyPair, base string) string {
+	rsp, err := client(ca, nil).Get(base + api.LivezPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	return rsp.TLS.PeerCertificates[0].Subject.CommonName
+}
+
+func Test_Reload(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "Test CA", nil, 0)
+	certFile, keyFile := issue(t, "voter-api-1", &ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+
+	base := serve(t, api.TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientAuth: api.ClientAuthNone, ReloadInterval: 20 * time.Millisecond})
+	assert.Equal(t, "voter-api-1", serverCN(t, ca, base))
+
+	//Rotate the certificate, new connections get it without a restart.
+	//The mtime has to move for the change to be seen
+	issue(t, "voter-api-2", &ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+	later := time.Now().Add(time.Second)
+	os.Chtimes(certFile, later, later)
+	assert.Eventually(t, func() bool {
+		return serverCN(t, ca, base) == "voter-api-2"
+	}, 2*time.Second, 20*time.Millisecond)
+
+	//A broken certificate is not picked up, the last good one stays
+	os.WriteFile(certFile, []byte("not a certificate"), 0o600)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "voter-api-2", serverCN(t, ca, base))
+}
+
+func Test_BadCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := issue(t, "Test CA", nil, 0)
+	certFile, _ := issue(t, "voter-api", &ca, x509.ExtKeyUsageServerAuth).write(t, dir, "server")
+	_, otherKey := issue(t, "other", &ca, x509.ExtKeyUsageServerAuth).write(t, dir, "other")
+
+	_, err := api.NewCertReloader(api.TLSConfig{CertFile: certFile, KeyFile: otherKey})
+	assert.NotNil(t, err, "key does not match the certificate")
+
+	_, err = api.NewCertReloader(api.TLSConfig{CertFile: certFile, KeyFile: filepath.Join(dir, "missing.key")})
+	assert.NotNil(t, err)
+}
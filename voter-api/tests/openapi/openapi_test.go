@@ -0,0 +1,189 @@
+package openapi
+
+//The openapi tests hold the spec served at /openapi.json against the
+//routes fiber has registered, in process, no server needed
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/tests/apptest"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newApp(t *testing.T, links api.LinkConfig) *fiber.App {
+	app, _ := apptest.New(t, apptest.Options{Links: links})
+	return app
+}
+
+// spec fetches /openapi.json as plain JSON
+func spec(t *testing.T, app *fiber.App) map[string]any {
+	rsp, body := apptest.Do(t, app, http.MethodGet, api.OpenAPIPath, nil)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	var doc map[string]any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// at follows a path of object keys down doc
+func at(doc any, keys ...string) map[string]any {
+	for _, k := range keys {
+		m, _ := doc.(map[string]any)
+		doc = m[k]
+	}
+	m, _ := doc.(map[string]any)
+	return m
+}
+
+var routeParam = regexp.MustCompile(`:([a-z]+)(<[a-z]+>)?`)
+
+// specPath is the OpenAPI form of a fiber route, /voters/:id<int> is
+// /voters/{id}
+func specPath(route string) string {
+	return routeParam.ReplaceAllString(route, "{$1}")
+}
+
+// Test_RoutesInSpec fails for any route fiber serves that the spec does not
+// describe.  Add the route to operations in api/openapi.go
+func Test_RoutesInSpec(t *testing.T) {
+	app := newApp(t, api.LinkConfig{})
+	paths := at(spec(t, app), "paths")
+
+	served := 0
+	for _, r := range app.GetRoutes(true) {
+		//fiber adds a HEAD for every GET, OpenAPI takes that as given
+		if r.Method == http.MethodHead {
+			continue
+		}
+		served++
+		op := at(paths, specPath(r.Path), strings.ToLower(r.Method))
+		assert.NotNil(t, op, "%s %s is not in the spec", r.Method, r.Path)
+	}
+	assert.Greater(t, served, 0)
+}
+
+// Test_SpecInRoutes is the other way round, every operation of the spec is
+// served
+func Test_SpecInRoutes(t *testing.T) {
+	app := newApp(t, api.LinkConfig{})
+
+	served := map[string]bool{}
+	for _, r := range app.GetRoutes(true) {
+		served[strings.ToLower(r.Method)+" "+specPath(r.Path)] = true
+	}
+	for path, item := range at(spec(t, app), "paths") {
+		for method := range item.(map[string]any) {
+			assert.True(t, served[method+" "+path], "%s %s is in the spec but not served", method, path)
+		}
+	}
+}
+
+// Test_Refs checks every $ref points at a component that is there
+func Test_Refs(t *testing.T) {
+	doc := spec(t, newApp(t, api.LinkConfig{}))
+	assert.Equal(t, api.OpenAPIVersion, doc["openapi"])
+
+	var walk func(v any)
+	refs := 0
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok {
+				refs++
+				keys := strings.Split(strings.TrimPrefix(ref, "#/"), "/")
+				assert.NotNil(t, at(doc, keys...), "dangling %s", ref)
+			}
+			for _, e := range v {
+				walk(e)
+			}
+		case []any:
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+	walk(doc)
+	assert.Greater(t, refs, 0)
+}
+
+func keys(m map[string]any) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// Test_Schemas checks the schemas have the fields the API really sends
+func Test_Schemas(t *testing.T) {
+	app := newApp(t, api.LinkConfig{})
+	schemas := at(spec(t, app), "components", "schemas")
+
+	rsp, body := apptest.Do(t, app, http.MethodPost, api.VotersPath,
+		`{"name":"Ada","email":"ada@example.com","vote_history":[{"poll_id":1,"vote_id":2,"vote_date":"2024-01-02T03:04:05Z"}]}`)
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode)
+	var voter map[string]any
+	assert.Nil(t, json.Unmarshal(body, &voter))
+	assert.Equal(t, keys(voter), keys(at(schemas, "VoterResponse", "properties")))
+
+	history := voter["vote_history"].([]any)[0].(map[string]any)
+	assert.Equal(t, keys(history), keys(at(schemas, "VoterHistoryResponse", "properties")))
+
+	//The embedded vote_history is shadowed by the one with links
+	assert.Equal(t, "#/components/schemas/VoterHistoryResponse",
+		at(schemas, "VoterResponse", "properties", "vote_history", "items")["$ref"])
+
+	rsp, body = apptest.Do(t, app, http.MethodGet, "/voters/99", nil)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	var problem map[string]any
+	assert.Nil(t, json.Unmarshal(body, &problem))
+	for k := range problem {
+		assert.Contains(t, at(schemas, "Problem", "properties"), k)
+	}
+
+	//What Validate checks that reflection can't see
+	v := at(schemas, "Voter")
+	assert.ElementsMatch(t, []any{"name", "email"}, v["required"])
+	assert.Equal(t, float64(db.MaxNameLength), at(v, "properties", "name")["maxLength"])
+	assert.Equal(t, "date-time", at(schemas, "VoterHistory", "properties", "vote_date")["format"])
+}
+
+// Test_Security checks the voter routes ask for credentials with the roles
+// and the probes don't
+func Test_Security(t *testing.T) {
+	paths := at(spec(t, newApp(t, api.LinkConfig{})), "paths")
+
+	del := at(paths, "/voters/{id}", "delete")
+	assert.Len(t, del["security"], 3)
+	assert.Equal(t, []any{api.RoleAdmin}, del["x-roles"])
+	assert.Contains(t, at(del, "responses"), "403")
+	assert.Contains(t, at(del, "responses"), "412")
+
+	ready := at(paths, api.ReadyzPath, "get")
+	assert.Empty(t, ready["security"])
+	assert.NotContains(t, at(ready, "responses"), "401")
+}
+
+func Test_Docs(t *testing.T) {
+	app := newApp(t, api.LinkConfig{VoterBaseURL: "http://voters.example.com"})
+
+	rsp, body := apptest.Do(t, app, http.MethodGet, api.DocsPath, nil)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.True(t, strings.HasPrefix(rsp.Header.Get(fiber.HeaderContentType), fiber.MIMETextHTML))
+	assert.Contains(t, string(body), `"http://voters.example.com/openapi.json"`)
+
+	servers, _ := spec(t, app)["servers"].([]any)
+	if assert.Len(t, servers, 1) {
+		assert.Equal(t, "http://voters.example.com", servers[0].(map[string]any)["url"])
+	}
+}
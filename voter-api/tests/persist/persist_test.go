@@ -0,0 +1,256 @@
+package persist
+
+//The persist tests open the file store in a temp dir, write to it, and
+//open it again to check everything came back.  No server needed
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// ctx is the context of every store call, these tests don't cancel
+var ctx = context.Background()
+
+func history(pollId uint) db.VoterHistory {
+	return db.VoterHistory{
+		PollId:   pollId,
+		VoteId:   pollId * 10,
+		VoteDate: time.Date(2024, 2, 25, 9, 0, 0, 0, time.UTC),
+	}
+}
+
+func open(t *testing.T, dir string, snapshotEvery int) *db.VoterFile {
+	store, err := db.NewWithFileInstance(dir, snapshotEvery)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// load writes one of every mutation to the store
+func load(t *testing.T, store *db.VoterFile) {
+	for id := uint(1); id <= 4; id++ {
+		_, err := store.AddVoter(ctx, db.Voter{VoterId: id, Name: "Voter", Email: "v@example.com"})
+		assert.Nil(t, err)
+		assert.Nil(t, store.AddVoterPoll(ctx, id, history(10)))
+		assert.Nil(t, store.AddVoterPoll(ctx, id, history(20)))
+	}
+
+	//Voter 2 is at revision 3, add plus two polls
+	assert.Nil(t, store.UpdateVoter(ctx, 2, 3, db.Voter{VoterId: 2, Name: "Updated", VoteHistory: []db.VoterHistory{history(30)}}))
+	assert.Nil(t, store.UpdateVoterPoll(ctx, 1, 20, db.AnyRevision, history(21)))
+	assert.Nil(t, store.DeleteVoterPoll(ctx, 1, 10, db.AnyRevision))
+	assert.Nil(t, store.DeleteVoter(ctx, 3, db.AnyRevision))
+
+	patch, err := db.NewVoterPatch(db.MergePatchType, []byte(`{"email":"patched@example.com"}`))
+	assert.Nil(t, err)
+	assert.Nil(t, store.PatchVoter(ctx, 4, db.AnyRevision, patch))
+
+	//Failed mutations must not be logged
+	_, err = store.AddVoter(ctx, db.Voter{VoterId: 1})
+	assert.NotNil(t, err)
+	assert.NotNil(t, store.DeleteVoter(ctx, 99, db.AnyRevision))
+	assert.ErrorIs(t, store.DeleteVoter(ctx, 2, 3), db.ErrRevisionMismatch)
+}
+
+func checkLoaded(t *testing.T, store *db.VoterFile) {
+	voters, err := store.GetAllVoters(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(voters))
+
+	v1, err := store.GetVoter(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []db.VoterHistory{history(21)}, v1.VoteHistory)
+
+	v2, err := store.GetVoter(ctx, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "Updated", v2.Name)
+	assert.Equal(t, uint64(4), v2.Revision)
+	assert.Equal(t, []db.VoterHistory{history(30)}, v2.VoteHistory)
+
+	_, err = store.GetVoter(ctx, 3)
+	assert.NotNil(t, err)
+
+	v4, err := store.GetVoter(ctx, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, "patched@example.com", v4.Email)
+	assert.Equal(t, []db.VoterHistory{history(10), history(20)}, v4.VoteHistory)
+}
+
+// Test_ReplayLog reopens without Close, so everything has to come back
+// from the log
+func Test_ReplayLog(t *testing.T) {
+	dir := t.TempDir()
+
+	load(t, open(t, dir, db.DefaultSnapshotEvery))
+	checkLoaded(t, open(t, dir, db.DefaultSnapshotEvery))
+}
+
+// Test_Compaction snapshots every few writes so the voters come back from a
+// snapshot plus the tail of the log
+func Test_Compaction(t *testing.T) {
+	dir := t.TempDir()
+
+	load(t, open(t, dir, 3))
+	checkLoaded(t, open(t, dir, 3))
+}
+
+// Test_TimedCompaction checks a store that is written to once still gets
+// its log compacted, by the interval rather than the count
+func Test_TimedCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := db.NewWithFileConfig(db.FileConfig{Dir: dir, SnapshotEvery: db.DefaultSnapshotEvery, SnapshotInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.AddVoter(ctx, db.Voter{VoterId: 1, Name: "Voter", Email: "v@example.com"})
+	assert.Nil(t, err)
+
+	assert.Eventually(t, func() bool {
+		fi, err := os.Stat(filepath.Join(dir, "voters.wal"))
+		return err == nil && fi.Size() == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Nil(t, store.Close())
+
+	//The voter is in the snapshot, there is no log left to replay
+	reopened := open(t, dir, db.DefaultSnapshotEvery)
+	_, err = reopened.GetVoter(ctx, 1)
+	assert.Nil(t, err)
+}
+
+func Test_CloseAndReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	store := open(t, dir, db.DefaultSnapshotEvery)
+	load(t, store)
+	assert.Nil(t, store.Close())
+
+	//Close leaves everything in the snapshot and an empty log
+	info, err := os.Stat(filepath.Join(dir, "voters.wal"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), info.Size())
+
+	checkLoaded(t, open(t, dir, db.DefaultSnapshotEvery))
+}
+
+func Test_DeleteAllSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	store := open(t, dir, db.DefaultSnapshotEvery)
+	load(t, store)
+	cnt, err := store.DeleteAll(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, cnt)
+
+	voters, err := open(t, dir, db.DefaultSnapshotEvery).GetAllVoters(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(voters))
+}
+
+// Test_TornRecord cuts the last log record in half, like a crash in the
+// middle of a write.  The torn record is dropped and the rest replayed
+func Test_TornRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	store := open(t, dir, db.DefaultSnapshotEvery)
+	load(t, store)
+	_, err := store.AddVoter(ctx, db.Voter{VoterId: 5, Name: "Torn"})
+	assert.Nil(t, err)
+
+	walPath := filepath.Join(dir, "voters.wal")
+	b, err := os.ReadFile(walPath)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(walPath, b[:len(b)-10], 0o644))
+
+	store = open(t, dir, db.DefaultSnapshotEvery)
+	checkLoaded(t, store)
+	_, err = store.GetVoter(ctx, 5)
+	assert.NotNil(t, err, "torn record should be dropped")
+}
+
+// Test_CrashDuringCompaction puts back a log the snapshot already holds,
+// like a crash after the snapshot was written but before the log was
+// emptied.  Those records must not be applied twice
+func Test_CrashDuringCompaction(t *testing.T) {
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "voters.wal")
+
+	store := open(t, dir, db.DefaultSnapshotEvery)
+	load(t, store)
+	b, err := os.ReadFile(walPath)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Close())
+
+	assert.Nil(t, os.WriteFile(walPath, b, 0o644))
+	checkLoaded(t, open(t, dir, db.DefaultSnapshotEvery))
+}
+
+// Test_AssignedIdsSurviveRestart checks that ids the store picked come back
+// under the same id, and that a deleted voter's id is not handed out again
+// after a restart, from the log or from a snapshot
+func Test_AssignedIdsSurviveRestart(t *testing.T) {
+	for _, snapshotEvery := range []int{db.DefaultSnapshotEvery, 1} {
+		dir := t.TempDir()
+
+		store := open(t, dir, snapshotEvery)
+		_, err := store.AddVoter(ctx, db.Voter{VoterId: 7, Name: "Migrated", Email: "m@example.com"})
+		assert.Nil(t, err)
+		id, err := store.AddVoter(ctx, db.Voter{Name: "Assigned", Email: "a@example.com"})
+		assert.Nil(t, err)
+		assert.Equal(t, uint(8), id)
+		assert.Nil(t, store.DeleteVoter(ctx, id, db.AnyRevision))
+
+		store = open(t, dir, snapshotEvery)
+		id, err = store.AddVoter(ctx, db.Voter{Name: "After Restart", Email: "r@example.com"})
+		assert.Nil(t, err)
+		assert.Equal(t, uint(9), id)
+
+		voter, err := open(t, dir, snapshotEvery).GetVoter(ctx, 9)
+		assert.Nil(t, err)
+		assert.Equal(t, "After Restart", voter.Name)
+	}
+}
+
+// Test_FailedAppend points the log at /dev/full, every write to it fails.
+// A voter whose record didn't make it to the log must not be in the map
+// either, not now and not after a restart
+func Test_FailedAppend(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full")
+	}
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "voters.wal")
+
+	//With a snapshot every record, the first add reopens the log, and
+	//that's when it gets /dev/full
+	store := open(t, dir, 1)
+	assert.Nil(t, os.Remove(walPath))
+	assert.Nil(t, os.Symlink("/dev/full", walPath))
+	_, err := store.AddVoter(ctx, db.Voter{VoterId: 1, Name: "Logged", Email: "l@example.com"})
+	assert.Nil(t, err)
+
+	_, err = store.AddVoter(ctx, db.Voter{VoterId: 2, Name: "Lost", Email: "x@example.com"})
+	assert.ErrorIs(t, err, db.ErrUnavailable)
+	_, err = store.GetVoter(ctx, 2)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+	assert.NotNil(t, store.AddVoterPoll(ctx, 1, history(10)))
+	voter, err := store.GetVoter(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), voter.Revision)
+	assert.Empty(t, voter.VoteHistory)
+
+	//Replay would read zeros from /dev/full forever
+	assert.Nil(t, os.Remove(walPath))
+	store = open(t, dir, 1)
+	_, err = store.GetVoter(ctx, 1)
+	assert.Nil(t, err)
+	_, err = store.GetVoter(ctx, 2)
+	assert.ErrorIs(t, err, db.ErrNotFound)
+}
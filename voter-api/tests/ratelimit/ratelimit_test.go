@@ -0,0 +1,217 @@
+package ratelimit
+
+//The rate limit tests run the voter API in process.  The redis ones use
+//miniredis, so no server or redis needed
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/tests/apptest"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newApp(t *testing.T, cfg api.RateLimitConfig) (*fiber.App, *api.VoterAPI) {
+	return apptest.New(t, apptest.Options{Setup: func(_ testing.TB, vt *api.VoterAPI) {
+		vt.UseRateLimit(api.NewRateLimiter(cfg))
+	}})
+}
+
+func get(t *testing.T, app *fiber.App, path string, headers ...string) *http.Response {
+	rsp, _ := apptest.Do(t, app, http.MethodGet, path, nil, headers...)
+	return rsp
+}
+
+// statuses is the status of n GETs of path
+func statuses(t *testing.T, app *fiber.App, path string, n int, headers ...string) []int {
+	var codes []int
+	for i := 0; i < n; i++ {
+		codes = append(codes, get(t, app, path, headers...).StatusCode)
+	}
+	return codes
+}
+
+func Test_Limit(t *testing.T) {
+	app, _ := newApp(t, api.RateLimitConfig{
+		Store:   api.RateLimitMemory,
+		Default: api.RatePolicy{Limit: 3, Window: time.Minute},
+	})
+
+	rsp := get(t, app, "/voters")
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "3", rsp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "2", rsp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "20", rsp.Header.Get("RateLimit-Reset"))
+	assert.Equal(t, "3;w=60", rsp.Header.Get("RateLimit-Policy"))
+
+	assert.Equal(t, []int{200, 200, 429}, statuses(t, app, "/voters", 3))
+
+	rsp = get(t, app, "/voters")
+	assert.Equal(t, http.StatusTooManyRequests, rsp.StatusCode)
+	assert.Equal(t, "0", rsp.Header.Get("RateLimit-Remaining"))
+	retry, err := strconv.Atoi(rsp.Header.Get("Retry-After"))
+	assert.Nil(t, err)
+	assert.True(t, retry > 0 && retry <= 20, "Retry-After %d", retry)
+
+	//Each route has its own bucket, and the probes are never limited
+	assert.Equal(t, http.StatusNotFound, get(t, app, "/voters/1/polls").StatusCode)
+	assert.Equal(t, []int{200, 200, 200, 200, 200}, statuses(t, app, api.LivezPath, 5))
+}
+
+func Test_RoutePolicies(t *testing.T) {
+	app, _ := newApp(t, api.RateLimitConfig{
+		Store:   api.RateLimitMemory,
+		Default: api.RatePolicy{Limit: 2, Window: time.Minute},
+		Routes: map[string]api.RatePolicy{
+			"GET /voters/:id": {Limit: 1, Window: time.Minute},
+			"GET /voters":     {Limit: 0},
+		},
+	})
+
+	assert.Equal(t, []int{404, 429}, statuses(t, app, "/voters/1", 2))
+	//The bucket is per route, not per url
+	assert.Equal(t, http.StatusTooManyRequests, get(t, app, "/voters/2").StatusCode)
+
+	rsp := get(t, app, "/voters")
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, "", rsp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, []int{200, 200, 200}, statuses(t, app, "/voters", 3))
+
+	assert.Equal(t, []int{404, 404, 429}, statuses(t, app, "/voters/1/polls", 3))
+}
+
+// Test_Clients checks every client has a bucket of its own
+func Test_Clients(t *testing.T) {
+	//app.Test connects from 0.0.0.0, that is the load balancer here
+	app, vt := newApp(t, api.RateLimitConfig{
+		Store:          api.RateLimitMemory,
+		Default:        api.RatePolicy{Limit: 1, Window: time.Minute},
+		IPHeader:       "X-Forwarded-For",
+		TrustedProxies: []string{"0.0.0.0", "10.0.0.0/8"},
+	})
+
+	assert.Equal(t, []int{200, 429}, statuses(t, app, "/voters", 2, "X-Forwarded-For", "203.0.113.1, 10.0.0.1"))
+	assert.Equal(t, []int{200, 429}, statuses(t, app, "/voters", 2, "X-Forwarded-For", "203.0.113.2, 10.0.0.1"))
+
+	//With auth on, API keys are counted apart even from the same address
+	auth, err := api.NewAuthenticator(api.AuthConfig{APIKeys: []api.APIKey{
+		{Name: "ops", Key: "ops-key-0123456789", Role: api.RoleAdmin},
+		{Name: "desk", Key: "desk-key-0123456789", Role: api.RoleClerk},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vt.UseAuth(auth)
+	ip := "203.0.113.3"
+	assert.Equal(t, []int{200, 429}, statuses(t, app, "/voters", 2, "X-Forwarded-For", ip, api.APIKeyHeader, "ops-key-0123456789"))
+	assert.Equal(t, []int{200, 429}, statuses(t, app, "/voters", 2, "X-Forwarded-For", ip, api.APIKeyHeader, "desk-key-0123456789"))
+}
+
+// Test_SpoofedAddress checks a client can't pick its own bucket by sending
+// X-Forwarded-For itself
+func Test_SpoofedAddress(t *testing.T) {
+	app, _ := newApp(t, api.RateLimitConfig{
+		Store:          api.RateLimitMemory,
+		Default:        api.RatePolicy{Limit: 1, Window: time.Minute},
+		IPHeader:       "X-Forwarded-For",
+		TrustedProxies: []string{"0.0.0.0"},
+	})
+
+	//The load balancer appends the address it saw, what the client sent
+	//is left of it
+	assert.Equal(t, []int{200}, statuses(t, app, "/voters", 1, "X-Forwarded-For", "198.51.100.1, 203.0.113.1"))
+	assert.Equal(t, []int{429}, statuses(t, app, "/voters", 1, "X-Forwarded-For", "198.51.100.2, 203.0.113.1"))
+	assert.Equal(t, []int{429}, statuses(t, app, "/voters", 1, "X-Forwarded-For", "garbage, 203.0.113.1"))
+
+	//Not behind a proxy we trust, the header is not read at all
+	app, _ = newApp(t, api.RateLimitConfig{
+		Store:          api.RateLimitMemory,
+		Default:        api.RatePolicy{Limit: 1, Window: time.Minute},
+		IPHeader:       "X-Forwarded-For",
+		TrustedProxies: []string{"192.0.2.1"},
+	})
+	assert.Equal(t, []int{200}, statuses(t, app, "/voters", 1, "X-Forwarded-For", "203.0.113.1"))
+	assert.Equal(t, []int{429}, statuses(t, app, "/voters", 1, "X-Forwarded-For", "203.0.113.2"))
+}
+
+// Test_PerIP checks the per address bucket is taken before auth, so
+// guessing keys runs into it
+func Test_PerIP(t *testing.T) {
+	app, vt := newApp(t, api.RateLimitConfig{
+		Store:   api.RateLimitMemory,
+		Default: api.RatePolicy{Limit: 10, Window: time.Minute},
+		PerIP:   api.RatePolicy{Limit: 3, Window: time.Minute},
+	})
+	auth, err := api.NewAuthenticator(api.AuthConfig{APIKeys: []api.APIKey{
+		{Name: "ops", Key: "ops-key-0123456789", Role: api.RoleAdmin},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vt.UseAuth(auth)
+
+	assert.Equal(t, []int{401, 401}, statuses(t, app, "/voters", 2, api.APIKeyHeader, "guess-0123456789"))
+	rsp := get(t, app, "/voters/1", api.APIKeyHeader, "ops-key-0123456789")
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	assert.Equal(t, "10", rsp.Header.Get("RateLimit-Limit"), "the route's bucket has the last word")
+
+	//Every route counts, and the right key does not help either
+	rsp = get(t, app, "/voters", api.APIKeyHeader, "ops-key-0123456789")
+	assert.Equal(t, http.StatusTooManyRequests, rsp.StatusCode)
+	assert.Equal(t, "3", rsp.Header.Get("RateLimit-Limit"))
+	assert.NotEmpty(t, rsp.Header.Get("Retry-After"))
+}
+
+// Test_Shared runs two instances on one redis, between them they only get
+// the limit once
+func Test_Shared(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := api.RateLimitConfig{
+		Store:   api.RateLimitRedis,
+		Default: api.RatePolicy{Limit: 4, Window: time.Minute},
+		Redis:   db.RedisConfig{Addr: mr.Addr(), KeyPrefix: "test:"},
+	}
+	app1, _ := newApp(t, cfg)
+	app2, _ := newApp(t, cfg)
+
+	assert.Equal(t, []int{200, 200}, statuses(t, app1, "/voters", 2))
+	assert.Equal(t, []int{200, 200, 429}, statuses(t, app2, "/voters", 3))
+	assert.Equal(t, http.StatusTooManyRequests, get(t, app1, "/voters").StatusCode)
+
+	keys := mr.Keys()
+	if assert.Len(t, keys, 1) {
+		assert.Equal(t, "test:ratelimit:GET /voters ip:0.0.0.0", keys[0])
+		assert.True(t, mr.TTL(keys[0]) > 0, "the bucket expires")
+	}
+}
+
+// Test_RedisDown checks we still limit, per instance, while redis is down,
+// and don't hammer redis the moment it is back
+func Test_RedisDown(t *testing.T) {
+	mr := miniredis.RunT(t)
+	app, _ := newApp(t, api.RateLimitConfig{
+		Store:   api.RateLimitRedis,
+		Default: api.RatePolicy{Limit: 2, Window: time.Minute},
+		Redis:   db.RedisConfig{Addr: mr.Addr()},
+	})
+	assert.Equal(t, []int{200}, statuses(t, app, "/voters", 1))
+
+	mr.Close()
+	assert.Equal(t, []int{200, 200, 429}, statuses(t, app, "/voters", 3))
+
+	//Not asked again for a while after it failed, so this still counts
+	//locally and the bucket in redis still has the one token taken
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, get(t, app, "/voters").StatusCode)
+	tokens, err := strconv.ParseFloat(mr.HGet("ratelimit:GET /voters ip:0.0.0.0", "tokens"), 64)
+	assert.Nil(t, err)
+	assert.InDelta(t, 1, tokens, 0.1)
+}
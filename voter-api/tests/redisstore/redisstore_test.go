@@ -0,0 +1,230 @@
+package redisstore
+
+//The redis store tests run the voter API in process against the redis-stack
+//at REDIS_URL, miniredis has no RedisJSON.  They skip when REDIS_URL isn't
+//set.  Each test keeps its keys under a prefix of its own and deletes them
+//when it ends, so any redis-stack will do
+//
+//	docker run -d -p 6379:6379 redis/redis-stack-server
+//	REDIS_URL=localhost:6379 go test ./tests/redisstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/tests/apptest"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// store reaches into the redis behind the app, to plant documents the
+// store would not write itself and to look at what it did write
+type store struct {
+	client *redis.Client
+	prefix string
+}
+
+func newApp(t *testing.T) (*fiber.App, *store) {
+	addr := os.Getenv("REDIS_URL")
+	if addr == "" {
+		t.Skip("REDIS_URL not set, the redis store tests need redis-stack")
+	}
+
+	rds := &store{
+		client: redis.NewClient(&redis.Options{Addr: addr}),
+		prefix: fmt.Sprintf("test:%s:%d:", t.Name(), time.Now().UnixNano()),
+	}
+	t.Cleanup(rds.clear)
+
+	app, _ := apptest.New(t, apptest.Options{Store: db.StoreConfig{
+		Type:  db.StoreRedis,
+		Redis: db.RedisConfig{Addr: addr, KeyPrefix: rds.prefix},
+	}})
+	return app, rds
+}
+
+// clear drops the test's search index and every key under its prefix
+func (s *store) clear() {
+	ctx := context.Background()
+	s.client.Do(ctx, "FT.DROPINDEX", s.prefix+db.RedisSearchIndex)
+	iter := s.client.Scan(ctx, 0, s.prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		s.client.Del(ctx, iter.Val())
+	}
+	s.client.Close()
+}
+
+func (s *store) setJSON(t *testing.T, key, doc string) {
+	t.Helper()
+	//JSONSet sends a string as is, so doc goes over as the JSON it already is
+	if err := s.client.JSONSet(context.Background(), s.prefix+key, "$", doc).Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// getJSON is the document under key, or "" if there is none
+func (s *store) getJSON(key string) string {
+	return s.client.JSONGet(context.Background(), s.prefix+key).Val()
+}
+
+// get is the string under key, or "" if there is none
+func (s *store) get(key string) string {
+	return s.client.Get(context.Background(), s.prefix+key).Val()
+}
+
+func getVoter(t *testing.T, app *fiber.App, id string) (db.Voter, *http.Response) {
+	rsp, body := apptest.Do(t, app, http.MethodGet, "/voters/"+id, nil)
+	var voter db.Voter
+	if rsp.StatusCode == http.StatusOK {
+		assert.Nil(t, json.Unmarshal(body, &voter))
+	}
+	return voter, rsp
+}
+
+// Test_PatchVoter checks both patch types change only what they say and
+// bump the revision
+func Test_PatchVoter(t *testing.T) {
+	app, rds := newApp(t)
+	rsp, _ := apptest.Do(t, app, http.MethodPost, "/voters", db.Voter{VoterId: 1, Name: "John Doe", Email: "john@example.com"})
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode)
+
+	rsp, body := apptest.Do(t, app, http.MethodPatch, "/voters/1", `{"name":"Merge Patched"}`,
+		fiber.HeaderContentType, db.MergePatchType)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode, string(body))
+	rsp, body = apptest.Do(t, app, http.MethodPatch, "/voters/1",
+		`[{"op":"replace","path":"/email","value":"patched@example.com"}]`,
+		fiber.HeaderContentType, db.JSONPatchType)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode, string(body))
+
+	voter, _ := getVoter(t, app, "1")
+	assert.Equal(t, "Merge Patched", voter.Name)
+	assert.Equal(t, "patched@example.com", voter.Email)
+	assert.Equal(t, uint64(3), voter.Revision)
+	assert.JSONEq(t, `{"voter_id":1,"name":"Merge Patched","email":"patched@example.com","vote_history":[],"revision":3}`,
+		rds.getJSON("voter:1"))
+}
+
+// Test_PatchMissingVoter checks a patch of a voter that isn't there is a
+// 404 like on the other stores
+func Test_PatchMissingVoter(t *testing.T) {
+	app, _ := newApp(t)
+	rsp, body := apptest.Do(t, app, http.MethodPatch, "/voters/9", `{"name":"Nobody"}`,
+		fiber.HeaderContentType, db.MergePatchType)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	var p api.Problem
+	assert.Nil(t, json.Unmarshal(body, &p))
+	assert.Equal(t, "voter_not_found", p.Code)
+}
+
+// Test_OldHistory plants a voter the way the store wrote them before
+// vote_history was always an array.  Votes can still be added to it
+func Test_OldHistory(t *testing.T) {
+	app, rds := newApp(t)
+	rds.setJSON(t, "voter:1", `{"voter_id":1,"name":"Old Voter","email":"old@example.com","vote_history":null}`)
+
+	rsp, body := apptest.Do(t, app, http.MethodPost, "/voters/1/polls",
+		db.VoterHistory{PollId: 10, VoteId: 100, VoteDate: time.Date(2024, 2, 25, 9, 0, 0, 0, time.UTC)})
+	assert.Equal(t, http.StatusOK, rsp.StatusCode, string(body))
+
+	rsp, body = apptest.Do(t, app, http.MethodGet, "/voters/1/polls", nil)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	var history []db.VoterHistory
+	assert.Nil(t, json.Unmarshal(body, &history))
+	assert.Equal(t, 1, len(history))
+
+	voter, _ := getVoter(t, app, "1")
+	assert.Equal(t, "Old Voter", voter.Name)
+	assert.Equal(t, 1, len(voter.VoteHistory))
+}
+
+// Test_NoRevision plants a voter from before there were revisions.  It
+// reads as revision 1, and If-Match with that revision updates it
+func Test_NoRevision(t *testing.T) {
+	app, rds := newApp(t)
+	rds.setJSON(t, "voter:1", `{"voter_id":1,"name":"Old Voter","email":"old@example.com","vote_history":[]}`)
+
+	voter, rsp := getVoter(t, app, "1")
+	assert.Equal(t, uint64(1), voter.Revision)
+	assert.Equal(t, `"1"`, rsp.Header.Get(fiber.HeaderETag))
+
+	rsp, body := apptest.Do(t, app, http.MethodPut, "/voters/1",
+		db.Voter{Name: "Updated Voter", Email: "old@example.com"}, fiber.HeaderIfMatch, `"1"`)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode, string(body))
+
+	voter, rsp = getVoter(t, app, "1")
+	assert.Equal(t, "Updated Voter", voter.Name)
+	assert.Equal(t, uint64(2), voter.Revision)
+	assert.Equal(t, `"2"`, rsp.Header.Get(fiber.HeaderETag))
+}
+
+// Test_ExplicitIdRaisesCounter checks a voter added with its own id moves
+// the id counter past it, so the next assigned id doesn't collide
+func Test_ExplicitIdRaisesCounter(t *testing.T) {
+	app, rds := newApp(t)
+
+	for _, id := range []uint{5, 3} {
+		rsp, body := apptest.Do(t, app, http.MethodPost, "/voters",
+			db.Voter{VoterId: id, Name: "Picked Id", Email: "picked@example.com"})
+		assert.Equal(t, http.StatusCreated, rsp.StatusCode, string(body))
+	}
+	assert.Equal(t, "5", rds.get("voters:next_id"))
+
+	rsp, body := apptest.Do(t, app, http.MethodPost, "/voters",
+		db.Voter{Name: "Assigned Id", Email: "assigned@example.com"})
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode, string(body))
+	var voter db.Voter
+	assert.Nil(t, json.Unmarshal(body, &voter))
+	assert.Equal(t, uint(6), voter.VoterId)
+}
+
+// listAll follows the next links from path and returns every page's voters
+// in order.  No page may hold more than limit
+func listAll(t *testing.T, app *fiber.App, path string, limit int) []db.Voter {
+	var voters []db.Voter
+	for pages := 0; path != "" && pages < 20; pages++ {
+		rsp, body := apptest.Do(t, app, http.MethodGet, path, nil)
+		assert.Equal(t, http.StatusOK, rsp.StatusCode, string(body))
+		var page api.VoterPageResponse
+		assert.Nil(t, json.Unmarshal(body, &page))
+		assert.LessOrEqual(t, len(page.Voters), limit)
+		for _, v := range page.Voters {
+			voters = append(voters, v.Voter)
+		}
+		path = page.Links["next"].Href
+	}
+	return voters
+}
+
+// Test_ListVotersPaging checks SCAN paging hands out every voter once and
+// keeps to the limit, and that sort=name holds across pages, not just
+// within each one
+func Test_ListVotersPaging(t *testing.T) {
+	app, _ := newApp(t)
+	names := []string{"Eve", "Bob", "Dan", "Amy", "Cal", "Fay", "Gus"}
+	for i, name := range names {
+		rsp, body := apptest.Do(t, app, http.MethodPost, "/voters",
+			db.Voter{VoterId: uint(i + 1), Name: name, Email: "pager@example.com"})
+		assert.Equal(t, http.StatusCreated, rsp.StatusCode, string(body))
+	}
+
+	seen := map[uint]bool{}
+	for _, v := range listAll(t, app, "/voters?limit=2", 2) {
+		assert.False(t, seen[v.VoterId], "voter returned twice")
+		seen[v.VoterId] = true
+	}
+	assert.Equal(t, len(names), len(seen))
+
+	var byName []string
+	for _, v := range listAll(t, app, "/voters?limit=2&sort=name", 2) {
+		byName = append(byName, v.Name)
+	}
+	assert.Equal(t, []string{"Amy", "Bob", "Cal", "Dan", "Eve", "Fay", "Gus"}, byName)
+}
@@ -0,0 +1,219 @@
+package shutdown
+
+//The shutdown tests run the voter API on a real port with the file store
+//in a temp dir and stop it the way SIGTERM does, by cancelling Serve's
+//context.  No server needed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/db"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// No keep-alives, so every request is a new connection and a request made
+// after the shutdown really finds nobody listening
+var cli = http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+func newAPI(t *testing.T) (*api.VoterAPI, *fiber.App, string) {
+	dir := t.TempDir()
+	t.Setenv("VOTER_DATA_DIR", dir)
+
+	vt, err := api.New(db.StoreFile, api.LinkConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New(fiber.Config{
+		ErrorHandler:          api.ErrorHandler,
+		DisableStartupMessage: true,
+	})
+	vt.RegisterRoutes(app)
+	return vt, app, dir
+}
+
+// freeAddr finds a port nothing listens on
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func waitForServer(t *testing.T, base string) {
+	for i := 0; i < 100; i++ {
+		if rsp, err := cli.Get(base + api.LivezPath); err == nil {
+			rsp.Body.Close()
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("server did not start")
+}
+
+func Test_ReadyzDuringShutdown(t *testing.T) {
+	vt, app, _ := newAPI(t)
+	defer vt.Close()
+
+	rsp, err := app.Test(httptest.NewRequest(http.MethodGet, api.ReadyzPath, nil))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	vt.Shutdown()
+
+	rsp, err = app.Test(httptest.NewRequest(http.MethodGet, api.ReadyzPath, nil))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+
+	var ready api.ReadyResponse
+	assert.Nil(t, json.NewDecoder(rsp.Body).Decode(&ready))
+	assert.Equal(t, "shutting_down", ready.Status)
+
+	//Liveness is unaffected, we are still up, just on the way out
+	rsp, err = app.Test(httptest.NewRequest(http.MethodGet, api.LivezPath, nil))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+}
+
+// Test_DrainAndFlush starts a slow request, shuts down while it runs, and
+// checks the request still finished and the file store wrote its snapshot
+func Test_DrainAndFlush(t *testing.T) {
+	vt, app, dir := newAPI(t)
+	app.Get("/slow", func(c *fiber.Ctx) error {
+		time.Sleep(300 * time.Millisecond)
+		return c.SendString("done")
+	})
+
+	addr := freeAddr(t)
+	base := "http://" + addr
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- vt.Serve(ctx, app, addr, api.ShutdownConfig{Drain: 5 * time.Second})
+	}()
+	waitForServer(t, base)
+
+	rsp, err := cli.Post(base+api.VotersPath, "application/json", strings.NewReader(`{"name":"Drained","email":"drained@example.com"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode)
+	rsp.Body.Close()
+
+	slow := make(chan string, 1)
+	go func() {
+		rsp, err := cli.Get(base + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer rsp.Body.Close()
+		b, _ := io.ReadAll(rsp.Body)
+		slow <- fmt.Sprintf("%d %s", rsp.StatusCode, b)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	cancel()
+	assert.Equal(t, "200 done", <-slow, "the in-flight request should be drained")
+	assert.Nil(t, <-served)
+
+	//New connections are refused once we are down
+	_, err = cli.Get(base + api.LivezPath)
+	assert.NotNil(t, err)
+
+	//Close wrote the voter into the snapshot and emptied the log
+	snapshot, err := os.ReadFile(filepath.Join(dir, "voters.json"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(snapshot), "drained@example.com")
+	info, err := os.Stat(filepath.Join(dir, "voters.wal"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), info.Size())
+}
+
+// Test_DrainDeadline checks a request that outlives the drain timeout does
+// not hold up the shutdown
+func Test_DrainDeadline(t *testing.T) {
+	vt, app, _ := newAPI(t)
+	release := make(chan struct{})
+	app.Get("/stuck", func(c *fiber.Ctx) error {
+		<-release
+		return c.SendString("late")
+	})
+	defer close(release)
+
+	addr := freeAddr(t)
+	base := "http://" + addr
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- vt.Serve(ctx, app, addr, api.ShutdownConfig{Drain: 200 * time.Millisecond})
+	}()
+	waitForServer(t, base)
+
+	go cli.Get(base + "/stuck")
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	cancel()
+	select {
+	case err := <-served:
+		assert.Nil(t, err)
+		assert.Less(t, time.Since(start), 2*time.Second)
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown waited past the drain timeout")
+	}
+}
+
+// Test_PreStop checks that for the pre-stop delay the listener still takes
+// new connections, /readyz on them answers 503 and the rest is served, and
+// that the listener closes once the delay is over
+func Test_PreStop(t *testing.T) {
+	vt, app, _ := newAPI(t)
+
+	addr := freeAddr(t)
+	base := "http://" + addr
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- vt.Serve(ctx, app, addr, api.ShutdownConfig{PreStop: 500 * time.Millisecond, Drain: time.Second})
+	}()
+	waitForServer(t, base)
+
+	start := time.Now()
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+
+	//Every request is a new connection, see cli
+	rsp, err := cli.Get(base + api.ReadyzPath)
+	if assert.Nil(t, err, "the listener should still accept during pre-stop") {
+		assert.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+		rsp.Body.Close()
+	}
+	rsp, err = cli.Get(base + api.LivezPath)
+	if assert.Nil(t, err) {
+		assert.Equal(t, http.StatusOK, rsp.StatusCode)
+		rsp.Body.Close()
+	}
+
+	select {
+	case err := <-served:
+		assert.Nil(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), 500*time.Millisecond)
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not finish after the pre-stop delay")
+	}
+	_, err = cli.Get(base + api.LivezPath)
+	assert.NotNil(t, err)
+}
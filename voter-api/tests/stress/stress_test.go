@@ -0,0 +1,229 @@
+package stress
+
+//The stress tests run the voter API in process against the memory store,
+//no server needed.  They are only useful under the race detector:
+//
+//	go test -race ./tests/stress/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/db"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	workers = 8
+	rounds  = 20
+
+	//Every worker also writes to this voter so they all contend on it
+	sharedVoterId = 1
+)
+
+func newApp(t *testing.T) *fiber.App {
+	apiHandler, err := api.New(db.StoreMemory, api.LinkConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New(fiber.Config{
+		ErrorHandler: api.ErrorHandler,
+	})
+	apiHandler.RegisterRoutes(app)
+	return app
+}
+
+// do runs one request through the app and returns the status and body
+func do(t *testing.T, app *fiber.App, method, path string, body interface{}) (int, []byte) {
+	var rdr io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Error(err)
+			return 0, nil
+		}
+		rdr = bytes.NewReader(b)
+	}
+
+	req := httptest.NewRequest(method, path, rdr)
+	req.Header.Set("Content-Type", "application/json")
+
+	rsp, err := app.Test(req, -1)
+	if err != nil {
+		t.Error(err)
+		return 0, nil
+	}
+	defer rsp.Body.Close()
+
+	b, _ := io.ReadAll(rsp.Body)
+	return rsp.StatusCode, b
+}
+
+func history(pollId uint) db.VoterHistory {
+	return db.VoterHistory{
+		PollId:   pollId,
+		VoteId:   pollId * 10,
+		VoteDate: time.Now().UTC(),
+	}
+}
+
+// worker walks one voter through every handler, and in between appends
+// to and reads back the shared voter
+func worker(t *testing.T, app *fiber.App, w int) {
+	for r := 0; r < rounds; r++ {
+		id := uint(1000 + w*rounds + r)
+		voterPath := fmt.Sprintf("/voters/%d", id)
+		pollId := uint(w*rounds + r + 1)
+
+		voter := db.Voter{VoterId: id, Name: fmt.Sprintf("Worker %d", w), Email: fmt.Sprintf("w%d@example.com", w)}
+		if code, _ := do(t, app, http.MethodPost, "/voters", voter); code != http.StatusCreated {
+			t.Errorf("add voter %d: %d", id, code)
+			continue
+		}
+
+		do(t, app, http.MethodPost, voterPath+"/polls", history(pollId))
+		do(t, app, http.MethodPut, fmt.Sprintf("%s/polls/%d", voterPath, pollId), history(pollId))
+		voter.Name = fmt.Sprintf("Worker %d round %d", w, r)
+		do(t, app, http.MethodPut, voterPath, voter)
+
+		do(t, app, http.MethodGet, voterPath, nil)
+		do(t, app, http.MethodGet, voterPath+"/polls", nil)
+		do(t, app, http.MethodGet, fmt.Sprintf("%s/polls/%d", voterPath, pollId), nil)
+		do(t, app, http.MethodGet, "/voters?limit=5&sort=name", nil)
+		do(t, app, http.MethodGet, "/voters?name=worker", nil)
+		do(t, app, http.MethodGet, "/voters/search?name=worker", nil)
+		do(t, app, http.MethodGet, "/voters/health", nil)
+
+		//Every poll id is unique, so each append to the shared voter has
+		//to survive.  A lost update shows up in Test_StressHandlers
+		shared := fmt.Sprintf("/voters/%d/polls", sharedVoterId)
+		if code, _ := do(t, app, http.MethodPost, shared, history(pollId)); code != http.StatusOK {
+			t.Errorf("add shared poll %d: %d", pollId, code)
+		}
+		do(t, app, http.MethodGet, shared, nil)
+
+		do(t, app, http.MethodDelete, fmt.Sprintf("%s/polls/%d", voterPath, pollId), nil)
+		if r%2 == 0 {
+			do(t, app, http.MethodDelete, voterPath, nil)
+		}
+	}
+}
+
+func Test_StressHandlers(t *testing.T) {
+	app := newApp(t)
+
+	code, _ := do(t, app, http.MethodPost, "/voters", db.Voter{VoterId: sharedVoterId, Name: "Shared Voter", Email: "shared@example.com"})
+	assert.Equal(t, http.StatusCreated, code)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			worker(t, app, w)
+		}(w)
+	}
+	wg.Wait()
+
+	code, body := do(t, app, http.MethodGet, fmt.Sprintf("/voters/%d/polls", sharedVoterId), nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	var polls []db.VoterHistory
+	assert.Nil(t, json.Unmarshal(body, &polls))
+	assert.Equal(t, workers*rounds, len(polls), "every shared poll append should be kept")
+
+	//The even rounds deleted their voter, the odd rounds plus the shared
+	//voter are left
+	code, body = do(t, app, http.MethodGet, "/voters?limit=1000", nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	var page api.VoterPageResponse
+	assert.Nil(t, json.Unmarshal(body, &page))
+	assert.Equal(t, workers*(rounds/2)+1, len(page.Voters))
+}
+
+// Test_StressSharedVoterPolls races updates and deletes against appends on
+// the same voter's history, the read-modify-write paths of the store
+func Test_StressSharedVoterPolls(t *testing.T) {
+	app := newApp(t)
+
+	code, _ := do(t, app, http.MethodPost, "/voters", db.Voter{VoterId: sharedVoterId, Name: "Shared Voter", Email: "shared@example.com"})
+	assert.Equal(t, http.StatusCreated, code)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				pollId := uint(w*rounds + r + 1)
+				pollPath := fmt.Sprintf("/voters/%d/polls/%d", sharedVoterId, pollId)
+
+				do(t, app, http.MethodPost, fmt.Sprintf("/voters/%d/polls", sharedVoterId), history(pollId))
+				do(t, app, http.MethodPut, pollPath, history(pollId))
+				do(t, app, http.MethodGet, fmt.Sprintf("/voters/%d", sharedVoterId), nil)
+				if r%2 == 1 {
+					do(t, app, http.MethodDelete, pollPath, nil)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	code, body := do(t, app, http.MethodGet, fmt.Sprintf("/voters/%d/polls", sharedVoterId), nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	var polls []db.VoterHistory
+	assert.Nil(t, json.Unmarshal(body, &polls))
+	assert.Equal(t, workers*((rounds+1)/2), len(polls), "only the odd rounds should have been deleted")
+
+	seen := map[uint]bool{}
+	for _, p := range polls {
+		assert.False(t, seen[p.PollId], "poll %d is in the history twice", p.PollId)
+		seen[p.PollId] = true
+	}
+}
+
+// Test_StressAssignedIds creates voters without an id from every worker at
+// once, no two may get the same one
+func Test_StressAssignedIds(t *testing.T) {
+	app := newApp(t)
+
+	ids := make(chan uint, workers*rounds)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				code, body := do(t, app, http.MethodPost, "/voters", db.Voter{Name: fmt.Sprintf("Worker %d", w), Email: fmt.Sprintf("w%d@example.com", w)})
+				if code != http.StatusCreated {
+					t.Errorf("add voter: %d", code)
+					continue
+				}
+				var voter db.Voter
+				assert.Nil(t, json.Unmarshal(body, &voter))
+				ids <- voter.VoterId
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := map[uint]bool{}
+	for id := range ids {
+		assert.False(t, seen[id], "voter id %d was handed out twice", id)
+		seen[id] = true
+	}
+	assert.Equal(t, workers*rounds, len(seen))
+}
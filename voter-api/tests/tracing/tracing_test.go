@@ -0,0 +1,271 @@
+package tracing
+
+//The tracing tests run the voter API in process with a span recorder as
+//the tracer provider.  The redis ones use miniredis and the OTLP one a
+//fake collector, so no server, redis or collector needed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/db"
+	"drexel.edu/todo/logging"
+	"drexel.edu/todo/tests/apptest"
+	"drexel.edu/todo/tracing"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	traceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	parentID    = "00f067aa0ba902b7"
+	traceparent = "00-" + traceID + "-" + parentID + "-01"
+)
+
+// record makes a span recorder the global tracer provider for the rest of
+// the test
+func record(t *testing.T) *tracetest.SpanRecorder {
+	if _, err := tracing.Setup(tracing.Config{Exporter: tracing.ExporterNone}); err != nil {
+		t.Fatal(err)
+	}
+	sr := tracetest.NewSpanRecorder()
+	old := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	t.Cleanup(func() { otel.SetTracerProvider(old) })
+	return sr
+}
+
+func newApp(t *testing.T, store db.StoreConfig) *fiber.App {
+	app, _ := apptest.New(t, apptest.Options{Store: store})
+	return app
+}
+
+func get(t *testing.T, app *fiber.App, path string, headers ...string) *http.Response {
+	rsp, _ := apptest.Do(t, app, http.MethodGet, path, nil, headers...)
+	return rsp
+}
+
+// byName is the ended spans of sr by name, the last one wins
+func byName(sr *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range sr.Ended() {
+		spans[s.Name()] = s
+	}
+	return spans
+}
+
+func attr(s sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, kv := range s.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// Test_RequestSpans checks a request joins the trace of its traceparent
+// and the store span hangs under the request span
+func Test_RequestSpans(t *testing.T) {
+	sr := record(t)
+	app := newApp(t, db.StoreConfig{Type: db.StoreMemory})
+
+	assert.Equal(t, http.StatusNotFound, get(t, app, "/voters/7", "traceparent", traceparent).StatusCode)
+
+	spans := byName(sr)
+	server, ok := spans["GET "+api.VoterPath]
+	if !assert.True(t, ok, "no request span in %v", spans) {
+		return
+	}
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+	assert.Equal(t, traceID, server.SpanContext().TraceID().String())
+	assert.Equal(t, parentID, server.Parent().SpanID().String())
+	assert.True(t, server.Parent().IsRemote())
+	assert.Equal(t, api.VoterPath, attr(server, "http.route").AsString())
+	assert.Equal(t, int64(http.StatusNotFound), attr(server, "http.response.status_code").AsInt64())
+	assert.Equal(t, codes.Unset, server.Status().Code, "a 404 is not a server error")
+
+	store, ok := spans["store.GetVoter"]
+	if assert.True(t, ok) {
+		assert.Equal(t, server.SpanContext().SpanID(), store.Parent().SpanID())
+		assert.Equal(t, int64(7), attr(store, "voter.id").AsInt64())
+		assert.Equal(t, db.StoreMemory, attr(store, "voter.store").AsString())
+		assert.Equal(t, codes.Unset, store.Status().Code)
+		assert.Len(t, store.Events(), 1, "the not found is recorded")
+	}
+}
+
+func Test_NewTrace(t *testing.T) {
+	sr := record(t)
+	app := newApp(t, db.StoreConfig{Type: db.StoreMemory})
+
+	get(t, app, "/voters")
+	get(t, app, "/nowhere")
+
+	spans := byName(sr)
+	server, ok := spans["GET "+api.VotersPath]
+	if assert.True(t, ok) {
+		assert.False(t, server.Parent().IsValid())
+		assert.NotEqual(t, traceID, server.SpanContext().TraceID().String())
+	}
+	_, ok = spans["store.ListVoters"]
+	assert.True(t, ok)
+
+	//An unmatched request is one span name, not one per url
+	_, ok = spans["GET unmatched"]
+	assert.True(t, ok, "no unmatched span in %v", spans)
+}
+
+// Test_RedisSpans checks every redis command is a client span under the
+// store operation that sent it
+func Test_RedisSpans(t *testing.T) {
+	sr := record(t)
+	mr := miniredis.RunT(t)
+	app := newApp(t, db.StoreConfig{Type: db.StoreRedis, Redis: db.RedisConfig{Addr: mr.Addr()}})
+
+	assert.Equal(t, http.StatusOK, get(t, app, api.ReadyzPath).StatusCode)
+	spans := byName(sr)
+	ping, cmd := spans["store.Ping"], spans["PING"]
+	if assert.NotNil(t, ping) && assert.NotNil(t, cmd) {
+		assert.Equal(t, ping.SpanContext().SpanID(), cmd.Parent().SpanID())
+		assert.Equal(t, trace.SpanKindClient, cmd.SpanKind())
+		assert.Equal(t, "redis", attr(cmd, "db.system").AsString())
+		assert.Equal(t, "ping", attr(cmd, "db.operation.name").AsString())
+	}
+
+	//miniredis has no RedisJSON, which makes for a handy failing command
+	assert.Equal(t, http.StatusInternalServerError, get(t, app, "/voters/1").StatusCode)
+	spans = byName(sr)
+	getVoter, cmd := spans["store.GetVoter"], spans["JSON.GET"]
+	if assert.NotNil(t, getVoter) && assert.NotNil(t, cmd) {
+		assert.Equal(t, getVoter.SpanContext().SpanID(), cmd.Parent().SpanID())
+		assert.Equal(t, codes.Error, cmd.Status().Code)
+		assert.Equal(t, codes.Error, getVoter.Status().Code)
+		assert.Equal(t, codes.Error, spans["GET "+api.VoterPath].Status().Code)
+	}
+}
+
+// Test_LogTraceID checks log lines of a traced request carry its trace
+func Test_LogTraceID(t *testing.T) {
+	record(t)
+	var buf bytes.Buffer
+	l, err := logging.New(&buf, logging.LevelInfo, logging.FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := slog.Default()
+	slog.SetDefault(l)
+	t.Cleanup(func() { slog.SetDefault(old) })
+
+	app := newApp(t, db.StoreConfig{Type: db.StoreMemory})
+	get(t, app, "/voters", "traceparent", traceparent)
+
+	var line map[string]any
+	for _, s := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if strings.Contains(s, `"msg":"request"`) {
+			assert.Nil(t, json.Unmarshal([]byte(s), &line))
+		}
+	}
+	assert.Equal(t, traceID, line[logging.TraceIDKey])
+	assert.Len(t, line[logging.SpanIDKey], 16)
+	assert.NotEqual(t, parentID, line[logging.SpanIDKey], "the span is ours, not the caller's")
+}
+
+// spanIn starts and ends one span on tp, under parent if it is valid
+func spanIn(tp trace.TracerProvider, parent trace.SpanContext) {
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), parent)
+	_, span := tp.Tracer("test").Start(ctx, "work")
+	span.End()
+}
+
+func Test_Stdout(t *testing.T) {
+	var buf bytes.Buffer
+	tp, err := tracing.NewProvider(tracing.Config{Exporter: tracing.ExporterStdout, ServiceName: "voter-test", SampleRatio: 1}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spanIn(tp, trace.SpanContext{})
+	assert.Nil(t, tp.Shutdown(context.Background()))
+
+	var span struct {
+		Name     string
+		Resource []struct {
+			Key   string
+			Value struct{ Value any }
+		}
+	}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &span))
+	assert.Equal(t, "work", span.Name)
+	assert.Contains(t, span.Resource, struct {
+		Key   string
+		Value struct{ Value any }
+	}{"service.name", struct{ Value any }{"voter-test"}})
+}
+
+// Test_Sampling checks a ratio of 0 drops new traces but keeps the ones a
+// caller already sampled
+func Test_Sampling(t *testing.T) {
+	var buf bytes.Buffer
+	tp, err := tracing.NewProvider(tracing.Config{Exporter: tracing.ExporterStdout, ServiceName: "voter-test"}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spanIn(tp, trace.SpanContext{})
+	assert.Nil(t, tp.ForceFlush(context.Background()))
+	assert.Equal(t, 0, buf.Len())
+
+	tid, _ := trace.TraceIDFromHex(traceID)
+	sid, _ := trace.SpanIDFromHex(parentID)
+	spanIn(tp, trace.NewSpanContext(trace.SpanContextConfig{TraceID: tid, SpanID: sid, TraceFlags: trace.FlagsSampled, Remote: true}))
+	assert.Nil(t, tp.Shutdown(context.Background()))
+	assert.Contains(t, buf.String(), traceID)
+}
+
+// Test_OTLP sends to a fake collector
+func Test_OTLP(t *testing.T) {
+	var posts atomic.Int32
+	var contentType atomic.Value
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/v1/traces" {
+			posts.Add(1)
+			contentType.Store(r.Header.Get("Content-Type"))
+			io.Copy(io.Discard, r.Body)
+		}
+	}))
+	defer collector.Close()
+
+	tp, err := tracing.NewProvider(tracing.Config{
+		Exporter:    tracing.ExporterOTLP,
+		Endpoint:    collector.URL + "/v1/traces",
+		ServiceName: "voter-test",
+		SampleRatio: 1,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spanIn(tp, trace.SpanContext{})
+	assert.Nil(t, tp.Shutdown(context.Background()))
+	assert.Equal(t, int32(1), posts.Load())
+	assert.Equal(t, "application/x-protobuf", contentType.Load())
+
+	_, err = tracing.NewProvider(tracing.Config{Exporter: "jaeger"}, nil)
+	assert.NotNil(t, err)
+	tp, err = tracing.NewProvider(tracing.Config{Exporter: tracing.ExporterNone}, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, tp)
+}
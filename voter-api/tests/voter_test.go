@@ -10,7 +10,10 @@ import (
 	"testing"
 	"time"
 
+	"drexel.edu/todo/api"
+	"drexel.edu/todo/config"
 	"drexel.edu/todo/db"
+	"drexel.edu/todo/server"
 	fake "github.com/brianvoe/gofakeit/v6" //aliasing package name
 	"github.com/go-resty/resty/v2"
 	"github.com/stretchr/testify/assert"
@@ -58,13 +61,13 @@ func newRandVoter(id uint) db.Voter {
 func Test_LoadDB(t *testing.T) {
 	numLoad := 3
 	for i := 0; i < numLoad; i++ {
-		item := newRandVoter(uint(i))
+		item := newRandVoter(uint(i + 1))
 		rsp, err := cli.R().
 			SetBody(item).
 			Post(BASE_API + "/voters")
 
 		assert.Nil(t, err)
-		assert.Equal(t, 200, rsp.StatusCode())
+		assert.Equal(t, http.StatusCreated, rsp.StatusCode())
 	}
 }
 
@@ -76,14 +79,15 @@ func Test_Health(t *testing.T) {
 
 }
 func Test_GetAllVoters(t *testing.T) {
-	var items []db.Voter
+	var page api.VoterPageResponse
 
-	rsp, err := cli.R().SetResult(&items).Get(BASE_API + "/voters")
+	rsp, err := cli.R().SetResult(&page).Get(BASE_API + "/voters")
 
 	assert.Nil(t, err)
 	assert.Equal(t, 200, rsp.StatusCode())
 
-	assert.Equal(t, 3, len(items))
+	assert.Equal(t, 3, len(page.Voters))
+	assert.Equal(t, "/voters?limit=100", page.Links["self"].Href)
 }
 
 func Test_GetVoterByID(t *testing.T) {
@@ -276,7 +280,7 @@ func Test_DeleteVoter(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, 200, rsp.StatusCode(), "voters deleted expected")
 
-	rsp, err = cli.R().SetResult(item).Get(BASE_API + "/voters/2")
+	rsp, err = cli.R().SetResult(&item).Get(BASE_API + "/voters/2")
 	assert.Nil(t, err)
 	assert.Equal(t, 404, rsp.StatusCode(), "expected not found error code")
 }
@@ -296,3 +300,508 @@ func Test_DeleteAllVoters(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, 200, rsp.StatusCode(), "expected not found error code")
 }
+
+func Test_VoterLinks(t *testing.T) {
+	rsp, err := cli.R().
+		SetBody(newRandVoter(50)).
+		Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode())
+
+	type link struct {
+		Href string `json:"href"`
+	}
+	var voter struct {
+		VoteHistory []struct {
+			PollId uint            `json:"poll_id"`
+			Links  map[string]link `json:"_links"`
+		} `json:"vote_history"`
+		Links map[string]link `json:"_links"`
+	}
+
+	rsp, err = cli.R().SetResult(&voter).Get(BASE_API + "/voters/50")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	assert.Equal(t, "/voters/50", voter.Links["self"].Href)
+	assert.Equal(t, "/voters/50/polls", voter.Links["vote_history"].Href)
+	assert.Equal(t, "/votes/voterid/50", voter.Links["voter_responses"].Href)
+	assert.Equal(t, "/votes", voter.Links["make_vote"].Href)
+	assert.Equal(t, "/polls", voter.Links["all_polls"].Href)
+
+	assert.Equal(t, 1, len(voter.VoteHistory))
+	pollId := voter.VoteHistory[0].PollId
+	pollLinks := voter.VoteHistory[0].Links
+	assert.Equal(t, fmt.Sprintf("/voters/50/polls/%d", pollId), pollLinks["self"].Href)
+	assert.Equal(t, fmt.Sprintf("/votes/voterid/50/pollid/%d", pollId), pollLinks["vote_response"].Href)
+	assert.Equal(t, fmt.Sprintf("/polls/%d", pollId), pollLinks["poll_info"].Href)
+
+	rsp, err = cli.R().Delete(BASE_API + "/voters/50")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_ListVotersPaging(t *testing.T) {
+	for i := 60; i < 65; i++ {
+		item := newRandVoter(uint(i))
+		item.Name = fmt.Sprintf("Pager %d", 65-i)
+		rsp, err := cli.R().SetBody(item).Post(BASE_API + "/voters")
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusCreated, rsp.StatusCode())
+	}
+
+	//Follow the next links until there are none, every voter should show
+	//up exactly once.  The Link header has the same next link as the body
+	seen := map[uint]bool{}
+	next := BASE_API + "/voters?limit=2&name=pager&sort=voter_id"
+	for pages := 0; next != "" && pages < 10; pages++ {
+		var page api.VoterPageResponse
+		rsp, err := cli.R().SetResult(&page).Get(next)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode())
+		for _, v := range page.Voters {
+			assert.False(t, seen[v.VoterId], "voter returned twice")
+			seen[v.VoterId] = true
+		}
+
+		next = ""
+		if link, ok := page.Links["next"]; ok {
+			assert.Equal(t, fmt.Sprintf(`<%s>; rel="next"`, link.Href), rsp.Header().Get("Link"))
+			next = BASE_API + link.Href
+		}
+	}
+	assert.Equal(t, 5, len(seen))
+
+	var byName api.VoterPageResponse
+	rsp, err := cli.R().SetResult(&byName).Get(BASE_API + "/voters?name=pager&sort=name")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 5, len(byName.Voters))
+	assert.Equal(t, "Pager 1", byName.Voters[0].Name)
+
+	rsp, err = cli.R().Get(BASE_API + "/voters?sort=bogus")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode())
+
+	rsp, err = cli.R().Get(BASE_API + "/voters?limit=0")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode())
+
+	for i := 60; i < 65; i++ {
+		cli.R().Delete(fmt.Sprintf("%s/voters/%d", BASE_API, i))
+	}
+}
+
+func Test_SearchVoters(t *testing.T) {
+	item := newRandVoter(70)
+	item.Name = "Searchable Person"
+	item.Email = "searchable.person@example.com"
+	rsp, err := cli.R().SetBody(item).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode())
+
+	queries := []string{
+		"email=searchable.person@example.com",
+		"email=SEARCHABLE.person*",
+		"name=searchable",
+		"name=pers*",
+		"name=searchable&email=searchable*",
+	}
+	for _, q := range queries {
+		var items []db.Voter
+		rsp, err := cli.R().SetResult(&items).Get(BASE_API + "/voters/search?" + q)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, rsp.StatusCode(), q)
+		if assert.Equal(t, 1, len(items), q) {
+			assert.Equal(t, uint(70), items[0].VoterId)
+		}
+	}
+
+	var none []db.Voter
+	rsp, err = cli.R().SetResult(&none).Get(BASE_API + "/voters/search?email=searchable")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 0, len(none))
+
+	rsp, err = cli.R().Get(BASE_API + "/voters/search")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode())
+
+	cli.R().Delete(BASE_API + "/voters/70")
+}
+
+func Test_VoterETag(t *testing.T) {
+	item := newRandVoter(80)
+	rsp, err := cli.R().SetBody(item).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode())
+	assert.Equal(t, `"1"`, rsp.Header().Get("ETag"))
+
+	var got db.Voter
+	rsp, err = cli.R().SetResult(&got).Get(BASE_API + "/voters/80")
+	assert.Nil(t, err)
+	etag := rsp.Header().Get("ETag")
+	assert.Equal(t, fmt.Sprintf(`"%d"`, got.Revision), etag)
+
+	//Update with the current ETag works and moves the revision on
+	item.Name = "First Admin"
+	rsp, err = cli.R().SetBody(item).SetHeader("If-Match", etag).Put(BASE_API + "/voters/80")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	newEtag := rsp.Header().Get("ETag")
+	assert.NotEqual(t, etag, newEtag)
+
+	//A second admin still holding the old ETag is turned away
+	item.Name = "Second Admin"
+	rsp, err = cli.R().SetBody(item).SetHeader("If-Match", etag).Put(BASE_API + "/voters/80")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, rsp.StatusCode())
+
+	rsp, err = cli.R().SetBody(item.VoteHistory[0]).SetHeader("If-Match", etag).Put(BASE_API + "/voters/80/polls/81")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, rsp.StatusCode())
+
+	rsp, err = cli.R().SetHeader("If-Match", etag).Delete(BASE_API + "/voters/80/polls/81")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, rsp.StatusCode())
+
+	rsp, err = cli.R().SetHeader("If-Match", etag).Delete(BASE_API + "/voters/80")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, rsp.StatusCode())
+
+	rsp, err = cli.R().SetResult(&got).Get(BASE_API + "/voters/80")
+	assert.Nil(t, err)
+	assert.Equal(t, "First Admin", got.Name)
+
+	rsp, err = cli.R().SetHeader("If-Match", "not-an-etag").Delete(BASE_API + "/voters/80")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode())
+
+	rsp, err = cli.R().SetHeader("If-Match", newEtag).Delete(BASE_API + "/voters/80")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_PatchVoter(t *testing.T) {
+	item := newRandVoter(90)
+	rsp, err := cli.R().SetBody(item).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode())
+
+	//A merge patch only touches the fields it names, vote_history stays
+	var got db.Voter
+	rsp, err = cli.R().
+		SetHeader("Content-Type", "application/merge-patch+json").
+		SetBody(`{"email":"patched@example.com"}`).
+		SetResult(&got).
+		Patch(BASE_API + "/voters/90")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, "patched@example.com", got.Email)
+	assert.Equal(t, item.Name, got.Name)
+	assert.Equal(t, 1, len(got.VoteHistory))
+	etag := rsp.Header().Get("ETag")
+
+	rsp, err = cli.R().
+		SetHeader("Content-Type", "application/json-patch+json").
+		SetHeader("If-Match", etag).
+		SetBody(`[
+			{"op":"test","path":"/email","value":"patched@example.com"},
+			{"op":"replace","path":"/name","value":"Json Patched"},
+			{"op":"add","path":"/vote_history/-","value":{"poll_id":92,"vote_id":93,"vote_date":"2024-02-26T10:00:00Z"}}
+		]`).
+		SetResult(&got).
+		Patch(BASE_API + "/voters/90")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, "Json Patched", got.Name)
+	assert.Equal(t, "patched@example.com", got.Email)
+	assert.Equal(t, 2, len(got.VoteHistory))
+
+	//Stale ETag, failed test op, bad result and the wrong content type
+	rsp, err = cli.R().
+		SetHeader("Content-Type", "application/merge-patch+json").
+		SetHeader("If-Match", etag).
+		SetBody(`{"name":"Too Late"}`).
+		Patch(BASE_API + "/voters/90")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, rsp.StatusCode())
+
+	rsp, err = cli.R().
+		SetHeader("Content-Type", "application/json-patch+json").
+		SetBody(`[{"op":"test","path":"/name","value":"Someone Else"}]`).
+		Patch(BASE_API + "/voters/90")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusConflict, rsp.StatusCode())
+
+	for _, body := range []string{`{"voter_id":91}`, `{"name":5}`, `{"shoe_size":11}`} {
+		rsp, err = cli.R().
+			SetHeader("Content-Type", "application/merge-patch+json").
+			SetBody(body).
+			Patch(BASE_API + "/voters/90")
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusUnprocessableEntity, rsp.StatusCode(), body)
+	}
+
+	rsp, err = cli.R().
+		SetHeader("Content-Type", "application/json-patch+json").
+		SetBody(`{"not":"a list"}`).
+		Patch(BASE_API + "/voters/90")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode())
+
+	rsp, err = cli.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{"name":"Nope"}`).
+		Patch(BASE_API + "/voters/90")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rsp.StatusCode())
+
+	rsp, err = cli.R().SetResult(&got).Get(BASE_API + "/voters/90")
+	assert.Nil(t, err)
+	assert.Equal(t, "Json Patched", got.Name)
+
+	cli.R().Delete(BASE_API + "/voters/90")
+}
+
+func Test_VoterValidation(t *testing.T) {
+	type validation struct {
+		Message string `json:"message"`
+		Errors  []struct {
+			Field   string `json:"field"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	fields := func(v validation) []string {
+		var res []string
+		for _, e := range v.Errors {
+			res = append(res, e.Field)
+		}
+		return res
+	}
+
+	bad := []struct {
+		body   string
+		fields []string
+	}{
+		{`{"voter_id":0,"name":"","email":"not an email"}`, []string{"name", "email"}},
+		{`{"voter_id":100,"name":"Has Extra","email":"extra@example.com","shoe_size":11}`, []string{"shoe_size"}},
+		{`{"voter_id":"100","name":"Wrong Type","email":"wrong@example.com"}`, []string{"voter_id"}},
+		{`{"voter_id":100,"name":"Bad History","email":"bad@example.com","vote_history":[{"poll_id":1,"vote_id":1,"vote_date":"2024-02-26T10:00:00Z"},{"poll_id":1,"vote_id":0}]}`,
+			[]string{"vote_history[1].vote_id", "vote_history[1].vote_date", "vote_history[1].poll_id"}},
+	}
+	for _, b := range bad {
+		var v validation
+		rsp, err := cli.R().SetHeader("Content-Type", "application/json").SetBody(b.body).SetError(&v).Post(BASE_API + "/voters")
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusUnprocessableEntity, rsp.StatusCode(), b.body)
+		assert.ElementsMatch(t, b.fields, fields(v), b.body)
+	}
+
+	rsp, err := cli.R().SetHeader("Content-Type", "application/json").SetBody(`{"voter_id":`).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, rsp.StatusCode())
+
+	//A body voter_id that differs from the path is rejected, a missing one
+	//is taken from the path
+	item := newRandVoter(100)
+	rsp, err = cli.R().SetBody(item).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode())
+
+	var v validation
+	rsp, err = cli.R().SetBody(item).SetError(&v).Put(BASE_API + "/voters/101")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, rsp.StatusCode())
+	assert.Equal(t, []string{"voter_id"}, fields(v))
+
+	var got db.Voter
+	rsp, err = cli.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{"name":"No Id In Body","email":"noid@example.com"}`).
+		SetResult(&got).
+		Put(BASE_API + "/voters/100")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, uint(100), got.VoterId)
+
+	v = validation{}
+	rsp, err = cli.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{"poll_id":7,"vote_id":1,"vote_date":"2024-02-26T10:00:00Z"}`).
+		SetError(&v).
+		Put(BASE_API + "/voters/100/polls/8")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, rsp.StatusCode())
+	assert.Equal(t, []string{"poll_id"}, fields(v))
+
+	v = validation{}
+	rsp, err = cli.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(`{"poll_id":7}`).
+		SetError(&v).
+		Post(BASE_API + "/voters/100/polls")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, rsp.StatusCode())
+	assert.ElementsMatch(t, []string{"vote_id", "vote_date"}, fields(v))
+
+	v = validation{}
+	rsp, err = cli.R().
+		SetHeader("Content-Type", "application/merge-patch+json").
+		SetBody(`{"email":"nope"}`).
+		SetError(&v).
+		Patch(BASE_API + "/voters/100")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnprocessableEntity, rsp.StatusCode())
+	assert.Equal(t, []string{"email"}, fields(v))
+
+	cli.R().Delete(BASE_API + "/voters/100")
+}
+
+func Test_ProblemResponses(t *testing.T) {
+	type problem struct {
+		Type   string `json:"type"`
+		Title  string `json:"title"`
+		Status int    `json:"status"`
+		Code   string `json:"code"`
+	}
+	check := func(rsp *resty.Response, status int, code string) {
+		t.Helper()
+		assert.Equal(t, status, rsp.StatusCode())
+		assert.Equal(t, "application/problem+json", rsp.Header().Get("Content-Type"))
+
+		var p problem
+		assert.Nil(t, json.Unmarshal(rsp.Body(), &p))
+		assert.Equal(t, "about:blank", p.Type)
+		assert.Equal(t, http.StatusText(status), p.Title)
+		assert.Equal(t, status, p.Status)
+		assert.Equal(t, code, p.Code)
+	}
+
+	rsp, err := cli.R().Delete(BASE_API + "/voters/110")
+	assert.Nil(t, err)
+	check(rsp, http.StatusNotFound, "voter_not_found")
+
+	item := newRandVoter(110)
+	rsp, err = cli.R().SetBody(item).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode())
+
+	rsp, err = cli.R().SetBody(item).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	check(rsp, http.StatusConflict, "voter_exists")
+
+	rsp, err = cli.R().SetBody(item.VoteHistory[0]).Post(BASE_API + "/voters/110/polls")
+	assert.Nil(t, err)
+	check(rsp, http.StatusConflict, "voter_poll_exists")
+
+	rsp, err = cli.R().Get(BASE_API + "/voters/110/polls/999")
+	assert.Nil(t, err)
+	check(rsp, http.StatusNotFound, "voter_poll_not_found")
+
+	rsp, err = cli.R().SetHeader("If-Match", `"99"`).Delete(BASE_API + "/voters/110")
+	assert.Nil(t, err)
+	check(rsp, http.StatusPreconditionFailed, "revision_mismatch")
+
+	rsp, err = cli.R().SetHeader("Content-Type", "application/json").SetBody(`{"voter_id":`).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	check(rsp, http.StatusBadRequest, "malformed_body")
+
+	rsp, err = cli.R().SetHeader("Content-Type", "application/json").SetBody(`{"voter_id":0}`).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	check(rsp, http.StatusUnprocessableEntity, "validation_failed")
+
+	rsp, err = cli.R().Get(BASE_API + "/voters?limit=abc")
+	assert.Nil(t, err)
+	check(rsp, http.StatusBadRequest, "bad_request")
+
+	rsp, err = cli.R().Get(BASE_API + "/no/such/route")
+	assert.Nil(t, err)
+	check(rsp, http.StatusNotFound, "not_found")
+
+	cli.R().Delete(BASE_API + "/voters/110")
+}
+
+func Test_AssignedVoterId(t *testing.T) {
+	var first, second db.Voter
+	body := `{"name":"No Id","email":"noid@example.com"}`
+
+	rsp, err := cli.R().SetHeader("Content-Type", "application/json").SetBody(body).SetResult(&first).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode())
+	assert.NotZero(t, first.VoterId)
+	assert.Equal(t, fmt.Sprintf("/voters/%d", first.VoterId), rsp.Header().Get("Location"))
+
+	rsp, err = cli.R().SetHeader("Content-Type", "application/json").SetBody(body).SetResult(&second).Post(BASE_API + "/voters")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, rsp.StatusCode())
+	assert.NotEqual(t, first.VoterId, second.VoterId)
+
+	//The Location is where the voter lives
+	var got db.Voter
+	rsp, err = cli.R().SetResult(&got).Get(BASE_API + rsp.Header().Get("Location"))
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, second.VoterId, got.VoterId)
+
+	cli.R().Delete(fmt.Sprintf("%s/voters/%d", BASE_API, first.VoterId))
+	cli.R().Delete(fmt.Sprintf("%s/voters/%d", BASE_API, second.VoterId))
+}
+
+func Test_Metrics(t *testing.T) {
+	type health struct {
+		Requests uint64 `json:"users_processed"`
+		Errors   uint64 `json:"errors_encountered"`
+	}
+
+	var before, after health
+	rsp, err := cli.R().SetResult(&before).Get(BASE_API + "/voters/health")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	cli.R().Get(BASE_API + "/voters/120")
+	cli.R().Get(BASE_API + "/no/such/route")
+
+	//The health numbers count every request, including the health check
+	rsp, err = cli.R().SetResult(&after).Get(BASE_API + "/voters/health")
+	assert.Nil(t, err)
+	assert.Equal(t, before.Requests+3, after.Requests)
+
+	rsp, err = cli.R().Get(BASE_API + "/metrics")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	body := rsp.String()
+	assert.Contains(t, body, `voter_api_http_requests_total{class="4xx",method="GET",route="/voters/:id<int>"}`)
+	assert.Contains(t, body, `voter_api_http_requests_total{class="4xx",method="GET",route="unmatched"}`)
+	assert.Contains(t, body, `voter_api_http_request_duration_seconds_bucket{method="GET",route="/voters/health",le="0.005"}`)
+	assert.Contains(t, body, "voter_api_http_requests_in_flight 1")
+}
+
+func Test_Probes(t *testing.T) {
+	var live struct {
+		Status  string `json:"status"`
+		Version string `json:"version"`
+	}
+	rsp, err := cli.R().SetResult(&live).Get(BASE_API + "/livez")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, "ok", live.Status)
+	assert.NotEmpty(t, live.Version)
+
+	var ready api.ReadyResponse
+	rsp, err = cli.R().SetResult(&ready).Get(BASE_API + "/readyz")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, "ready", ready.Status)
+	assert.Equal(t, "up", ready.Checks["store"].Status)
+	assert.NotEmpty(t, ready.Checks["store"].Type)
+
+	//The healthcheck subcommand probes the same server
+	assert.Equal(t, 0, server.Run(config.Default(), []string{"healthcheck"}))
+	assert.Equal(t, 1, server.Run(config.Default(), []string{"healthcheck", "-path", "/no/such/route"}))
+
+	//The port comes from the same env vars and file as the server's
+	t.Setenv("VOTER_PORT", "1")
+	assert.Equal(t, 1, server.Run(config.Default(), []string{"healthcheck"}))
+	assert.Equal(t, 0, server.Run(config.Default(), []string{"healthcheck", "-p", "1080"}))
+}
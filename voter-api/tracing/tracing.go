@@ -0,0 +1,100 @@
+// Package tracing sets up the OpenTelemetry tracer the voter API traces
+// with.  The api package starts a span for every request, the db package
+// one for every store operation and every redis command, all through the
+// global tracer provider Setup installs
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters, as they are spelled in the config.  With none spans are not
+// even recorded, stdout writes them as JSON, one per line, and otlp sends
+// them to a collector over OTLP/HTTP
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// DefaultEndpoint is where a collector on the same host takes OTLP/HTTP
+const DefaultEndpoint = "http://localhost:4318/v1/traces"
+
+// ScopeName is the instrumentation scope of our spans
+const ScopeName = "drexel.edu/todo"
+
+// Config is the tracing settings.  SampleRatio is the share of new traces
+// recorded, a request that comes in with a sampled traceparent is always
+// recorded so the trace stays whole
+type Config struct {
+	Exporter    string
+	Endpoint    string
+	ServiceName string
+	SampleRatio float64
+}
+
+// NewProvider is the tracer provider of cfg.  The stdout exporter writes to
+// w.  Nil with ExporterNone, there is nothing to provide
+func NewProvider(cfg Config, w io.Writer) (*sdktrace.TracerProvider, error) {
+	var exp sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case ExporterNone:
+		return nil, nil
+	case ExporterStdout:
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	case ExporterOTLP:
+		exp, err = otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(),
+		resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	), nil
+}
+
+// Setup makes NewProvider's provider, writing to stdout, the global one.
+// The W3C traceparent propagator is installed whatever the exporter.  The
+// function returned flushes the spans not exported yet, call it on the way
+// out
+func Setup(cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	tp, err := NewProvider(cfg, os.Stdout)
+	if err != nil || tp == nil {
+		return func(context.Context) error { return nil }, err
+	}
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Tracer is our tracer from the global provider.  It is looked up on each
+// call, so spans go to whatever provider is installed at the time
+func Tracer() trace.Tracer {
+	return otel.Tracer(ScopeName)
+}
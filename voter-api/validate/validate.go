@@ -0,0 +1,112 @@
+// Package validate collects per-field errors for request payloads.  The
+// rule sets live next to the types they check, like db.Voter.Validate, this
+// package only has the plumbing they share
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrMalformed is a body that is not JSON at all, as opposed to JSON with
+// bad fields
+var ErrMalformed = errors.New("malformed JSON body")
+
+// FieldError is one problem with one field.  Field is the json name, with
+// a path for nested fields like vote_history[0].poll_id
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors is every problem found in a payload.  A rule set adds to it and
+// returns Err, which is nil when nothing was added
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Field+": "+fe.Message)
+	}
+	return "validation failed: " + strings.Join(msgs, ", ")
+}
+
+func (e *Errors) Add(field, message string) {
+	*e = append(*e, FieldError{Field: field, Message: message})
+}
+
+// Nest adds the field errors in err under prefix, so the rules for a
+// nested type don't need to know where it sits.  An empty prefix merges
+// them as they are
+func (e *Errors) Nest(prefix string, err error) {
+	var nested Errors
+	if !errors.As(err, &nested) {
+		if err != nil {
+			e.Add(prefix, err.Error())
+		}
+		return
+	}
+	for _, fe := range nested {
+		if prefix != "" {
+			fe.Field = prefix + "." + fe.Field
+		}
+		*e = append(*e, fe)
+	}
+}
+
+// Err returns e as an error, or nil if there are no errors
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Decode unmarshals body into v and rejects fields v does not have.  Bad
+// fields come back as Errors, anything that is not a single JSON value as
+// ErrMalformed
+func Decode(body []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if err == nil {
+		if _, err := dec.Token(); err != io.EOF {
+			return fmt.Errorf("%w: more than one JSON value", ErrMalformed)
+		}
+		return nil
+	}
+
+	var errs Errors
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr):
+		errs.Add(typeErr.Field, "must be a "+jsonType(typeErr.Type.Kind().String()))
+		return errs
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		//encoding/json has no type for this one, only the message
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		errs.Add(field, "unknown field")
+		return errs
+	default:
+		return fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+}
+
+// jsonType names a go kind the way a client sending JSON thinks of it
+func jsonType(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "number"
+	case kind == "slice", kind == "array":
+		return "list"
+	case kind == "struct", kind == "map":
+		return "object"
+	default:
+		return kind
+	}
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"drexel.edu/votes/db"
+	"github.com/gofiber/fiber/v2"
+)
+
+// The api package creates and maintains a reference to the data handler
+// and to the clients for the voter and polls services
+type VoteAPI struct {
+	db       db.VoteStore
+	voters   *VoterClient
+	polls    *PollClient
+	bootTime time.Time
+}
+
+// New sets up the store and the clients.  voterKey is the API key we send
+// to the voter API, empty when it runs without auth
+func New(storeType, voterURL, voterKey, pollsURL string) (*VoteAPI, error) {
+	dbHandler, err := db.NewVoteStore(storeType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VoteAPI{
+		db:       dbHandler,
+		voters:   NewVoterClient(voterURL, voterKey),
+		polls:    NewPollClient(pollsURL),
+		bootTime: time.Now(),
+	}, nil
+}
+
+func paramId(c *fiber.Ctx, name string) (uint, error) {
+	id, err := strconv.ParseUint(c.Params(name), 10, 64)
+	if err != nil {
+		return 0, fiber.NewError(http.StatusBadRequest)
+	}
+	return uint(id), nil
+}
+
+// dependencyError turns an error from one of the other services into
+// a 404 if the thing we looked for is missing, otherwise a 502
+func dependencyError(err error, what string) error {
+	if errors.Is(err, ErrNotFound) {
+		return fiber.NewError(http.StatusNotFound, what+" does not exist")
+	}
+	log.Println("Error calling "+what+" service: ", err)
+	return fiber.NewError(http.StatusBadGateway)
+}
+
+func (va *VoteAPI) ListAllVotes(c *fiber.Ctx) error {
+
+	voteList, err := va.db.GetAllVotes()
+	if err != nil {
+		log.Println("Error Getting All Votes: ", err)
+		return fiber.NewError(http.StatusNotFound,
+			"Error Getting All Votes")
+	}
+	if voteList == nil {
+		voteList = make([]db.Vote, 0)
+	}
+
+	return c.JSON(voteList)
+}
+
+func (va *VoteAPI) GetVote(c *fiber.Ctx) error {
+	id, err := paramId(c, "id")
+	if err != nil {
+		return err
+	}
+
+	vote, err := va.db.GetVote(id)
+	if err != nil {
+		log.Println("Vote not found: ", err)
+		return fiber.NewError(http.StatusNotFound)
+	}
+
+	return c.JSON(vote)
+}
+
+func (va *VoteAPI) GetVoterVotes(c *fiber.Ctx) error {
+	voterId, err := paramId(c, "voterid")
+	if err != nil {
+		return err
+	}
+
+	voteList, err := va.db.GetVoterVotes(voterId)
+	if err != nil {
+		log.Println("Error Getting Voter Votes: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+	if voteList == nil {
+		voteList = make([]db.Vote, 0)
+	}
+
+	return c.JSON(voteList)
+}
+
+func (va *VoteAPI) GetVoterPollVote(c *fiber.Ctx) error {
+	voterId, err := paramId(c, "voterid")
+	if err != nil {
+		return err
+	}
+	pollId, err := paramId(c, "pollid")
+	if err != nil {
+		return err
+	}
+
+	vote, err := va.db.GetVoterPollVote(voterId, pollId)
+	if err != nil {
+		log.Println("Vote not found: ", err)
+		return fiber.NewError(http.StatusNotFound)
+	}
+
+	return c.JSON(vote)
+}
+
+// implementation for POST /votes
+// The voter and the chosen poll option are checked against their own
+// services first.  Once the vote is stored we add the matching history
+// entry to the voter; if that fails the vote is removed again so the
+// votes and the voter's history never disagree
+func (va *VoteAPI) AddVote(c *fiber.Ctx) error {
+	var vote db.Vote
+
+	if err := c.BodyParser(&vote); err != nil {
+		log.Println("Error binding JSON: ", err)
+		return fiber.NewError(http.StatusBadRequest)
+	}
+	if err := vote.Validate(); err != nil {
+		return fiber.NewError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	if err := va.voters.VoterExists(c.UserContext(), vote.VoterId); err != nil {
+		return dependencyError(err, "voter")
+	}
+	if err := va.polls.PollOptionExists(c.UserContext(), vote.PollId, vote.VoteValue); err != nil {
+		return dependencyError(err, "poll option")
+	}
+
+	if err := va.db.AddVote(vote); err != nil {
+		log.Println("Error adding vote: ", err)
+		if errors.Is(err, db.ErrVoteExists) || errors.Is(err, db.ErrAlreadyVoted) {
+			return fiber.NewError(http.StatusConflict, err.Error())
+		}
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	history := voterHistory{
+		PollId:   vote.PollId,
+		VoteId:   vote.VoteId,
+		VoteDate: time.Now().UTC(),
+	}
+	if err := va.voters.AddVoterPoll(c.UserContext(), vote.VoterId, history); err != nil {
+		log.Println("Error writing voter history, removing vote: ", err)
+		if delErr := va.db.DeleteVote(vote.VoteId); delErr != nil {
+			log.Println("Error removing vote: ", delErr)
+		}
+		return fiber.NewError(http.StatusBadGateway)
+	}
+
+	return c.JSON(vote)
+}
+
+// implementation for DELETE /votes/:id
+// Removes the vote and the voter's history entry for the poll
+func (va *VoteAPI) DeleteVote(c *fiber.Ctx) error {
+	id, err := paramId(c, "id")
+	if err != nil {
+		return err
+	}
+
+	vote, err := va.db.GetVote(id)
+	if err != nil {
+		log.Println("Vote not found: ", err)
+		return fiber.NewError(http.StatusNotFound)
+	}
+
+	if err := va.voters.DeleteVoterPoll(c.UserContext(), vote.VoterId, vote.PollId); err != nil && !errors.Is(err, ErrNotFound) {
+		return dependencyError(err, "voter")
+	}
+
+	if err := va.db.DeleteVote(id); err != nil {
+		log.Println("Error deleting vote: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.Status(http.StatusOK).SendString("Delete OK")
+}
+
+func (va *VoteAPI) DeleteAllVotes(c *fiber.Ctx) error {
+
+	if err := va.db.DeleteAll(); err != nil {
+		log.Println("Error deleting all votes: ", err)
+		return fiber.NewError(http.StatusInternalServerError)
+	}
+
+	return c.Status(http.StatusOK).SendString("Delete All OK")
+}
+
+// implementation of GET /votes/health
+func (va *VoteAPI) HealthCheck(c *fiber.Ctx) error {
+	uptime := time.Since(va.bootTime)
+
+	return c.Status(http.StatusOK).
+		JSON(fiber.Map{
+			"status":  "ok",
+			"version": "1.0.0",
+			"uptime":  uptime.Seconds(),
+		})
+}
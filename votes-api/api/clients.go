@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// ErrNotFound is returned by the clients when the other service answers 404
+var ErrNotFound = errors.New("not found")
+
+// voterHistory mirrors db.VoterHistory in the voter API.  It is the entry
+// we write back to the voter after a vote is stored
+type voterHistory struct {
+	PollId   uint      `json:"poll_id"`
+	VoteId   uint      `json:"vote_id"`
+	VoteDate time.Time `json:"vote_date"`
+}
+
+// APIKeyHeader is where the voter API looks for an API key
+const APIKeyHeader = "X-API-Key"
+
+// injectTrace passes the trace of the request's context on in traceparent,
+// so the other service's spans join the caller's trace.  The context is
+// the one the handler passed to the client, see TraceContext
+func injectTrace(_ *resty.Client, r *resty.Request) error {
+	otel.GetTextMapPropagator().Inject(r.Context(), propagation.HeaderCarrier(r.Header))
+	return nil
+}
+
+// newClient is the resty client both clients use
+func newClient() *resty.Client {
+	return resty.New().
+		SetTimeout(5 * time.Second).
+		OnBeforeRequest(injectTrace)
+}
+
+// VoterClient talks to the voter API
+type VoterClient struct {
+	baseURL string
+	cli     *resty.Client
+}
+
+// NewVoterClient sends apiKey with every call once the voter API has auth
+// on.  Removing a vote deletes a poll history entry, which takes the admin
+// role, the rest only needs a clerk
+func NewVoterClient(baseURL, apiKey string) *VoterClient {
+	cli := newClient()
+	if apiKey != "" {
+		cli.SetHeader(APIKeyHeader, apiKey)
+	}
+	return &VoterClient{
+		baseURL: baseURL,
+		cli:     cli,
+	}
+}
+
+func checkResponse(rsp *resty.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	switch {
+	case rsp.StatusCode() == http.StatusNotFound:
+		return ErrNotFound
+	case rsp.IsError():
+		return fmt.Errorf("%s %s returned %d", rsp.Request.Method, rsp.Request.URL, rsp.StatusCode())
+	}
+	return nil
+}
+
+func (vc *VoterClient) VoterExists(ctx context.Context, voterId uint) error {
+	return checkResponse(vc.cli.R().
+		SetContext(ctx).
+		Get(fmt.Sprintf("%s/voters/%d", vc.baseURL, voterId)))
+}
+
+func (vc *VoterClient) AddVoterPoll(ctx context.Context, voterId uint, history voterHistory) error {
+	return checkResponse(vc.cli.R().
+		SetContext(ctx).
+		SetBody(history).
+		Post(fmt.Sprintf("%s/voters/%d/polls", vc.baseURL, voterId)))
+}
+
+func (vc *VoterClient) DeleteVoterPoll(ctx context.Context, voterId, pollId uint) error {
+	return checkResponse(vc.cli.R().
+		SetContext(ctx).
+		Delete(fmt.Sprintf("%s/voters/%d/polls/%d", vc.baseURL, voterId, pollId)))
+}
+
+// PollClient talks to the polls API
+type PollClient struct {
+	baseURL string
+	cli     *resty.Client
+}
+
+func NewPollClient(baseURL string) *PollClient {
+	return &PollClient{
+		baseURL: baseURL,
+		cli:     newClient(),
+	}
+}
+
+// PollOptionExists checks the poll exists and has an option with the
+// given id.  A missing poll and a missing option both come back as 404
+func (pc *PollClient) PollOptionExists(ctx context.Context, pollId, optionId uint) error {
+	return checkResponse(pc.cli.R().
+		SetContext(ctx).
+		Get(fmt.Sprintf("%s/polls/%d/options/%d", pc.baseURL, pollId, optionId)))
+}
+
+// TraceContext puts the trace of an incoming traceparent in the request's
+// user context, the clients pass it on from there.  We record no spans of
+// our own, so the voter and polls APIs' spans hang right under the caller's
+func TraceContext() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		headers := make(http.Header)
+		c.Request().Header.VisitAll(func(k, v []byte) {
+			headers.Add(string(k), string(v))
+		})
+		c.SetUserContext(otel.GetTextMapPropagator().Extract(c.UserContext(), propagation.HeaderCarrier(headers)))
+		return c.Next()
+	}
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	RedisNilError        = "redis: nil"
+	RedisDefaultLocation = "0.0.0.0:6379"
+	RedisKeyPrefix       = "vote:"
+
+	//voterpoll:<voter_id>:<poll_id> holds the vote_id a voter cast in a
+	//poll.  It is claimed with SETNX so a voter can only vote once per poll
+	RedisVoterPollPrefix = "voterpoll:"
+)
+
+type cache struct {
+	client  *redis.Client
+	context context.Context
+}
+
+// VoteCache is the redis backed VoteStore.  Votes are stored as RedisJSON
+// documents under vote:<id>
+type VoteCache struct {
+	cache
+}
+
+func NewVoteCache() (*VoteCache, error) {
+	redisUrl := os.Getenv("REDIS_URL")
+	if redisUrl == "" {
+		redisUrl = RedisDefaultLocation
+	}
+	return NewWithCacheInstance(redisUrl)
+}
+
+func NewWithCacheInstance(location string) (*VoteCache, error) {
+
+	client := redis.NewClient(&redis.Options{
+		Addr: location,
+	})
+
+	ctx := context.TODO()
+
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		log.Println("Error connecting to redis" + err.Error())
+		return nil, err
+	}
+
+	return &VoteCache{
+		cache: cache{
+			client:  client,
+			context: ctx,
+		},
+	}, nil
+}
+
+//------------------------------------------------------------
+// REDIS HELPERS
+//------------------------------------------------------------
+
+func isRedisNilError(err error) bool {
+	return errors.Is(err, redis.Nil) || err.Error() == RedisNilError
+}
+
+func redisKeyFromId(id uint) string {
+	return fmt.Sprintf("%s%d", RedisKeyPrefix, id)
+}
+
+func redisVoterPollKey(voterId, pollId uint) string {
+	return fmt.Sprintf("%s%d:%d", RedisVoterPollPrefix, voterId, pollId)
+}
+
+func (v *VoteCache) getAllKeys() ([]string, error) {
+	key := fmt.Sprintf("%s*", RedisKeyPrefix)
+	return v.client.Keys(v.context, key).Result()
+}
+
+func (v *VoteCache) getVoteFromRedis(key string, vote *Vote) error {
+	voteJson, err := v.client.JSONGet(v.context, key, ".").Result()
+	if err != nil {
+		if isRedisNilError(err) {
+			return ErrVoteNotFound
+		}
+		return err
+	}
+	if voteJson == "" {
+		return ErrVoteNotFound
+	}
+	return json.Unmarshal([]byte(voteJson), vote)
+}
+
+//------------------------------------------------------------
+// REDIS HELPERS-END
+//------------------------------------------------------------
+
+func (v *VoteCache) AddVote(vote Vote) error {
+	claimed, err := v.client.SetNX(v.context, redisVoterPollKey(vote.VoterId, vote.PollId), vote.VoteId, 0).Result()
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrAlreadyVoted
+	}
+
+	//NX answers nil rather than OK when the vote id is already taken, so
+	//two adds racing on one id can't both win
+	err = v.client.JSONSetMode(v.context, redisKeyFromId(vote.VoteId), "$", &vote, "NX").Err()
+	if err == nil {
+		return nil
+	}
+
+	//Release the claim so the voter can try again
+	if delErr := v.client.Del(v.context, redisVoterPollKey(vote.VoterId, vote.PollId)).Err(); delErr != nil {
+		log.Printf("Error releasing voterpoll claim for voter %d poll %d: %v", vote.VoterId, vote.PollId, delErr)
+	}
+	if isRedisNilError(err) {
+		return ErrVoteExists
+	}
+	return err
+}
+
+func (v *VoteCache) GetVote(id uint) (Vote, error) {
+	var vote Vote
+	if err := v.getVoteFromRedis(redisKeyFromId(id), &vote); err != nil {
+		return Vote{}, err
+	}
+	return vote, nil
+}
+
+func (v *VoteCache) GetAllVotes() ([]Vote, error) {
+	keyList, err := v.getAllKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	resList := make([]Vote, len(keyList))
+	for idx, k := range keyList {
+		if err := v.getVoteFromRedis(k, &resList[idx]); err != nil {
+			return nil, err
+		}
+	}
+
+	return resList, nil
+}
+
+func (v *VoteCache) GetVoterVotes(voterId uint) ([]Vote, error) {
+	allVotes, err := v.GetAllVotes()
+	if err != nil {
+		return nil, err
+	}
+
+	var voteList []Vote
+	for _, vote := range allVotes {
+		if vote.VoterId == voterId {
+			voteList = append(voteList, vote)
+		}
+	}
+	return voteList, nil
+}
+
+func (v *VoteCache) GetVoterPollVote(voterId, pollId uint) (Vote, error) {
+	voteId, err := v.client.Get(v.context, redisVoterPollKey(voterId, pollId)).Uint64()
+	if err != nil {
+		if isRedisNilError(err) {
+			return Vote{}, ErrVoteNotFound
+		}
+		return Vote{}, err
+	}
+	return v.GetVote(uint(voteId))
+}
+
+func (v *VoteCache) DeleteVote(id uint) error {
+	vote, err := v.GetVote(id)
+	if err != nil {
+		return err
+	}
+	return v.client.Del(v.context, redisKeyFromId(id), redisVoterPollKey(vote.VoterId, vote.PollId)).Err()
+}
+
+func (v *VoteCache) DeleteAll() error {
+	keyList, err := v.getAllKeys()
+	if err != nil {
+		return err
+	}
+	indexList, err := v.client.Keys(v.context, RedisVoterPollPrefix+"*").Result()
+	if err != nil {
+		return err
+	}
+
+	keyList = append(keyList, indexList...)
+	if len(keyList) == 0 {
+		return nil
+	}
+	return v.client.Del(v.context, keyList...).Err()
+}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Vote records which option (VoteValue is a poll_option_id) a voter picked
+// in a poll.  The voter's VoterHistory entry points back here by VoteId
+type Vote struct {
+	VoteId    uint `json:"vote_id"`
+	VoterId   uint `json:"voter_id"`
+	PollId    uint `json:"poll_id"`
+	VoteValue uint `json:"vote_value"`
+}
+
+// VoteStore is implemented by every backend that can hold votes
+type VoteStore interface {
+	AddVote(vote Vote) error
+	GetVote(id uint) (Vote, error)
+	GetAllVotes() ([]Vote, error)
+	GetVoterVotes(voterId uint) ([]Vote, error)
+	GetVoterPollVote(voterId, pollId uint) (Vote, error)
+	DeleteVote(id uint) error
+	DeleteAll() error
+}
+
+const (
+	StoreMemory = "memory"
+	StoreRedis  = "redis"
+)
+
+var (
+	ErrVoteExists   = errors.New("vote already exists")
+	ErrAlreadyVoted = errors.New("voter already voted in this poll")
+	ErrVoteNotFound = errors.New("vote does not exist")
+	ErrInvalidVote  = errors.New("invalid vote")
+)
+
+// Validate checks the ids a vote needs before we ask the other services
+// about it.  0 is never an id, the voter API turns down a history entry
+// with vote_id 0.  vote_value is up to the polls API
+func (v Vote) Validate() error {
+	var missing []string
+	if v.VoteId == 0 {
+		missing = append(missing, "vote_id")
+	}
+	if v.VoterId == 0 {
+		missing = append(missing, "voter_id")
+	}
+	if v.PollId == 0 {
+		missing = append(missing, "poll_id")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s must be greater than 0", ErrInvalidVote, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// NewVoteStore returns the backend named by storeType, either
+// StoreMemory or StoreRedis
+func NewVoteStore(storeType string) (VoteStore, error) {
+	switch storeType {
+	case StoreMemory, "":
+		return NewVoteList()
+	case StoreRedis:
+		return NewVoteCache()
+	default:
+		return nil, errors.New("unknown store type: " + storeType)
+	}
+}
+
+// VoteList is the in-memory VoteStore
+type VoteList struct {
+	mu    sync.RWMutex
+	Votes map[uint]Vote //A map of VoteIDs as keys and Vote structs as values
+}
+
+func NewVoteList() (*VoteList, error) {
+
+	voteList := &VoteList{
+		Votes: make(map[uint]Vote),
+	}
+
+	return voteList, nil
+}
+
+// AddVote checks both the vote id and the (voter, poll) pair under the
+// same lock, so two concurrent votes by one voter in one poll cannot
+// both get in
+func (v *VoteList) AddVote(vote Vote) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, ok := v.Votes[vote.VoteId]; ok {
+		return ErrVoteExists
+	}
+	for _, existing := range v.Votes {
+		if existing.VoterId == vote.VoterId && existing.PollId == vote.PollId {
+			return ErrAlreadyVoted
+		}
+	}
+
+	v.Votes[vote.VoteId] = vote
+	return nil
+}
+
+func (v *VoteList) GetVote(id uint) (Vote, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	vote, ok := v.Votes[id]
+	if !ok {
+		return Vote{}, ErrVoteNotFound
+	}
+
+	return vote, nil
+}
+
+func (v *VoteList) GetAllVotes() ([]Vote, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	var voteList []Vote
+	for _, vote := range v.Votes {
+		voteList = append(voteList, vote)
+	}
+
+	return voteList, nil
+}
+
+func (v *VoteList) GetVoterVotes(voterId uint) ([]Vote, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	var voteList []Vote
+	for _, vote := range v.Votes {
+		if vote.VoterId == voterId {
+			voteList = append(voteList, vote)
+		}
+	}
+
+	return voteList, nil
+}
+
+func (v *VoteList) GetVoterPollVote(voterId, pollId uint) (Vote, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	for _, vote := range v.Votes {
+		if vote.VoterId == voterId && vote.PollId == pollId {
+			return vote, nil
+		}
+	}
+
+	return Vote{}, ErrVoteNotFound
+}
+
+func (v *VoteList) DeleteVote(id uint) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, ok := v.Votes[id]; !ok {
+		return ErrVoteNotFound
+	}
+
+	delete(v.Votes, id)
+	return nil
+}
+
+func (v *VoteList) DeleteAll() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.Votes = make(map[uint]Vote)
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"drexel.edu/votes/api"
+	"drexel.edu/votes/db"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+var (
+	hostFlag     string
+	portFlag     uint
+	storeFlag    string
+	voterApiFlag string
+	voterKeyFlag string
+	pollsApiFlag string
+)
+
+// envOrDefault lets the service urls be set with env vars in a container
+func envOrDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func processCmdLineFlags() {
+
+	flag.StringVar(&hostFlag, "h", "0.0.0.0", "Listen on all interfaces")
+	flag.UintVar(&portFlag, "p", 1082, "Default Port")
+	flag.StringVar(&storeFlag, "store", db.StoreMemory, "Vote store to use, memory or redis")
+	flag.StringVar(&voterApiFlag, "voterapi", envOrDefault("VOTER_API_URL", "http://localhost:1080"), "Voter API base url")
+	flag.StringVar(&voterKeyFlag, "voterapikey", os.Getenv("VOTER_API_KEY"), "API key for the voter API, when it has auth on")
+	flag.StringVar(&pollsApiFlag, "pollsapi", envOrDefault("POLLS_API_URL", "http://localhost:1081"), "Polls API base url")
+
+	flag.Parse()
+}
+
+// main is the entry point for our votes API application.  It processes
+// the command line flags, picks a vote store and registers the routes
+func main() {
+	processCmdLineFlags()
+
+	//W3C traceparent, read from the requests and passed on to the voter
+	//and polls APIs
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	app := fiber.New()
+	app.Use(cors.New())
+	app.Use(recover.New())
+	app.Use(api.TraceContext())
+
+	apiHandler, err := api.New(storeFlag, voterApiFlag, voterKeyFlag, pollsApiFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	app.Get("/votes", apiHandler.ListAllVotes)
+	app.Post("/votes", apiHandler.AddVote)
+	app.Delete("/votes", apiHandler.DeleteAllVotes)
+	app.Get("/votes/:id<int>", apiHandler.GetVote)
+	app.Delete("/votes/:id<int>", apiHandler.DeleteVote)
+	app.Get("/votes/voterid/:voterid<int>", apiHandler.GetVoterVotes)
+	app.Get("/votes/voterid/:voterid<int>/pollid/:pollid<int>", apiHandler.GetVoterPollVote)
+
+	app.Get("/votes/health", apiHandler.HealthCheck)
+
+	serverPath := fmt.Sprintf("%s:%d", hostFlag, portFlag)
+	log.Println("Starting server on ", serverPath)
+	app.Listen(serverPath)
+}
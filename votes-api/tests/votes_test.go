@@ -0,0 +1,208 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"drexel.edu/votes/api"
+	"drexel.edu/votes/db"
+	"github.com/go-resty/resty/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// These tests need the voter API on 1080, the polls API on 1081 and the
+// votes API on 1082
+var (
+	BASE_API  = "http://localhost:1082"
+	VOTER_API = "http://localhost:1080"
+	POLLS_API = "http://localhost:1081"
+
+	cli = resty.New()
+)
+
+type voterHistory struct {
+	PollId uint `json:"poll_id"`
+	VoteId uint `json:"vote_id"`
+}
+
+func TestMain(m *testing.M) {
+
+	//SETUP GOES FIRST, start from empty stores with one voter and one poll
+	for _, url := range []string{BASE_API + "/votes", VOTER_API + "/voters", POLLS_API + "/polls"} {
+		rsp, err := cli.R().Delete(url)
+		if err != nil || rsp.StatusCode() != 200 {
+			log.Printf("error clearing %s, %v", url, err)
+			os.Exit(1)
+		}
+	}
+
+	cli.R().SetBody(map[string]any{
+		"voter_id": 1, "name": "John Doe", "email": "johnd@example.com",
+		"vote_history": []any{},
+	}).Post(VOTER_API + "/voters")
+	cli.R().SetBody(map[string]any{
+		"poll_id": 1, "poll_title": "Favorite Pet", "poll_question": "What type of pet do you like best?",
+		"poll_options": []map[string]any{
+			{"poll_option_id": 1, "poll_option_value": "Dog"},
+			{"poll_option_id": 2, "poll_option_value": "Cat"},
+		},
+	}).Post(POLLS_API + "/polls")
+
+	code := m.Run()
+
+	//Now Exit
+	os.Exit(code)
+}
+
+func Test_Health(t *testing.T) {
+	rsp, err := cli.R().Get(BASE_API + "/votes/health")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+}
+
+func Test_AddVote(t *testing.T) {
+	vote := db.Vote{VoteId: 10, VoterId: 1, PollId: 1, VoteValue: 2}
+
+	rsp, err := cli.R().SetBody(vote).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	//The voter history should now have the matching entry
+	var history voterHistory
+	rsp, err = cli.R().SetResult(&history).Get(VOTER_API + "/voters/1/polls/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, vote.VoteId, history.VoteId)
+
+	var got db.Vote
+	rsp, err = cli.R().SetResult(&got).Get(BASE_API + "/votes/voterid/1/pollid/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, vote, got)
+}
+
+func Test_AddVoteTwice(t *testing.T) {
+	vote := db.Vote{VoteId: 11, VoterId: 1, PollId: 1, VoteValue: 1}
+
+	rsp, err := cli.R().SetBody(vote).Post(BASE_API + "/votes")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusConflict, rsp.StatusCode())
+}
+
+func Test_AddVoteUnknownReferences(t *testing.T) {
+	badVotes := []db.Vote{
+		{VoteId: 20, VoterId: 99, PollId: 1, VoteValue: 1}, //no such voter
+		{VoteId: 21, VoterId: 1, PollId: 99, VoteValue: 1}, //no such poll
+		{VoteId: 22, VoterId: 1, PollId: 1, VoteValue: 99}, //no such option
+	}
+
+	for _, vote := range badVotes {
+		rsp, err := cli.R().SetBody(vote).Post(BASE_API + "/votes")
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusNotFound, rsp.StatusCode(), fmt.Sprintf("%+v", vote))
+
+		rsp, err = cli.R().Get(fmt.Sprintf("%s/votes/%d", BASE_API, vote.VoteId))
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusNotFound, rsp.StatusCode())
+	}
+}
+
+func Test_AddVoteInvalid(t *testing.T) {
+	badVotes := []db.Vote{
+		{VoteId: 0, VoterId: 1, PollId: 1, VoteValue: 1},
+		{VoteId: 23, VoterId: 0, PollId: 1, VoteValue: 1},
+		{VoteId: 24, VoterId: 1, PollId: 0, VoteValue: 1},
+	}
+
+	for _, vote := range badVotes {
+		rsp, err := cli.R().SetBody(vote).Post(BASE_API + "/votes")
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusUnprocessableEntity, rsp.StatusCode(), fmt.Sprintf("%+v", vote))
+	}
+}
+
+func Test_GetVoterVotes(t *testing.T) {
+	var votes []db.Vote
+	rsp, err := cli.R().SetResult(&votes).Get(BASE_API + "/votes/voterid/1")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+	assert.Equal(t, 1, len(votes))
+}
+
+func Test_DeleteVote(t *testing.T) {
+	rsp, err := cli.R().Delete(BASE_API + "/votes/10")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, rsp.StatusCode())
+
+	rsp, err = cli.R().Get(BASE_API + "/votes/10")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode())
+
+	rsp, err = cli.R().Get(VOTER_API + "/voters/1/polls/1")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, rsp.StatusCode())
+}
+
+// Test_VoterClientKey checks the voter client sends its API key, against a
+// stand-in for the voter API
+func Test_VoterClientKey(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(api.APIKeyHeader)
+	}))
+	defer srv.Close()
+
+	assert.Nil(t, api.NewVoterClient(srv.URL, "votes-service-key").VoterExists(context.Background(), 1))
+	assert.Equal(t, "votes-service-key", got)
+
+	assert.Nil(t, api.NewVoterClient(srv.URL, "").VoterExists(context.Background(), 1))
+	assert.Equal(t, "", got)
+}
+
+// Test_Traceparent checks the traceparent a vote came in with goes out
+// again on the calls to the voter and polls APIs
+func Test_Traceparent(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	old := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(old) })
+
+	got := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get("traceparent")
+	}))
+	defer srv.Close()
+	voters := api.NewVoterClient(srv.URL, "")
+	polls := api.NewPollClient(srv.URL)
+
+	//A handler behind TraceContext calls both services with its context
+	app := fiber.New()
+	app.Use(api.TraceContext())
+	app.Get("/", func(c *fiber.Ctx) error {
+		if err := voters.VoterExists(c.UserContext(), 1); err != nil {
+			return err
+		}
+		return polls.PollOptionExists(c.UserContext(), 1, 1)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("traceparent", traceparent)
+	rsp, err := app.Test(req, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rsp.StatusCode)
+	assert.Equal(t, traceparent, <-got, "voter API call")
+	assert.Equal(t, traceparent, <-got, "polls API call")
+
+	//Without one there is nothing to pass on
+	assert.Nil(t, voters.VoterExists(context.Background(), 1))
+	assert.Equal(t, "", <-got)
+}